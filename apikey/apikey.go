@@ -0,0 +1,247 @@
+// Package apikey provides the authentication of the clients with API keys, shared by all the
+// routers, and the per-key quotas
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/proxy"
+	"github.com/ph0m1/porta/ratelimit"
+)
+
+var (
+	// ErrMissingKey is returned when the request has no API key
+	ErrMissingKey = errors.New("apikey: missing API key")
+	// ErrInvalidKey is returned when the API key of the request is unknown
+	ErrInvalidKey = errors.New("apikey: invalid API key")
+	// ErrForbidden is returned when the API key is not allowed to consume the endpoint
+	ErrForbidden = errors.New("apikey: the API key is not allowed to consume the endpoint")
+	// ErrQuotaExceeded is returned when the API key exceeds its rate limit
+	ErrQuotaExceeded = errors.New("apikey: quota exceeded")
+)
+
+// Key is an API key known by the store
+type Key struct {
+	// identity of the client owning the key
+	Client string
+
+	endpoints map[string]struct{}
+	limiter   *ratelimit.TokenBucket
+}
+
+// allows reports whether the key is allowed to consume the endpoint
+func (k *Key) allows(endpoint *config.EndpointConfig) bool {
+	if len(k.endpoints) == 0 {
+		return true
+	}
+	path := normalizePath(endpoint.Endpoint)
+	_, anyMethod := k.endpoints[path]
+	_, method := k.endpoints[endpoint.Method+" "+path]
+	return anyMethod || method
+}
+
+// Store holds the API keys of a service. The keys are stored by their hash, so looking them up
+// does not leak their content through timing
+type Store struct {
+	cfg  *config.APIKeysConfig
+	keys map[[sha256.Size]byte]*Key
+}
+
+// New returns the store with the keys defined by the received config and its keys file, or nil
+// when there is no config
+func New(cfg *config.APIKeysConfig) (*Store, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	keys := cfg.Keys
+	if cfg.KeysFile != "" {
+		fileKeys, err := loadKeysFile(cfg.KeysFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(fileKeys, keys...)
+	}
+	s := &Store{cfg: cfg, keys: make(map[[sha256.Size]byte]*Key, len(keys))}
+	for _, k := range keys {
+		hash := sha256.Sum256([]byte(k.Key))
+		if _, ok := s.keys[hash]; ok || k.Key == "" {
+			return nil, fmt.Errorf("apikey: empty or duplicated key of the client %s", k.Client)
+		}
+		key := &Key{Client: k.Client, endpoints: make(map[string]struct{}, len(k.Endpoints))}
+		for _, e := range k.Endpoints {
+			if method, path, ok := strings.Cut(strings.TrimSpace(e), " "); ok {
+				e = strings.ToUpper(method) + " " + normalizePath(strings.TrimSpace(path))
+			} else {
+				e = normalizePath(e)
+			}
+			key.endpoints[e] = struct{}{}
+		}
+		if k.RateLimit > 0 {
+			key.limiter = ratelimit.NewTokenBucket(k.RateLimit, k.Burst)
+		}
+		s.keys[hash] = key
+	}
+	return s, nil
+}
+
+// Stores holds the stores of the API keys configs, so the endpoints sharing a config share the
+// quotas of its keys
+type Stores map[*config.APIKeysConfig]*Store
+
+// Get returns the store of the received config, creating it the first time
+func (s Stores) Get(cfg *config.APIKeysConfig) (*Store, error) {
+	if store, ok := s[cfg]; ok {
+		return store, nil
+	}
+	store, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s[cfg] = store
+	return store, nil
+}
+
+type fileKey struct {
+	Key       string   `json:"key"`
+	Client    string   `json:"client"`
+	Endpoints []string `json:"endpoints"`
+	RateLimit float64  `json:"rate_limit"`
+	Burst     int      `json:"burst"`
+}
+
+func loadKeysFile(path string) ([]config.APIKeyConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []fileKey
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("apikey: parsing the keys file %s: %w", path, err)
+	}
+	keys := make([]config.APIKeyConfig, len(list))
+	for i, k := range list {
+		keys[i] = config.APIKeyConfig(k)
+	}
+	return keys, nil
+}
+
+// Authorize returns the key of the request when it is allowed to consume the endpoint. The quota
+// of the key is only consumed by the authorized requests. When the quota is exceeded, the returned
+// duration is the time to wait before retrying
+func (s *Store) Authorize(r *http.Request, endpoint *config.EndpointConfig) (*Key, time.Duration, error) {
+	value := r.Header.Get(s.cfg.Header)
+	if value == "" && s.cfg.QueryParam != "" {
+		value = r.URL.Query().Get(s.cfg.QueryParam)
+	}
+	if value == "" {
+		return nil, 0, ErrMissingKey
+	}
+	key, ok := s.keys[sha256.Sum256([]byte(value))]
+	if !ok {
+		return nil, 0, ErrInvalidKey
+	}
+	if !key.allows(endpoint) {
+		return nil, 0, ErrForbidden
+	}
+	if key.limiter != nil {
+		if ok, wait := key.limiter.Allow(); !ok {
+			return nil, wait, ErrQuotaExceeded
+		}
+	}
+	return key, 0, nil
+}
+
+// Handler returns a handler authorizing the requests to the endpoint before passing them to the
+// next handler, with the key in their context. The requests without a valid key are rejected with
+// a 401 status code, the ones with a key not allowed to consume the endpoint with a 403 status code
+// and the ones exceeding the quota of their key with a 429 status code and a Retry-After header
+func (s *Store) Handler(endpoint *config.EndpointConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, wait, err := s.Authorize(r, endpoint)
+		if err != nil {
+			if wait > 0 {
+				w.Header().Set("Retry-After", RetryAfter(wait))
+			}
+			http.Error(w, err.Error(), Status(err))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), key)))
+	})
+}
+
+// Status returns the status code of the responses to the requests rejected with the received error
+func Status(err error) int {
+	switch {
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrQuotaExceeded):
+		return http.StatusTooManyRequests
+	}
+	return http.StatusUnauthorized
+}
+
+// RetryAfter returns the value of the Retry-After header for the received wait, in whole seconds
+func RetryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of the context carrying the key
+func NewContext(ctx context.Context, key *Key) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// FromContext returns the key carried by the context, if any
+func FromContext(ctx context.Context) (*Key, bool) {
+	key, ok := ctx.Value(contextKey{}).(*Key)
+	return key, ok
+}
+
+// NewMiddleware returns a proxy middleware forwarding the client of the key carried by the context
+// in the client header defined by the config. The header sent by the client with the same name is
+// always replaced, so the backends can trust it
+func NewMiddleware(cfg *config.APIKeysConfig) proxy.Middleware {
+	return func(next ...proxy.Proxy) proxy.Proxy {
+		if len(next) > 1 {
+			panic(proxy.ErrTooManyProxies)
+		}
+		if cfg == nil || cfg.ClientHeader == "" {
+			return next[0]
+		}
+		return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+			r := request.Clone()
+			r.Headers = make(map[string][]string, len(request.Headers)+1)
+			for k, v := range request.Headers {
+				r.Headers[k] = v
+			}
+			delete(r.Headers, cfg.ClientHeader)
+			if key, ok := FromContext(ctx); ok && key.Client != "" {
+				r.Headers[cfg.ClientHeader] = []string{key.Client}
+			}
+			return next[0](ctx, &r)
+		}
+	}
+}
+
+// colonParamPattern matches the colon style params of the paths (/:user)
+var colonParamPattern = regexp.MustCompile(`/:([^/]+)`)
+
+// normalizePath returns the path with its params in the brackets style, so the endpoints of the
+// keys match the paths of the endpoints with both styles
+func normalizePath(path string) string {
+	path = "/" + strings.TrimPrefix(path, "/")
+	return colonParamPattern.ReplaceAllString(path, "/{$1}")
+}
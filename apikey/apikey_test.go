@@ -0,0 +1,140 @@
+package apikey
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/proxy"
+)
+
+func newStore(t *testing.T) *Store {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	keys := `[{"key":"file-key","client":"tupu","endpoints":["GET /users/{id}"],"rate_limit":1,"burst":2}]`
+	if err := os.WriteFile(path, []byte(keys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(&config.APIKeysConfig{
+		Keys:       []config.APIKeyConfig{{Key: "supu-key", Client: "supu"}},
+		KeysFile:   path,
+		Header:     "X-Api-Key",
+		QueryParam: "api_key",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStore_Authorize(t *testing.T) {
+	s := newStore(t)
+	get := &config.EndpointConfig{Endpoint: "/users/:id", Method: "GET"}
+	del := &config.EndpointConfig{Endpoint: "/users/:id", Method: "DELETE"}
+
+	for i, tc := range []struct {
+		header, query string
+		endpoint      *config.EndpointConfig
+		client        string
+		err           error
+	}{
+		{"", "", get, "", ErrMissingKey},
+		{"unknown", "", get, "", ErrInvalidKey},
+		{"supu-key", "", del, "supu", nil},
+		{"", "supu-key", get, "supu", nil},
+		{"file-key", "", del, "", ErrForbidden},
+		{"file-key", "", get, "tupu", nil},
+		{"", "file-key", get, "tupu", nil},
+		{"file-key", "", get, "", ErrQuotaExceeded},
+		{"supu-key", "", get, "supu", nil},
+	} {
+		r := httptest.NewRequest("GET", "/users/1?api_key="+tc.query, nil)
+		if tc.header != "" {
+			r.Header.Set("X-Api-Key", tc.header)
+		}
+		key, wait, err := s.Authorize(r, tc.endpoint)
+		if err != tc.err {
+			t.Errorf("#%d: want %v, have %v", i, tc.err, err)
+			continue
+		}
+		if err == ErrQuotaExceeded && wait <= 0 {
+			t.Errorf("#%d: unexpected wait %s", i, wait)
+		}
+		if err == nil && key.Client != tc.client {
+			t.Errorf("#%d: want %s, have %s", i, tc.client, key.Client)
+		}
+	}
+}
+
+func TestStore_Handler(t *testing.T) {
+	s := newStore(t)
+	handler := s.Handler(&config.EndpointConfig{Endpoint: "/users/{id}", Method: "GET"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, _ := FromContext(r.Context())
+		fmt.Fprint(w, key.Client)
+	}))
+
+	for _, tc := range []struct {
+		key        string
+		status     int
+		retryAfter string
+	}{
+		{"", http.StatusUnauthorized, ""},
+		{"file-key", http.StatusOK, ""},
+		{"file-key", http.StatusOK, ""},
+		{"file-key", http.StatusTooManyRequests, "1"},
+	} {
+		r := httptest.NewRequest("GET", "/users/1", nil)
+		r.Header.Set("X-Api-Key", tc.key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.status || w.Header().Get("Retry-After") != tc.retryAfter {
+			t.Errorf("%s: unexpected response %d %v", tc.key, w.Code, w.Header())
+		}
+		if w.Code == http.StatusOK && w.Body.String() != "tupu" {
+			t.Errorf("unexpected body: %s", w.Body.String())
+		}
+	}
+}
+
+func TestNew_ko(t *testing.T) {
+	for _, cfg := range []*config.APIKeysConfig{
+		{KeysFile: "/nowhere/keys.json"},
+		{Keys: []config.APIKeyConfig{{Key: "supu"}, {Key: "supu"}}},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("error expected with the config %+v", cfg)
+		}
+	}
+	stores := Stores{}
+	cfg := &config.APIKeysConfig{Keys: []config.APIKeyConfig{{Key: "supu"}}}
+	if a, _ := stores.Get(cfg); a == nil {
+		t.Error("the store was not created")
+	} else if b, _ := stores.Get(cfg); a != b {
+		t.Error("the endpoints sharing a config should share the store")
+	}
+	if s, err := stores.Get(nil); s != nil || err != nil {
+		t.Errorf("unexpected store without config: %v %v", s, err)
+	}
+}
+
+func TestNewMiddleware(t *testing.T) {
+	var received *proxy.Request
+	p := NewMiddleware(&config.APIKeysConfig{ClientHeader: "X-Client"})(func(_ context.Context, r *proxy.Request) (*proxy.Response, error) {
+		received = r
+		return nil, nil
+	})
+	request := &proxy.Request{Headers: map[string][]string{"X-Client": {"spoofed"}}}
+
+	p(NewContext(context.Background(), &Key{Client: "supu"}), request)
+	if v := fmt.Sprint(received.Headers["X-Client"]); v != "[supu]" {
+		t.Errorf("unexpected client header: %s", v)
+	}
+	p(context.Background(), request)
+	if _, ok := received.Headers["X-Client"]; ok {
+		t.Errorf("the spoofed header was forwarded: %v", received.Headers)
+	}
+}
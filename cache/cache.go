@@ -0,0 +1,60 @@
+// Package cache provides the stores of the responses cached by the gateway
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// Store keeps the encoded responses by their cache key. The stores are shared by the concurrent
+// requests to an endpoint, so they must be safe for concurrent use. The errors of the remote stores
+// are not reported: a failed get is a miss and a failed set is a response not cached
+type Store interface {
+	// Get returns the value of the key, if it is stored and not expired
+	Get(ctx context.Context, key string) ([]byte, bool)
+	// Set stores the value of the key for the ttl
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration)
+}
+
+// StoreBuilder creates the store defined by a cache config
+type StoreBuilder func(cfg *config.CacheConfig) (Store, error)
+
+var (
+	storesMu sync.RWMutex
+	stores   = map[string]StoreBuilder{
+		config.CacheMemoryStore: func(cfg *config.CacheConfig) (Store, error) {
+			return NewMemoryStore(cfg.MaxEntries, cfg.MaxSize), nil
+		},
+	}
+)
+
+// Register makes a store available by the provided name, so it can be selected from the store
+// field of the cache config. Registering an already registered name replaces it
+func Register(name string, sb StoreBuilder) {
+	storesMu.Lock()
+	stores[strings.ToLower(name)] = sb
+	storesMu.Unlock()
+}
+
+// New returns the store defined by the received config, or nil when there is no config
+func New(cfg *config.CacheConfig) (Store, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	name := strings.ToLower(cfg.Store)
+	if name == "" {
+		name = config.CacheMemoryStore
+	}
+	storesMu.RLock()
+	sb, ok := stores[name]
+	storesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("cache: unknown store: %s", cfg.Store)
+	}
+	return sb(cfg)
+}
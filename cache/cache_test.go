@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := NewMemoryStore(2, 0)
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	s.Set(ctx, "a", []byte("supu"), time.Minute)
+	s.Set(ctx, "b", []byte("tupu"), time.Second)
+	if v, ok := s.Get(ctx, "a"); !ok || string(v) != "supu" {
+		t.Errorf("unexpected value: %s %v", v, ok)
+	}
+	// b is the least recently used one
+	s.Set(ctx, "c", []byte("foo"), time.Minute)
+	if _, ok := s.Get(ctx, "b"); ok {
+		t.Error("the least recently used value was not evicted")
+	}
+	if s.Len() != 2 {
+		t.Errorf("unexpected number of values: %d", s.Len())
+	}
+
+	s.Set(ctx, "a", []byte("bar"), time.Second)
+	if v, ok := s.Get(ctx, "a"); !ok || string(v) != "bar" {
+		t.Errorf("the value was not replaced: %s %v", v, ok)
+	}
+	now = now.Add(time.Second)
+	if _, ok := s.Get(ctx, "a"); ok {
+		t.Error("the expired value was returned")
+	}
+	if _, ok := s.Get(ctx, "c"); !ok || s.Len() != 1 {
+		t.Errorf("unexpected values: %d", s.Len())
+	}
+}
+
+func TestMemoryStore_maxSize(t *testing.T) {
+	s := NewMemoryStore(0, 10)
+	ctx := context.Background()
+
+	s.Set(ctx, "too-big", []byte("supu"), time.Minute)
+	if s.Len() != 0 {
+		t.Error("the value bigger than the max size was stored")
+	}
+	for i := 0; i < 3; i++ {
+		s.Set(ctx, fmt.Sprint(i), []byte("supu"), time.Minute)
+	}
+	if _, ok := s.Get(ctx, "0"); ok || s.Len() != 2 {
+		t.Errorf("unexpected number of values: %d", s.Len())
+	}
+	if s.size != 10 {
+		t.Errorf("unexpected size: %d", s.size)
+	}
+}
+
+type dummyStore struct{}
+
+func (dummyStore) Get(_ context.Context, _ string) ([]byte, bool)             { return nil, false }
+func (dummyStore) Set(_ context.Context, _ string, _ []byte, _ time.Duration) {}
+
+func TestNew(t *testing.T) {
+	if s, err := New(nil); s != nil || err != nil {
+		t.Errorf("unexpected store without config: %v %v", s, err)
+	}
+	if s, err := New(&config.CacheConfig{MaxEntries: 10}); err != nil {
+		t.Error(err)
+	} else if m, ok := s.(*MemoryStore); !ok || m.maxEntries != 10 {
+		t.Errorf("unexpected store: %v", s)
+	}
+	if _, err := New(&config.CacheConfig{Store: "dummy"}); err == nil {
+		t.Error("error expected with an unknown store")
+	}
+
+	errDummy := errors.New("dummy")
+	Register("Dummy", func(cfg *config.CacheConfig) (Store, error) {
+		if cfg.StoreConfig["fail"] == true {
+			return nil, errDummy
+		}
+		return dummyStore{}, nil
+	})
+	if s, err := New(&config.CacheConfig{Store: "dummy"}); err != nil || s != (dummyStore{}) {
+		t.Errorf("unexpected store: %v %v", s, err)
+	}
+	if _, err := New(&config.CacheConfig{Store: "dummy", StoreConfig: map[string]interface{}{"fail": true}}); err != errDummy {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-process store evicting the least recently used values when it exceeds its
+// max number of entries or its max size. The expired values are removed when they are read or
+// evicted
+type MemoryStore struct {
+	maxEntries int
+	maxSize    int64
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List
+	now     func() time.Time
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func (e *memoryEntry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+// NewMemoryStore returns an empty store keeping up to maxEntries values with up to maxSize bytes,
+// counting their keys. A limit is not applied when it is not positive
+func NewMemoryStore(maxEntries int, maxSize int64) *MemoryStore {
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		maxSize:    maxSize,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+// Get implements the Store interface
+func (s *MemoryStore) Get(_ context.Context, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*memoryEntry)
+	if !s.now().Before(e.expires) {
+		s.remove(el)
+		return nil, false
+	}
+	s.lru.MoveToFront(el)
+	return e.value, true
+}
+
+// Set implements the Store interface. The values bigger than the max size are not stored
+func (s *MemoryStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) {
+	e := &memoryEntry{key: key, value: value, expires: s.now().Add(ttl)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		s.remove(el)
+	}
+	if s.maxSize > 0 && e.size() > s.maxSize {
+		return
+	}
+	s.entries[key] = s.lru.PushFront(e)
+	s.size += e.size()
+	for (s.maxEntries > 0 && s.lru.Len() > s.maxEntries) || (s.maxSize > 0 && s.size > s.maxSize) {
+		s.remove(s.lru.Back())
+	}
+}
+
+// Len returns the number of stored values, including the expired ones not removed yet
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
+
+func (s *MemoryStore) remove(el *list.Element) {
+	e := s.lru.Remove(el).(*memoryEntry)
+	delete(s.entries, e.key)
+	s.size -= e.size()
+}
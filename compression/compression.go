@@ -0,0 +1,209 @@
+// Package compression provides the content codings supported by the gateway, used to decompress
+// the backend responses and to compress the responses sent to the clients
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// Gzip is the name of the gzip content coding
+	Gzip = "gzip"
+	// Deflate is the name of the deflate content coding
+	Deflate = "deflate"
+	// Brotli is the name of the brotli content coding
+	Brotli = "br"
+	// Zstd is the name of the zstandard content coding
+	Zstd = "zstd"
+	// Identity is the name of the content coding leaving the content untouched
+	Identity = "identity"
+)
+
+// ErrUnsupported is returned when there is no codec registered with the requested name
+var ErrUnsupported = errors.New("unsupported content coding")
+
+// ReaderFactory returns a reader decompressing the content of r
+type ReaderFactory func(r io.Reader) (io.ReadCloser, error)
+
+// WriterFactory returns a writer compressing into w with the received level. Zero means the
+// default level of the codec
+type WriterFactory func(w io.Writer, level int) (io.WriteCloser, error)
+
+type codec struct {
+	reader ReaderFactory
+	writer WriterFactory
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]codec{
+		Gzip:    {gzipReader, gzipWriter},
+		Deflate: {deflateReader, deflateWriter},
+		Brotli:  {brotliReader, brotliWriter},
+		Zstd:    {zstdReader, zstdWriter},
+	}
+)
+
+// Register makes a content coding available by the provided name. Registering an already
+// registered name replaces it
+func Register(name string, rf ReaderFactory, wf WriterFactory) {
+	codecsMu.Lock()
+	codecs[strings.ToLower(name)] = codec{rf, wf}
+	codecsMu.Unlock()
+}
+
+// Supported reports if there is a codec registered with the received name
+func Supported(name string) bool {
+	codecsMu.RLock()
+	_, ok := codecs[strings.ToLower(name)]
+	codecsMu.RUnlock()
+	return ok
+}
+
+// NewReader returns a reader decompressing r with the received content coding. The identity
+// coding returns the content untouched
+func NewReader(name string, r io.Reader) (io.ReadCloser, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || name == Identity {
+		return io.NopCloser(r), nil
+	}
+	codecsMu.RLock()
+	c, ok := codecs[name]
+	codecsMu.RUnlock()
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return c.reader(r)
+}
+
+// NewWriter returns a writer compressing into w with the received content coding
+func NewWriter(name string, w io.Writer, level int) (io.WriteCloser, error) {
+	codecsMu.RLock()
+	c, ok := codecs[strings.ToLower(name)]
+	codecsMu.RUnlock()
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return c.writer(w, level)
+}
+
+// Compress returns the content compressed with the received content coding
+func Compress(name string, content []byte, level int) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(name, buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(content); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Negotiate returns the first of the offered content codings accepted by the received
+// Accept-Encoding header value, honouring its quality factors. It returns an empty string when
+// the content should not be compressed
+func Negotiate(acceptEncoding string, offered []string) string {
+	accepted := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					q = v
+				}
+			}
+		}
+		accepted[name] = q
+	}
+
+	type candidate struct {
+		name string
+		q    float64
+	}
+	candidates := []candidate{}
+	for _, name := range offered {
+		q, ok := accepted[name]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > 0 {
+			candidates = append(candidates, candidate{name, q})
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].name
+}
+
+func gzipReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func gzipWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+// the deflate content coding is the zlib format (RFC 1950), not the raw deflate one
+
+func deflateReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+func deflateWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == 0 {
+		level = zlib.DefaultCompression
+	}
+	return zlib.NewWriterLevel(w, level)
+}
+
+func brotliReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(brotli.NewReader(r)), nil
+}
+
+func brotliWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == 0 {
+		level = brotli.DefaultCompression
+	}
+	return brotli.NewWriterLevel(w, level), nil
+}
+
+func zstdReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+func zstdWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == 0 {
+		return zstd.NewWriter(w)
+	}
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+}
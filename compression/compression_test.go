@@ -0,0 +1,124 @@
+package compression
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	content := []byte(strings.Repeat(`{"supu":"tupu"}`, 100))
+	for _, name := range []string{Gzip, Deflate, Brotli, Zstd} {
+		for _, level := range []int{0, 1} {
+			compressed, err := Compress(name, content, level)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+			if len(compressed) >= len(content) {
+				t.Errorf("%s: the content was not compressed", name)
+			}
+			r, err := NewReader(strings.ToUpper(name), bytes.NewReader(compressed))
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+			b, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+			if !bytes.Equal(b, content) {
+				t.Errorf("%s: unexpected content: %s", name, b)
+			}
+		}
+	}
+}
+
+func TestDeflate_zlib(t *testing.T) {
+	// "supu" compressed with the zlib format (RFC 1950) of the deflate content coding
+	body := []byte{0x78, 0x9c, 0x2b, 0x2e, 0x2d, 0x28, 0x05, 0x00, 0x04, 0x84, 0x01, 0xce}
+	r, err := NewReader(Deflate, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(r); err != nil || string(b) != "supu" {
+		t.Errorf("unexpected content: %s %v", b, err)
+	}
+
+	compressed, err := Compress(Deflate, []byte("supu"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(zr); err != nil || string(b) != "supu" {
+		t.Errorf("unexpected content: %s %v", b, err)
+	}
+}
+
+func TestNewReader(t *testing.T) {
+	for _, name := range []string{"", Identity} {
+		r, err := NewReader(name, strings.NewReader("supu"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := io.ReadAll(r); string(b) != "supu" {
+			t.Errorf("%s: unexpected content: %s", name, b)
+		}
+	}
+	if _, err := NewReader("compress", strings.NewReader("supu")); err != ErrUnsupported {
+		t.Errorf("want %v, have %v", ErrUnsupported, err)
+	}
+	if _, err := NewWriter("compress", io.Discard, 0); err != ErrUnsupported {
+		t.Errorf("want %v, have %v", ErrUnsupported, err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	if Supported("nop") {
+		t.Fatal("unexpected codec")
+	}
+	Register("NOP", func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(r), nil
+	}, func(w io.Writer, _ int) (io.WriteCloser, error) {
+		return nopWriteCloser{w}, nil
+	})
+	if !Supported("nop") {
+		t.Fatal("the codec was not registered")
+	}
+	b, err := Compress("nop", []byte("supu"), 0)
+	if err != nil || string(b) != "supu" {
+		t.Errorf("unexpected result: %s, %v", b, err)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestNegotiate(t *testing.T) {
+	offered := []string{Brotli, Zstd, Gzip}
+	for accept, want := range map[string]string{
+		"":                             "",
+		"identity":                     "",
+		"gzip":                         Gzip,
+		"gzip, deflate, br":            Brotli,
+		"GZIP;q=1.0, br;q=0.5":         Gzip,
+		"zstd;q=0.8, gzip;q=0.8":       Zstd,
+		"*":                            Brotli,
+		"*;q=0.5, br;q=0, gzip;q=0.9":  Gzip,
+		"br;q=0, zstd;q=0, gzip;q=0.0": "",
+		"deflate":                      "",
+	} {
+		if have := Negotiate(accept, offered); have != want {
+			t.Errorf("%q: want %q, have %q", accept, want, have)
+		}
+	}
+}
@@ -1,13 +1,16 @@
 package config
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log"
+	"net/textproto"
 	"regexp"
 	"strings"
 	"time"
 
+	"github.com/ph0m1/porta/compression"
 	"github.com/ph0m1/porta/encoding"
 )
 
@@ -17,11 +20,16 @@ const (
 )
 
 const (
-	GET    string = "GET"
-	POST   string = "POST"
-	PUT    string = "PUT"
-	DELETE string = "DELETE"
-	NONE   string = ""
+	GET     string = "GET"
+	HEAD    string = "HEAD"
+	POST    string = "POST"
+	PUT     string = "PUT"
+	PATCH   string = "PATCH"
+	DELETE  string = "DELETE"
+	OPTIONS string = "OPTIONS"
+	CONNECT string = "CONNECT"
+	TRACE   string = "TRACE"
+	NONE    string = ""
 )
 
 var RoutingPattern = ColonRouterPatternBuilder
@@ -42,6 +50,22 @@ type ServiceConfig struct {
 	Port int `mapstructure:"port"`
 	// version code of the configuration
 	Version int `mapstructure:"version"`
+	// name of the local zone. The backend host tiers with this name are preferred over the rest
+	Zone string `mapstructure:"zone"`
+	// default max size in bytes of the request bodies. Unlimited when zero
+	MaxRequestSize int64 `mapstructure:"max_request_size"`
+	// default max size in bytes of the backend responses. Unlimited when zero
+	MaxResponseSize int64 `mapstructure:"max_response_size"`
+	// default compression of the responses sent to the clients
+	Compression CompressionConfig `mapstructure:"compression"`
+	// TLS settings of the listener. The service listens on plain HTTP when nil
+	TLS *ServerTLSConfig `mapstructure:"tls"`
+	// accept HTTP/2 without TLS (h2c) on the plain HTTP listener
+	H2C bool `mapstructure:"h2c"`
+	// default cross-origin resource sharing policy of the endpoints
+	CORS *CORSConfig `mapstructure:"cors"`
+	// API keys accepted by the endpoints requiring one
+	APIKeys *APIKeysConfig `mapstructure:"api_keys"`
 
 	// run in Debug Mode
 	Debug bool
@@ -63,6 +87,29 @@ type EndpointConfig struct {
 	CacheTTL time.Duration `mapstructure:"cache_ttl"`
 	// list of query string params to be extracted from the URI
 	QueryString []string `mapstructure:"querystring_params"`
+	// list of headers to be passed from the client request to the backends
+	HeadersToPass []string `mapstructure:"headers_to_pass"`
+	// the encoding of the responses (json by default, xml, yaml, msgpack, any other registered in the
+	// encoding package, negotiate, selecting the encoder from the Accept header of the request, or
+	// no-op, passing the response of the single backend to the client untouched)
+	OutputEncoding string `mapstructure:"output_encoding"`
+	// max size in bytes of the request bodies. Bigger requests are rejected with a 413 status code
+	MaxRequestSize int64 `mapstructure:"max_request_size"`
+	// compression of the responses sent to the clients
+	Compression CompressionConfig `mapstructure:"compression"`
+	// cross-origin resource sharing policy of the endpoint
+	CORS *CORSConfig `mapstructure:"cors"`
+	// validation of the JSON web tokens required by the endpoint. The endpoint is public when nil
+	JWT *JWTConfig `mapstructure:"jwt"`
+	// require one of the API keys defined by the service
+	APIKey bool `mapstructure:"api_key"`
+	// rate limit of the requests to the endpoint. Unlimited when nil
+	RateLimit *RateLimitConfig `mapstructure:"rate_limit"`
+	// in-process cache of the responses of the endpoint, kept for its cache TTL. Not cached when nil
+	Cache *CacheConfig `mapstructure:"cache"`
+
+	// API keys of the service, set when the endpoint requires one
+	APIKeys *APIKeysConfig
 }
 
 // Backend defines how to connect to the backend service and how to process the received response
@@ -73,6 +120,13 @@ type Backend struct {
 	Method string `mapstructure:"method"`
 	// Set of hosts of the API
 	Host []string `mapstructure:"host"`
+	// Set of hosts of the API grouped by priority. The hosts of a tier are only used when the
+	// previous tiers have no healthy hosts. Incompatible with the host list
+	HostTiers []HostTier `mapstructure:"host_tiers"`
+	// name of the service discovery subscriber providing the hosts. Defaults to the static host list
+	SD string `mapstructure:"sd"`
+	// settings of the service discovery subscriber
+	SDConfig map[string]interface{} `mapstructure:"sd_config"`
 	// URL pattern to use to locate the resource to be consumed
 	URLPattern string `mapstructure:"url_pattern"`
 	// set of response fields to remove
@@ -81,10 +135,36 @@ type Backend struct {
 	Whitelist []string `mapstructure:"whitelist"`
 	// map of response fields to renamed and their new names
 	Mapping map[string]string `mapstructure:"mapping"`
-	// the encoding format
+	// the encoding format (json by default, xml, yaml, toml, csv, form, any other registered in the
+	// encoding package, auto, selecting the decoder from the Content-Type of the response, or no-op,
+	// streaming the response of the single backend of the endpoint to the client untouched)
 	Encoding string `mapstructure:"encoding"`
+	// delimiter of the fields of the responses decoded with the csv encoding (comma by default)
+	CSVDelimiter string `mapstructure:"csv_delimiter"`
 	// name of the field to extract to the root
 	Target string `mapstructure:"target"`
+	// load balancing settings for the set of hosts
+	LB LBConfig `mapstructure:"lb"`
+	// protocol of the backend (http by default, grpc or any other registered in the proxy package)
+	Type string `mapstructure:"type"`
+	// settings of the grpc backends
+	GRPC GRPCConfig `mapstructure:"grpc"`
+	// settings of the graphql backends
+	GraphQL GraphQLConfig `mapstructure:"graphql"`
+	// max size in bytes of the responses. Bigger responses are rejected with a 502 status code
+	MaxResponseSize int64 `mapstructure:"max_response_size"`
+	// max nesting level of the decoded responses. Deeper responses are rejected with a 502 status code
+	MaxDepth int `mapstructure:"max_depth"`
+	// content codings accepted from the backend (gzip, deflate, br, zstd), in order of preference.
+	// The compressed responses are decompressed before decoding them, so the no-op backends, streaming
+	// the responses untouched, do not support them
+	AcceptEncoding []string `mapstructure:"accept_encoding"`
+	// settings of the dedicated http client of the backend. The default http client is shared by
+	// the backends without it
+	HTTPClient *HTTPClientConfig `mapstructure:"http_client"`
+	// rate limit of the requests sent to the backend, protecting it. Every concurrent call takes a
+	// token, and the calls over the limit are not sent. Unlimited when nil
+	RateLimit *RateLimitConfig `mapstructure:"rate_limit"`
 
 	// list of keys to be replaced in the URLPattern
 	URLKeys []string
@@ -96,6 +176,332 @@ type Backend struct {
 	Decoder encoding.Decoder
 }
 
+// HostTier defines a group of hosts sharing the same priority, like the ones deployed in a zone
+type HostTier struct {
+	// name of the tier or zone
+	Name string `mapstructure:"name"`
+	// set of hosts of the tier
+	Host []string `mapstructure:"host"`
+}
+
+// LBConfig defines how the requests are distributed across the hosts of a backend
+type LBConfig struct {
+	// name of the balancing strategy (round_robin, random, weighted_round_robin, least_connections,
+	// p2c_ewma, consistent_hash or any other strategy registered in the proxy package)
+	Strategy string `mapstructure:"strategy"`
+	// seed for the randomized strategies. A time based seed is used when zero
+	Seed int64 `mapstructure:"seed"`
+	// relative weight of each host, in the same order as the host list. Only supported by the
+	// backends with a static host list, as the other hosts are not known in advance
+	Weights []int `mapstructure:"weights"`
+	// request attribute used as key by the hashing strategies, with the format source:name where the
+	// source is one of param, header or query (ie: "param:user", "header:X-User-Id", "query:user")
+	HashKey string `mapstructure:"hash_key"`
+	// number of consecutive failures marking a host of a tier as unhealthy
+	MaxFails int `mapstructure:"max_fails"`
+	// time an unhealthy host of a tier is kept out of the rotation
+	FailTimeout time.Duration `mapstructure:"fail_timeout"`
+}
+
+// HTTPClientConfig defines the transport of the http client used to consume a backend. The zero
+// values keep the settings of the default transport
+type HTTPClientConfig struct {
+	// max number of idle connections across all the hosts
+	MaxIdleConns int `mapstructure:"max_idle_conns"`
+	// max number of idle connections kept per host
+	MaxIdleConnsPerHost int `mapstructure:"max_idle_conns_per_host"`
+	// max number of connections per host, including the active ones. Unlimited when zero
+	MaxConnsPerHost int `mapstructure:"max_conns_per_host"`
+	// time an idle connection is kept in the pool
+	IdleConnTimeout time.Duration `mapstructure:"idle_conn_timeout"`
+	// max time to establish a connection
+	DialTimeout time.Duration `mapstructure:"dial_timeout"`
+	// interval between the keep-alive probes of the active connections
+	KeepAlive time.Duration `mapstructure:"keep_alive"`
+	// max time to complete the TLS handshake
+	TLSHandshakeTimeout time.Duration `mapstructure:"tls_handshake_timeout"`
+	// max time to wait for the response headers after sending the request
+	ResponseHeaderTimeout time.Duration `mapstructure:"response_header_timeout"`
+	// do not reuse the connections
+	DisableKeepAlives bool `mapstructure:"disable_keep_alives"`
+	// do not request gzip responses transparently
+	DisableCompression bool `mapstructure:"disable_compression"`
+	// do not negotiate HTTP/2 with the backends
+	DisableHTTP2 bool `mapstructure:"disable_http2"`
+	// TLS settings of the connections to the backend
+	TLS *ClientTLSConfig `mapstructure:"tls"`
+}
+
+// ClientTLSConfig defines the TLS settings of the connections to a backend. The files are reloaded
+// when they change on disk, so the certificates can be rotated without restarting the service
+type ClientTLSConfig struct {
+	// files with the PEM encoded certificates of the CAs trusted to verify the backends, instead of
+	// the ones of the system
+	CACerts []string `mapstructure:"ca_certs"`
+	// file with the PEM encoded client certificate presented to the backends requiring mutual TLS
+	ClientCert string `mapstructure:"client_cert"`
+	// file with the PEM encoded key of the client certificate
+	ClientKey string `mapstructure:"client_key"`
+	// name used to verify the certificate of the backends, instead of their host
+	ServerName string `mapstructure:"server_name"`
+	// min TLS version (1.0, 1.1, 1.2 or 1.3). 1.2 when empty
+	MinVersion string `mapstructure:"min_version"`
+	// do not verify the certificate of the backends. For development purposes only
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"`
+}
+
+// CORSConfig defines the cross-origin resource sharing policy of the endpoints
+type CORSConfig struct {
+	// origins allowed to consume the endpoints. The * wildcard matches any origin or a part of one
+	// (ie: https://*.example.com)
+	AllowOrigins []string `mapstructure:"allow_origins"`
+	// methods allowed by the preflight requests. The methods of the endpoints sharing the path when
+	// empty
+	AllowMethods []string `mapstructure:"allow_methods"`
+	// request headers allowed by the preflight requests. The * wildcard allows any header
+	AllowHeaders []string `mapstructure:"allow_headers"`
+	// response headers exposed to the browser scripts
+	ExposeHeaders []string `mapstructure:"expose_headers"`
+	// allow the requests with credentials (cookies, authorization headers or client certificates).
+	// Incompatible with the * origin, as any site could read the responses of its users
+	AllowCredentials bool `mapstructure:"allow_credentials"`
+	// time the browsers can cache the result of a preflight request
+	MaxAge time.Duration `mapstructure:"max_age"`
+}
+
+// JWTConfig defines how the JSON web tokens sent as bearer tokens in the Authorization header are
+// validated and which of their claims are forwarded to the backends
+type JWTConfig struct {
+	// signing algorithms accepted (HS256, HS384, HS512, RS256, RS384, RS512, PS256, PS384, PS512,
+	// ES256, ES384, ES512 or EdDSA). Any of them matching the type of the key when empty
+	Algorithms []string `mapstructure:"algorithms"`
+	// static keys verifying the signatures
+	Keys []JWTKeyConfig `mapstructure:"keys"`
+	// http or https URL of the JWK set verifying the signatures. Only its public keys are used
+	JWKSURL string `mapstructure:"jwks_url"`
+	// file containing the JWK set verifying the signatures. Only its public keys are used
+	JWKSFile string `mapstructure:"jwks_file"`
+	// time the JWK set is cached before loading it again. 15 minutes when zero
+	JWKSCacheDuration time.Duration `mapstructure:"jwks_cache_duration"`
+	// required value of the iss claim. Not checked when empty
+	Issuer string `mapstructure:"issuer"`
+	// values accepted in the aud claim. Not checked when empty
+	Audience []string `mapstructure:"audience"`
+	// clock skew tolerated when checking the exp and nbf claims
+	Leeway time.Duration `mapstructure:"leeway"`
+	// roles allowed to consume the endpoint. The tokens without any of them are rejected with a 403
+	// status code. Not checked when empty
+	Roles []string `mapstructure:"roles"`
+	// dotted path of the claim holding the roles, as a list or a space separated string. roles when
+	// empty
+	RolesKey string `mapstructure:"roles_key"`
+	// claims forwarded to the backends as headers, by dotted claim path
+	ClaimsToHeaders map[string]string `mapstructure:"claims_to_headers"`
+	// claims forwarded to the backends as params, by dotted claim path. The params can be used in
+	// the url pattern of the backends like the ones of the endpoint path
+	ClaimsToParams map[string]string `mapstructure:"claims_to_params"`
+	// claims forwarded to the backends as query string params, by dotted claim path
+	ClaimsToQuery map[string]string `mapstructure:"claims_to_query"`
+}
+
+// JWTKeyConfig defines a static key verifying the signatures of the JSON web tokens. Either the
+// secret or the public key file must be set
+type JWTKeyConfig struct {
+	// id of the key, matched with the kid header of the tokens. The key verifies any token when empty
+	ID string `mapstructure:"kid"`
+	// shared secret of the HMAC algorithms
+	Secret string `mapstructure:"secret"`
+	// file with the PEM encoded public key or certificate of the RSA, ECDSA and EdDSA algorithms
+	PublicKeyFile string `mapstructure:"public_key_file"`
+}
+
+// RateLimitConfig defines the token buckets limiting the rate of the requests. The requests over
+// the limits are rejected with a 429 status code
+type RateLimitConfig struct {
+	// max number of requests per second, shared by all the clients. Unlimited when zero
+	MaxRate float64 `mapstructure:"max_rate"`
+	// max number of requests in a burst, shared by all the clients. The max rate, rounded up, when
+	// zero
+	Capacity int `mapstructure:"capacity"`
+	// max number of requests per second of every client. Unlimited when zero
+	ClientMaxRate float64 `mapstructure:"client_max_rate"`
+	// max number of requests in a burst of every client. The client max rate, rounded up, when zero
+	ClientCapacity int `mapstructure:"client_capacity"`
+	// how the clients are identified: ip, by their IP address (default), or header:name, by the
+	// value of a header (ie: "header:X-Client-Id"). The backends only see the headers passed to them
+	// and the client IP in the X-Forwarded-For header
+	Key string `mapstructure:"key"`
+}
+
+const (
+	RateLimitKeyIP     = "ip"
+	RateLimitKeyHeader = "header"
+)
+
+// KeySource returns the source identifying the clients and, for the headers, the name of the header
+func (r RateLimitConfig) KeySource() (source, name string) {
+	if r.Key == "" {
+		return RateLimitKeyIP, ""
+	}
+	parts := strings.SplitN(r.Key, ":", 2)
+	if len(parts) != 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// CacheConfig defines the cache of the complete responses of an endpoint. The responses are cached
+// by the method and path of the endpoint and the params, query string params and headers of the
+// requests to the backends
+type CacheConfig struct {
+	// name of the store of the responses: memory (default) or any other registered in the cache
+	// package
+	Store string `mapstructure:"store"`
+	// settings of the store
+	StoreConfig map[string]interface{} `mapstructure:"store_config"`
+	// max number of responses kept by the memory store. The least recently used ones are evicted
+	// when it is full
+	MaxEntries int `mapstructure:"max_entries"`
+	// max size in bytes of the responses kept by the memory store. Unlimited when zero
+	MaxSize int64 `mapstructure:"max_size"`
+	// params included in the cache key. All of them when empty
+	Params []string `mapstructure:"params"`
+	// query string params included in the cache key. All the ones passed to the backends when empty
+	QueryString []string `mapstructure:"querystring_params"`
+	// headers included in the cache key. All the ones passed to the backends, but the
+	// X-Forwarded-For and User-Agent ones, when empty
+	Headers []string `mapstructure:"headers"`
+}
+
+const (
+	// CacheMemoryStore is the name of the in-process store of the cached responses
+	CacheMemoryStore = "memory"
+	// DefaultCacheMaxEntries is the max number of responses kept by the memory store when there is
+	// no size limit
+	DefaultCacheMaxEntries = 1024
+)
+
+// APIKeysConfig defines the API keys accepted by the endpoints requiring one and where the requests
+// carry them
+type APIKeysConfig struct {
+	// keys accepted
+	Keys []APIKeyConfig `mapstructure:"keys"`
+	// JSON file containing a list of keys, with the same fields as the keys of the config
+	KeysFile string `mapstructure:"keys_file"`
+	// header carrying the key. X-Api-Key when empty
+	Header string `mapstructure:"header"`
+	// query string param carrying the key, when the header is missing. The keys are only accepted
+	// in the header when empty
+	QueryParam string `mapstructure:"query_param"`
+	// header forwarding the client of the key to the backends. Not forwarded when empty
+	ClientHeader string `mapstructure:"client_header"`
+}
+
+// APIKeyConfig defines an API key, the client it identifies and what it is allowed to do
+type APIKeyConfig struct {
+	// the key sent by the client
+	Key string `mapstructure:"key"`
+	// identity of the client
+	Client string `mapstructure:"client"`
+	// paths of the endpoints allowed, optionally prefixed by their method (ie: "GET /users/{id}").
+	// Every endpoint requiring a key is allowed when empty
+	Endpoints []string `mapstructure:"endpoints"`
+	// max number of requests per second of the key, across all the endpoints. Unlimited when zero
+	RateLimit float64 `mapstructure:"rate_limit"`
+	// max number of requests of the key in a burst. The rate limit, rounded up, when zero
+	Burst int `mapstructure:"burst"`
+}
+
+// ServerTLSConfig defines the TLS settings of the listener of the service
+type ServerTLSConfig struct {
+	// key pairs of the listener. The certificate is selected with the server name sent by the
+	// clients (SNI), falling back to the first one
+	Certificates []KeyPairConfig `mapstructure:"certificates"`
+	// min TLS version (1.0, 1.1, 1.2 or 1.3). 1.2 when empty
+	MinVersion string `mapstructure:"min_version"`
+	// names of the cipher suites allowed for the versions up to TLS 1.2, as defined by the
+	// crypto/tls package (ie: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256). The secure ones when empty
+	CipherSuites []string `mapstructure:"cipher_suites"`
+	// files with the PEM encoded certificates of the CAs verifying the client certificates
+	ClientCAs []string `mapstructure:"client_cas"`
+	// client certificate policy (none, request, require, verify_if_given or require_and_verify).
+	// require_and_verify when there are client CAs and none otherwise
+	ClientAuth string `mapstructure:"client_auth"`
+	// do not negotiate HTTP/2 with the clients
+	DisableHTTP2 bool `mapstructure:"disable_http2"`
+	// plain HTTP port redirecting the requests to the TLS listener. Disabled when zero
+	RedirectPort int `mapstructure:"redirect_port"`
+}
+
+// KeyPairConfig defines the files of a certificate and its key
+type KeyPairConfig struct {
+	// file with the PEM encoded certificate, followed by its intermediates
+	CertFile string `mapstructure:"cert_file"`
+	// file with the PEM encoded key of the certificate
+	KeyFile string `mapstructure:"key_file"`
+}
+
+// CompressionConfig defines how the responses are compressed for the clients, negotiating the
+// content coding with the Accept-Encoding header of the requests
+type CompressionConfig struct {
+	// content codings offered to the clients (gzip, deflate, br, zstd), in order of preference.
+	// The responses are not compressed when empty
+	Encodings []string `mapstructure:"encodings"`
+	// size in bytes of the smallest response to compress
+	MinSize int `mapstructure:"min_size"`
+	// compression level. The default level of every content coding is used when zero
+	Level int `mapstructure:"level"`
+}
+
+// GRPCConfig defines the unary method called by a grpc backend
+type GRPCConfig struct {
+	// files containing the serialized FileDescriptorSet of the service and its dependencies, as
+	// generated by protoc --include_imports --descriptor_set_out
+	DescriptorSets []string `mapstructure:"descriptor_sets"`
+	// full name of the method (package.Service/Method)
+	Method string `mapstructure:"method"`
+}
+
+// GraphQLConfig defines the operation sent to a graphql backend
+type GraphQLConfig struct {
+	// the query or mutation to send
+	Query string `mapstructure:"query"`
+	// path of the file containing the query or mutation, instead of the inline one
+	QueryPath string `mapstructure:"query_path"`
+	// name of the operation to execute when the document contains several ones
+	OperationName string `mapstructure:"operation_name"`
+	// default values of the variables, replaced by the URL params and the query string params with
+	// the same name
+	Variables map[string]interface{} `mapstructure:"variables"`
+}
+
+// StaticSD is the name of the service discovery using the hosts defined in the config
+const StaticSD = "static"
+
+const (
+	// HTTPBackend is the type of the backends consumed over http
+	HTTPBackend = "http"
+	// GRPCBackend is the type of the backends consumed over grpc
+	GRPCBackend = "grpc"
+	// GraphQLBackend is the type of the backends consumed with graphql operations
+	GraphQLBackend = "graphql"
+)
+
+const (
+	HashKeyFromParam  = "param"
+	HashKeyFromHeader = "header"
+	HashKeyFromQuery  = "query"
+)
+
+// HashKeySource returns the source and the name of the request attribute to be used as hash key
+func (l LBConfig) HashKeySource() (source, name string) {
+	parts := strings.SplitN(l.HashKey, ":", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
 var (
 	simpleURLKeysPattern   = regexp.MustCompile(`\{([a-zA-Z\-_0-9]+)\}`)
 	endpointURLKeysPattern = regexp.MustCompile(`/\{([a-zA-Z\-_0-9]+)\}`)
@@ -112,6 +518,12 @@ func (s *ServiceConfig) Init() error {
 	if s.Port == 0 {
 		s.Port = defaultPort
 	}
+	if err := s.validateTLS(); err != nil {
+		return err
+	}
+	if err := s.validateAPIKeys(); err != nil {
+		return err
+	}
 	s.Host = s.cleanHosts(s.Host)
 	for i, e := range s.Endpoints {
 		e.Endpoint = s.cleanPath(e.Endpoint)
@@ -128,11 +540,60 @@ func (s *ServiceConfig) Init() error {
 		e.Endpoint = s.getEndpointPath(e.Endpoint, inputParams)
 
 		s.initEndpointDefaults(i)
+		if err := e.initOutputEncoding(); err != nil {
+			return err
+		}
+		if err := validateContentCodings(e.Compression.Encodings); err != nil {
+			return err
+		}
+		if err := e.validateCORS(); err != nil {
+			return err
+		}
+		if err := e.validateJWT(inputSet); err != nil {
+			return err
+		}
+		if err := validateRateLimit(e.RateLimit, "endpoint: "+e.Endpoint); err != nil {
+			return err
+		}
+		if err := e.validateCache(); err != nil {
+			return err
+		}
+		if e.APIKey && s.APIKeys == nil {
+			return fmt.Errorf("The endpoint requires an API key but the service has no API keys! endpoint: %s\n", e.Endpoint)
+		}
+		for h := range e.HeadersToPass {
+			e.HeadersToPass[h] = textproto.CanonicalMIMEHeaderKey(e.HeadersToPass[h])
+		}
 
 		for j, b := range e.Backend {
-			s.initBackendDefaults(i, j)
+			if err := s.initBackendTiers(i, j); err != nil {
+				return err
+			}
+			if err := s.initBackendDefaults(i, j); err != nil {
+				return err
+			}
 			b.Method = strings.ToTitle(b.Method)
 
+			if err := b.validateType(); err != nil {
+				return err
+			}
+			if err := validateContentCodings(b.AcceptEncoding); err != nil {
+				return err
+			}
+			if len(b.AcceptEncoding) > 0 && b.Encoding == encoding.NOOP {
+				return fmt.Errorf("The no-op backends can not accept content codings! backend: %v\n", b.Host)
+			}
+			if err := b.validateHTTPClient(); err != nil {
+				return err
+			}
+			if err := validateRateLimit(b.RateLimit, fmt.Sprintf("backend: %v", b.Host)); err != nil {
+				return err
+			}
+
+			if err := b.validateLB(e, inputSet); err != nil {
+				return err
+			}
+
 			if err := s.initBackendURLMappings(i, j, inputSet); err != nil {
 				return err
 			}
@@ -166,9 +627,21 @@ func (s *ServiceConfig) initEndpointDefaults(e int) {
 	if endpoint.ConcurrentCalls == 0 {
 		endpoint.ConcurrentCalls = 1
 	}
+	if s.MaxRequestSize != 0 && endpoint.MaxRequestSize == 0 {
+		endpoint.MaxRequestSize = s.MaxRequestSize
+	}
+	if len(endpoint.Compression.Encodings) == 0 {
+		endpoint.Compression = s.Compression
+	}
+	if endpoint.CORS == nil {
+		endpoint.CORS = s.CORS
+	}
+	if endpoint.APIKey {
+		endpoint.APIKeys = s.APIKeys
+	}
 }
 
-func (s *ServiceConfig) initBackendDefaults(e, b int) {
+func (s *ServiceConfig) initBackendDefaults(e, b int) error {
 	endpoint := s.Endpoints[e]
 	backend := endpoint.Backend[b]
 	if len(backend.Host) == 0 {
@@ -181,19 +654,84 @@ func (s *ServiceConfig) initBackendDefaults(e, b int) {
 	}
 	backend.Timeout = endpoint.Timeout
 	backend.ConcurrentCalls = endpoint.ConcurrentCalls
+	if s.MaxResponseSize != 0 && backend.MaxResponseSize == 0 {
+		backend.MaxResponseSize = s.MaxResponseSize
+	}
+	if backend.MaxResponseSize < 0 || backend.MaxDepth < 0 || endpoint.MaxRequestSize < 0 {
+		return fmt.Errorf("Negative size limit! backend: %s\n", backend.URLPattern)
+	}
 
-	switch strings.ToLower(backend.Encoding) {
-	case "xml":
-		backend.Decoder = encoding.XMLDecoder
-	case "json":
+	backend.Encoding = strings.ToLower(backend.Encoding)
+	if endpoint.OutputEncoding == encoding.NOOP {
+		backend.Encoding = encoding.NOOP
+		backend.Decoder = nil
+		return nil
+	}
+	switch backend.Encoding {
+	case encoding.NOOP:
+		return fmt.Errorf("The no-op encoding requires a single backend and a no-op output encoding! backend: %s\n", backend.URLPattern)
+	case "":
+		backend.Encoding = encoding.JSON
+	case encoding.AUTO:
 		backend.Decoder = encoding.JSONDecoder
-	case "toml":
-		backend.Decoder = encoding.TOMLDecoder
-	case "yaml":
-		backend.Decoder = encoding.YAMLDecoder
-	default:
-		backend.Decoder = encoding.YAMLDecoder
+		return nil
 	}
+	decoder, err := encoding.Get(backend.Encoding)
+	if err != nil {
+		return fmt.Errorf("Unsupported encoding [%s]! backend: %s\n", backend.Encoding, backend.URLPattern)
+	}
+	backend.Decoder = decoder
+	if backend.Encoding == encoding.CSV && backend.CSVDelimiter != "" {
+		delimiter := []rune(backend.CSVDelimiter)
+		if len(delimiter) != 1 || !encoding.ValidCSVDelimiter(delimiter[0]) {
+			return fmt.Errorf("Invalid CSV delimiter [%s]! backend: %s\n", backend.CSVDelimiter, backend.URLPattern)
+		}
+		backend.Decoder = encoding.NewCSVDecoder(delimiter[0])
+	}
+	return nil
+}
+
+func (s *ServiceConfig) initBackendTiers(e, b int) error {
+	backend := s.Endpoints[e].Backend[b]
+	if len(backend.HostTiers) == 0 {
+		return nil
+	}
+	if backend.SD != "" && backend.SD != StaticSD {
+		return fmt.Errorf("Host tiers are not supported by the [%s] service discovery!\n", backend.SD)
+	}
+	if len(backend.Host) > 0 {
+		return fmt.Errorf("Hosts and host tiers defined at the same backend! hosts: %v, tiers: %v\n", backend.Host, backend.HostTiers)
+	}
+
+	local := []HostTier{}
+	remote := []HostTier{}
+	for i, t := range backend.HostTiers {
+		if t.Name == "" {
+			t.Name = fmt.Sprintf("tier-%d", i)
+		}
+		t.Host = s.cleanHosts(t.Host)
+		if s.Zone != "" && t.Name == s.Zone {
+			local = append(local, t)
+		} else {
+			remote = append(remote, t)
+		}
+	}
+	tiers := append(local, remote...)
+
+	seen := map[string]interface{}{}
+	hosts := []string{}
+	for _, t := range tiers {
+		for _, h := range t.Host {
+			if _, ok := seen[h]; ok {
+				return fmt.Errorf("Host [%s] defined at several tiers!\n", h)
+			}
+			seen[h] = nil
+			hosts = append(hosts, h)
+		}
+	}
+	backend.HostTiers = tiers
+	backend.Host = hosts
+	return nil
 }
 
 func (s *ServiceConfig) initBackendURLMappings(e, b int, inputParams map[string]interface{}) error {
@@ -259,6 +797,410 @@ func (s *ServiceConfig) getEndpointPath(path string, params []string) string {
 	return result
 }
 
+func (b *Backend) validateLB(e *EndpointConfig, inputParams map[string]interface{}) error {
+	b.LB.Strategy = strings.ToLower(b.LB.Strategy)
+	if err := b.validateHashKey(e, inputParams); err != nil {
+		return err
+	}
+	if len(b.LB.Weights) == 0 {
+		return nil
+	}
+	if (b.SD != "" && b.SD != StaticSD) || len(b.HostTiers) > 0 {
+		return fmt.Errorf("The lb weights require a static host list without tiers! hosts: %v, weights: %v\n", b.Host, b.LB.Weights)
+	}
+	if len(b.LB.Weights) != len(b.Host) {
+		return fmt.Errorf("Wrong number of lb weights! hosts: %v, weights: %v\n", b.Host, b.LB.Weights)
+	}
+	for _, w := range b.LB.Weights {
+		if w < 0 {
+			return fmt.Errorf("Negative lb weight! hosts: %v, weights: %v\n", b.Host, b.LB.Weights)
+		}
+	}
+	return nil
+}
+
+func (b *Backend) validateHashKey(e *EndpointConfig, inputParams map[string]interface{}) error {
+	if b.LB.HashKey == "" {
+		return nil
+	}
+	source, name := b.LB.HashKeySource()
+	if name == "" {
+		return fmt.Errorf("Invalid lb hash key [%s]!\n", b.LB.HashKey)
+	}
+	switch source {
+	case HashKeyFromParam:
+		if _, ok := inputParams[name]; !ok {
+			return fmt.Errorf("Undefined lb hash key param [%s]! input: %v\n", name, inputParams)
+		}
+		name = strings.Title(name)
+	case HashKeyFromHeader:
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		if !contains(e.HeadersToPass, name) {
+			return fmt.Errorf("The lb hash key header [%s] is not in the headers_to_pass list!\n", name)
+		}
+	case HashKeyFromQuery:
+		if !contains(e.QueryString, name) {
+			return fmt.Errorf("The lb hash key query param [%s] is not in the querystring_params list!\n", name)
+		}
+	default:
+		return fmt.Errorf("Invalid lb hash key source [%s]!\n", source)
+	}
+	b.LB.HashKey = source + ":" + name
+	return nil
+}
+
+func contains(set []string, value string) bool {
+	for _, v := range set {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Backend) validateHTTPClient() error {
+	c := b.HTTPClient
+	if c == nil {
+		return nil
+	}
+	if c.MaxIdleConns < 0 || c.MaxIdleConnsPerHost < 0 || c.MaxConnsPerHost < 0 {
+		return fmt.Errorf("Negative http client connection limit! backend: %s\n", b.URLPattern)
+	}
+	if c.IdleConnTimeout < 0 || c.DialTimeout < 0 || c.KeepAlive < 0 || c.TLSHandshakeTimeout < 0 || c.ResponseHeaderTimeout < 0 {
+		return fmt.Errorf("Negative http client timeout! backend: %s\n", b.URLPattern)
+	}
+	if c.TLS == nil {
+		return nil
+	}
+	if (c.TLS.ClientCert == "") != (c.TLS.ClientKey == "") {
+		return fmt.Errorf("The client certificate and its key must be defined together! backend: %s\n", b.URLPattern)
+	}
+	if _, err := ParseTLSVersion(c.TLS.MinVersion); err != nil {
+		return fmt.Errorf("Unsupported TLS version [%s]! backend: %s\n", c.TLS.MinVersion, b.URLPattern)
+	}
+	return nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion returns the TLS version with the received name (1.0, 1.1, 1.2 or 1.3). An empty
+// name returns zero, so the default version of the crypto/tls package is used
+func ParseTLSVersion(name string) (uint16, error) {
+	if name == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(name), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version: %s", name)
+	}
+	return v, nil
+}
+
+func (s *ServiceConfig) validateTLS() error {
+	t := s.TLS
+	if t == nil {
+		return nil
+	}
+	if s.H2C {
+		return fmt.Errorf("The h2c protocol requires a plain HTTP listener!\n")
+	}
+	if len(t.Certificates) == 0 {
+		return fmt.Errorf("The TLS listener requires at least a certificate!\n")
+	}
+	for _, kp := range t.Certificates {
+		if kp.CertFile == "" || kp.KeyFile == "" {
+			return fmt.Errorf("The TLS certificates require a certificate and a key file! certificate: %s\n", kp.CertFile)
+		}
+	}
+	if _, err := ParseTLSVersion(t.MinVersion); err != nil {
+		return fmt.Errorf("Unsupported TLS version [%s]!\n", t.MinVersion)
+	}
+	if _, err := ParseCipherSuites(t.CipherSuites); err != nil {
+		return fmt.Errorf("Unsupported cipher suites %v!\n", t.CipherSuites)
+	}
+	if _, err := ParseClientAuth(t.ClientAuth, len(t.ClientCAs) > 0); err != nil {
+		return fmt.Errorf("Unsupported client auth [%s]!\n", t.ClientAuth)
+	}
+	if t.RedirectPort < 0 || t.RedirectPort == s.Port {
+		return fmt.Errorf("Invalid redirect port [%d]! port: %d\n", t.RedirectPort, s.Port)
+	}
+	return nil
+}
+
+// ParseCipherSuites returns the ids of the cipher suites with the received names, as defined by the
+// crypto/tls package
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := map[string]uint16{}
+	for _, c := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[c.Name] = c.ID
+	}
+	ids := make([]uint16, len(names))
+	for i, name := range names {
+		id, ok := known[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite: %s", name)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+// ParseClientAuth returns the client certificate policy with the received name (none, request,
+// require, verify_if_given or require_and_verify). An empty name returns require_and_verify when
+// there are client CAs and none otherwise
+func ParseClientAuth(name string, withClientCAs bool) (tls.ClientAuthType, error) {
+	if name == "" {
+		if withClientCAs {
+			return tls.RequireAndVerifyClientCert, nil
+		}
+		return tls.NoClientCert, nil
+	}
+	ca, ok := clientAuthTypes[strings.ToLower(name)]
+	if !ok {
+		return tls.NoClientCert, fmt.Errorf("unsupported client auth: %s", name)
+	}
+	return ca, nil
+}
+
+// validateRateLimit checks the limits and normalizes the key identifying the clients. The errors
+// end with the received description of the limited resource
+func validateRateLimit(r *RateLimitConfig, resource string) error {
+	if r == nil {
+		return nil
+	}
+	if r.MaxRate < 0 || r.Capacity < 0 || r.ClientMaxRate < 0 || r.ClientCapacity < 0 {
+		return fmt.Errorf("Negative rate limit! %s\n", resource)
+	}
+	if r.MaxRate == 0 && r.ClientMaxRate == 0 {
+		return fmt.Errorf("The rate limit requires a max rate or a client max rate! %s\n", resource)
+	}
+	source, name := r.KeySource()
+	switch strings.ToLower(source) {
+	case RateLimitKeyIP:
+		r.Key = RateLimitKeyIP
+	case RateLimitKeyHeader:
+		if name == "" {
+			return fmt.Errorf("Invalid rate limit key [%s]! %s\n", r.Key, resource)
+		}
+		r.Key = RateLimitKeyHeader + ":" + textproto.CanonicalMIMEHeaderKey(name)
+	default:
+		return fmt.Errorf("Invalid rate limit key [%s]! %s\n", r.Key, resource)
+	}
+	return nil
+}
+
+func (s *ServiceConfig) validateAPIKeys() error {
+	a := s.APIKeys
+	if a == nil {
+		return nil
+	}
+	if len(a.Keys) == 0 && a.KeysFile == "" {
+		return fmt.Errorf("The API keys require at least a key or a keys file!\n")
+	}
+	if a.Header == "" {
+		a.Header = "X-Api-Key"
+	}
+	a.Header = textproto.CanonicalMIMEHeaderKey(a.Header)
+	if a.ClientHeader != "" {
+		a.ClientHeader = textproto.CanonicalMIMEHeaderKey(a.ClientHeader)
+	}
+	keys := map[string]struct{}{}
+	for _, k := range a.Keys {
+		if k.Key == "" {
+			return fmt.Errorf("Empty API key! client: %s\n", k.Client)
+		}
+		if _, ok := keys[k.Key]; ok {
+			return fmt.Errorf("Duplicated API key! client: %s\n", k.Client)
+		}
+		keys[k.Key] = struct{}{}
+		if k.RateLimit < 0 || k.Burst < 0 {
+			return fmt.Errorf("Negative API key rate limit or burst! client: %s\n", k.Client)
+		}
+	}
+	return nil
+}
+
+func (e *EndpointConfig) validateCORS() error {
+	c := e.CORS
+	if c == nil {
+		return nil
+	}
+	if len(c.AllowOrigins) == 0 {
+		return fmt.Errorf("The CORS policy requires at least an allowed origin! endpoint: %s\n", e.Endpoint)
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("Negative CORS max age! endpoint: %s\n", e.Endpoint)
+	}
+	if c.AllowCredentials {
+		for _, o := range c.AllowOrigins {
+			if o == "*" {
+				return fmt.Errorf("The CORS policy can not allow any origin with credentials! endpoint: %s\n", e.Endpoint)
+			}
+		}
+	}
+	for i, m := range c.AllowMethods {
+		c.AllowMethods[i] = strings.ToUpper(m)
+	}
+	return nil
+}
+
+func (e *EndpointConfig) validateCache() error {
+	c := e.Cache
+	if c == nil {
+		return nil
+	}
+	if e.CacheTTL <= 0 {
+		return fmt.Errorf("The cache requires a cache TTL! endpoint: %s\n", e.Endpoint)
+	}
+	if e.Method != GET {
+		return fmt.Errorf("Only the GET endpoints can be cached! endpoint: %s\n", e.Endpoint)
+	}
+	if e.OutputEncoding == encoding.NOOP {
+		return fmt.Errorf("The no-op endpoints can not be cached! endpoint: %s\n", e.Endpoint)
+	}
+	if c.MaxEntries < 0 || c.MaxSize < 0 {
+		return fmt.Errorf("Negative cache limit! endpoint: %s\n", e.Endpoint)
+	}
+	c.Store = strings.ToLower(c.Store)
+	if c.Store == "" {
+		c.Store = CacheMemoryStore
+	}
+	if c.MaxEntries == 0 && c.MaxSize == 0 {
+		c.MaxEntries = DefaultCacheMaxEntries
+	}
+	for i, h := range c.Headers {
+		c.Headers[i] = textproto.CanonicalMIMEHeaderKey(h)
+	}
+	return nil
+}
+
+// jwtAlgorithms are the names of the signing algorithms supported by the JWT validation, by their
+// uppercased name
+var jwtAlgorithms = map[string]string{
+	"HS256": "HS256", "HS384": "HS384", "HS512": "HS512",
+	"RS256": "RS256", "RS384": "RS384", "RS512": "RS512",
+	"PS256": "PS256", "PS384": "PS384", "PS512": "PS512",
+	"ES256": "ES256", "ES384": "ES384", "ES512": "ES512",
+	"EDDSA": "EdDSA",
+}
+
+// validateJWT checks the JWT settings of the endpoint, adding the params taken from the claims to
+// the input params available to the url patterns of the backends
+func (e *EndpointConfig) validateJWT(inputParams map[string]interface{}) error {
+	j := e.JWT
+	if j == nil {
+		return nil
+	}
+	if len(j.Keys) == 0 && j.JWKSURL == "" && j.JWKSFile == "" {
+		return fmt.Errorf("The JWT validation requires at least a key or a JWK set! endpoint: %s\n", e.Endpoint)
+	}
+	for i, a := range j.Algorithms {
+		name, ok := jwtAlgorithms[strings.ToUpper(a)]
+		if !ok {
+			return fmt.Errorf("Unsupported JWT algorithm [%s]! endpoint: %s\n", a, e.Endpoint)
+		}
+		j.Algorithms[i] = name
+	}
+	for _, k := range j.Keys {
+		if (k.Secret == "") == (k.PublicKeyFile == "") {
+			return fmt.Errorf("The JWT keys require either a secret or a public key file! endpoint: %s\n", e.Endpoint)
+		}
+	}
+	if j.JWKSURL != "" && !strings.HasPrefix(j.JWKSURL, "http://") && !strings.HasPrefix(j.JWKSURL, "https://") {
+		return fmt.Errorf("Invalid JWK set URL [%s]! endpoint: %s\n", j.JWKSURL, e.Endpoint)
+	}
+	if j.Leeway < 0 || j.JWKSCacheDuration < 0 {
+		return fmt.Errorf("Negative JWT leeway or JWK set cache duration! endpoint: %s\n", e.Endpoint)
+	}
+	if j.RolesKey == "" {
+		j.RolesKey = "roles"
+	}
+	for claim, header := range j.ClaimsToHeaders {
+		j.ClaimsToHeaders[claim] = textproto.CanonicalMIMEHeaderKey(header)
+	}
+	for _, param := range j.ClaimsToParams {
+		if _, ok := inputParams[param]; ok {
+			return fmt.Errorf("The claim param [%s] collides with an endpoint param! endpoint: %s\n", param, e.Endpoint)
+		}
+		inputParams[param] = nil
+	}
+	return nil
+}
+
+// validateContentCodings lowercases the received content codings and checks they are supported
+func validateContentCodings(codings []string) error {
+	for i, c := range codings {
+		codings[i] = strings.ToLower(c)
+		if !compression.Supported(codings[i]) {
+			return fmt.Errorf("Unsupported content coding [%s]!\n", c)
+		}
+	}
+	return nil
+}
+
+func (b *Backend) validateType() error {
+	b.Type = strings.ToLower(b.Type)
+	switch b.Type {
+	case "":
+		b.Type = HTTPBackend
+	case GRPCBackend:
+		if len(b.GRPC.DescriptorSets) == 0 || b.GRPC.Method == "" {
+			return fmt.Errorf("The grpc backends require a method and its descriptor sets! backend: %v\n", b.Host)
+		}
+		if b.Encoding == encoding.NOOP {
+			return fmt.Errorf("The grpc backends do not support the no-op encoding! backend: %v\n", b.Host)
+		}
+	case GraphQLBackend:
+		if (b.GraphQL.Query == "") == (b.GraphQL.QueryPath == "") {
+			return fmt.Errorf("The graphql backends require either a query or a query path! backend: %v\n", b.Host)
+		}
+		if b.Encoding == encoding.NOOP {
+			return fmt.Errorf("The graphql backends do not support the no-op encoding! backend: %v\n", b.Host)
+		}
+	}
+	return nil
+}
+
+func (e *EndpointConfig) initOutputEncoding() error {
+	e.OutputEncoding = strings.ToLower(e.OutputEncoding)
+	if e.OutputEncoding == "" && len(e.Backend) == 1 && strings.ToLower(e.Backend[0].Encoding) == encoding.NOOP {
+		e.OutputEncoding = encoding.NOOP
+	}
+	switch e.OutputEncoding {
+	case "":
+		e.OutputEncoding = encoding.JSON
+	case encoding.NEGOTIATE:
+	case encoding.NOOP:
+		if len(e.Backend) > 1 {
+			return fmt.Errorf("The no-op output encoding requires a single backend! endpoint: %s\n", e.Endpoint)
+		}
+		if e.ConcurrentCalls > 1 {
+			return fmt.Errorf("The no-op output encoding does not support concurrent calls! endpoint: %s\n", e.Endpoint)
+		}
+	default:
+		if _, _, err := encoding.GetEncoder(e.OutputEncoding); err != nil {
+			return fmt.Errorf("Unsupported output encoding [%s]! endpoint: %s\n", e.OutputEncoding, e.Endpoint)
+		}
+	}
+	return nil
+}
+
 func (e *EndpointConfig) validate() error {
 	matched, err := regexp.MatchString(debugPattern, e.Endpoint)
 	if err != nil {
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"crypto/tls"
 	"strings"
 	"testing"
 	"time"
@@ -325,3 +326,671 @@ func TestConfig_initKOInvalidDebugPattern(t *testing.T) {
 
 	debugPattern = dp
 }
+
+func TestConfig_initKOWrongLBWeights(t *testing.T) {
+	for _, weights := range [][]int{{1}, {1, -1}} {
+		subject := ServiceConfig{
+			Version: 1,
+			Host:    []string{"http://127.0.0.1:8080", "http://127.0.0.1:8081"},
+			Endpoints: []*EndpointConfig{
+				&EndpointConfig{
+					Endpoint: "/supu",
+					Backend: []*Backend{
+						&Backend{
+							URLPattern: "/",
+							LB:         LBConfig{Strategy: "weighted_round_robin", Weights: weights},
+						},
+					},
+				},
+			},
+		}
+		if err := subject.Init(); err == nil || !strings.Contains(err.Error(), "lb weight") {
+			t.Error("Error expected at the configuration init with weights", weights, err)
+		}
+	}
+}
+
+func TestConfig_initKOLBWeightsWithoutStaticHosts(t *testing.T) {
+	for _, b := range []*Backend{
+		&Backend{URLPattern: "/", SD: "kubernetes", LB: LBConfig{Weights: []int{1}}},
+		&Backend{URLPattern: "/", HostTiers: []HostTier{{Host: []string{"http://127.0.0.1:8080"}}}, LB: LBConfig{Weights: []int{1}}},
+	} {
+		subject := ServiceConfig{
+			Version:   1,
+			Host:      []string{"http://127.0.0.1:8080"},
+			Endpoints: []*EndpointConfig{&EndpointConfig{Endpoint: "/supu", Backend: []*Backend{b}}},
+		}
+		if err := subject.Init(); err == nil || !strings.Contains(err.Error(), "lb weights") {
+			t.Error("Error expected at the configuration init with weights", b, err)
+		}
+	}
+}
+
+func TestConfig_initLBHashKey(t *testing.T) {
+	for hashKey, expected := range map[string]string{
+		"param:user":       "param:User",
+		"header:x-user-id": "header:X-User-Id",
+		"query:user":       "query:user",
+		"":                 "",
+	} {
+		backend := Backend{
+			URLPattern: "/users/{user}",
+			LB:         LBConfig{Strategy: "Consistent_Hash", HashKey: hashKey},
+		}
+		subject := ServiceConfig{
+			Version: 1,
+			Host:    []string{"http://127.0.0.1:8080"},
+			Endpoints: []*EndpointConfig{
+				&EndpointConfig{
+					Endpoint:      "/users/{user}",
+					QueryString:   []string{"user"},
+					HeadersToPass: []string{"x-user-id"},
+					Backend:       []*Backend{&backend},
+				},
+			},
+		}
+		if err := subject.Init(); err != nil {
+			t.Error("Error at the configuration init:", err.Error())
+			continue
+		}
+		if backend.LB.HashKey != expected {
+			t.Errorf("want %s, have %s", expected, backend.LB.HashKey)
+		}
+		if backend.LB.Strategy != "consistent_hash" {
+			t.Errorf("lb strategy not sanitized: %s", backend.LB.Strategy)
+		}
+	}
+}
+
+func TestConfig_initKOLBHashKey(t *testing.T) {
+	for _, hashKey := range []string{"user", "param:", "param:id", "header:X-User-Id", "query:user", "cookie:user"} {
+		subject := ServiceConfig{
+			Version: 1,
+			Host:    []string{"http://127.0.0.1:8080"},
+			Endpoints: []*EndpointConfig{
+				&EndpointConfig{
+					Endpoint: "/users/{user}",
+					Backend: []*Backend{
+						&Backend{
+							URLPattern: "/users/{user}",
+							LB:         LBConfig{Strategy: "consistent_hash", HashKey: hashKey},
+						},
+					},
+				},
+			},
+		}
+		if err := subject.Init(); err == nil || !strings.Contains(err.Error(), "lb hash key") {
+			t.Error("Error expected at the configuration init with hash key", hashKey, err)
+		}
+	}
+}
+
+func TestConfig_initBackendTiers(t *testing.T) {
+	backend := Backend{
+		URLPattern: "/",
+		HostTiers: []HostTier{
+			{Name: "dc1", Host: []string{"a.dc1", "b.dc1"}},
+			{Host: []string{"a.backup"}},
+			{Name: "dc2", Host: []string{"https://a.dc2"}},
+		},
+	}
+	subject := ServiceConfig{
+		Version: 1,
+		Zone:    "dc2",
+		Host:    []string{"http://127.0.0.1:8080"},
+		Endpoints: []*EndpointConfig{
+			&EndpointConfig{Endpoint: "/supu", Backend: []*Backend{&backend}},
+		},
+	}
+	if err := subject.Init(); err != nil {
+		t.Fatal("Error at the configuration init:", err.Error())
+	}
+
+	expectedTiers := []string{"dc2", "dc1", "tier-1"}
+	for i, tier := range backend.HostTiers {
+		if tier.Name != expectedTiers[i] {
+			t.Errorf("want tier %s, have %s", expectedTiers[i], tier.Name)
+		}
+	}
+	expectedHosts := []string{"https://a.dc2", "http://a.dc1", "http://b.dc1", "http://a.backup"}
+	if len(backend.Host) != len(expectedHosts) {
+		t.Fatalf("unexpected hosts: %v", backend.Host)
+	}
+	for i := range expectedHosts {
+		if backend.Host[i] != expectedHosts[i] {
+			t.Errorf("want host %s, have %s", expectedHosts[i], backend.Host[i])
+		}
+	}
+}
+
+func TestConfig_initKOBackendTiers(t *testing.T) {
+	for _, backend := range []*Backend{
+		&Backend{
+			URLPattern: "/",
+			Host:       []string{"a"},
+			HostTiers:  []HostTier{{Name: "dc1", Host: []string{"b"}}},
+		},
+		&Backend{
+			URLPattern: "/",
+			HostTiers:  []HostTier{{Name: "dc1", Host: []string{"a"}}, {Name: "dc2", Host: []string{"http://a"}}},
+		},
+		&Backend{
+			URLPattern: "/",
+			SD:         "kubernetes",
+			HostTiers:  []HostTier{{Name: "dc1", Host: []string{"a"}}},
+		},
+	} {
+		subject := ServiceConfig{
+			Version: 1,
+			Endpoints: []*EndpointConfig{
+				&EndpointConfig{Endpoint: "/supu", Backend: []*Backend{backend}},
+			},
+		}
+		if err := subject.Init(); err == nil {
+			t.Error("Error expected at the configuration init with the backend", backend)
+		}
+	}
+}
+
+func TestConfig_initBackendEncoding(t *testing.T) {
+	for _, enc := range []string{"", "JSON", "xml", "yaml", "toml", "auto"} {
+		backend := Backend{URLPattern: "/", Encoding: enc}
+		subject := ServiceConfig{
+			Version:   1,
+			Host:      []string{"http://127.0.0.1:8080"},
+			Endpoints: []*EndpointConfig{&EndpointConfig{Endpoint: "/supu", Backend: []*Backend{&backend}}},
+		}
+		if err := subject.Init(); err != nil {
+			t.Errorf("%s: unexpected error %v", enc, err)
+			continue
+		}
+		if backend.Decoder == nil {
+			t.Errorf("%s: decoder not set", enc)
+		}
+		if enc == "" && backend.Encoding != "json" {
+			t.Errorf("json is not the default encoding: %s", backend.Encoding)
+		}
+	}
+
+	subject := ServiceConfig{
+		Version: 1,
+		Host:    []string{"http://127.0.0.1:8080"},
+		Endpoints: []*EndpointConfig{
+			&EndpointConfig{Endpoint: "/supu", Backend: []*Backend{&Backend{URLPattern: "/", Encoding: "unknown"}}},
+		},
+	}
+	if err := subject.Init(); err == nil || !strings.HasPrefix(err.Error(), "Unsupported encoding [unknown]!") {
+		t.Error("Error expected at the configuration init", err)
+	}
+}
+
+func TestConfig_initOutputEncoding(t *testing.T) {
+	for _, enc := range []string{"", "JSON", "xml", "yaml", "msgpack", "negotiate", "no-op"} {
+		backend := Backend{URLPattern: "/"}
+		endpoint := EndpointConfig{Endpoint: "/supu", OutputEncoding: enc, Backend: []*Backend{&backend}}
+		subject := ServiceConfig{
+			Version:   1,
+			Host:      []string{"http://127.0.0.1:8080"},
+			Endpoints: []*EndpointConfig{&endpoint},
+		}
+		if err := subject.Init(); err != nil {
+			t.Errorf("%s: unexpected error %v", enc, err)
+			continue
+		}
+		if enc == "" && endpoint.OutputEncoding != "json" {
+			t.Errorf("json is not the default output encoding: %s", endpoint.OutputEncoding)
+		}
+		if enc == "no-op" && (backend.Encoding != "no-op" || backend.Decoder != nil) {
+			t.Errorf("the backend of a no-op endpoint should not decode the responses: %s", backend.Encoding)
+		}
+	}
+
+	for _, endpoint := range []*EndpointConfig{
+		&EndpointConfig{Endpoint: "/supu", OutputEncoding: "unknown", Backend: []*Backend{&Backend{URLPattern: "/"}}},
+		&EndpointConfig{Endpoint: "/supu", OutputEncoding: "no-op", Backend: []*Backend{&Backend{URLPattern: "/a"}, &Backend{URLPattern: "/b"}}},
+		&EndpointConfig{Endpoint: "/supu", OutputEncoding: "no-op", ConcurrentCalls: 3, Backend: []*Backend{&Backend{URLPattern: "/"}}},
+		&EndpointConfig{Endpoint: "/supu", OutputEncoding: "json", Backend: []*Backend{&Backend{URLPattern: "/", Encoding: "no-op"}}},
+		&EndpointConfig{Endpoint: "/supu", Backend: []*Backend{&Backend{URLPattern: "/a", Encoding: "no-op"}, &Backend{URLPattern: "/b"}}},
+	} {
+		subject := ServiceConfig{
+			Version:   1,
+			Host:      []string{"http://127.0.0.1:8080"},
+			Endpoints: []*EndpointConfig{endpoint},
+		}
+		if err := subject.Init(); err == nil {
+			t.Error("Error expected at the configuration init with the endpoint", endpoint)
+		}
+	}
+}
+
+func TestConfig_initNoopBackendEncoding(t *testing.T) {
+	backend := Backend{URLPattern: "/", Encoding: "No-Op"}
+	endpoint := EndpointConfig{Endpoint: "/supu", Backend: []*Backend{&backend}}
+	subject := ServiceConfig{
+		Version:   1,
+		Host:      []string{"http://127.0.0.1:8080"},
+		Endpoints: []*EndpointConfig{&endpoint},
+	}
+	if err := subject.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if endpoint.OutputEncoding != "no-op" || backend.Encoding != "no-op" || backend.Decoder != nil {
+		t.Errorf("unexpected encodings: %s %s", endpoint.OutputEncoding, backend.Encoding)
+	}
+}
+
+func TestConfig_initBackendType(t *testing.T) {
+	for _, backend := range []*Backend{
+		&Backend{URLPattern: "/"},
+		&Backend{URLPattern: "/", Type: "GRPC", GRPC: GRPCConfig{DescriptorSets: []string{"users.pb"}, Method: "pkg.Users/Get"}},
+		&Backend{URLPattern: "/graphql", Type: "graphql", GraphQL: GraphQLConfig{Query: "{ users { id } }"}},
+		&Backend{URLPattern: "/graphql", Type: "graphql", GraphQL: GraphQLConfig{QueryPath: "users.graphql"}},
+	} {
+		subject := ServiceConfig{
+			Version:   1,
+			Host:      []string{"http://127.0.0.1:8080"},
+			Endpoints: []*EndpointConfig{&EndpointConfig{Endpoint: "/supu", Backend: []*Backend{backend}}},
+		}
+		if err := subject.Init(); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+		if backend.Type != HTTPBackend && backend.Type != GRPCBackend && backend.Type != GraphQLBackend {
+			t.Errorf("unexpected backend type: %s", backend.Type)
+		}
+	}
+
+	for _, backend := range []*Backend{
+		&Backend{URLPattern: "/", Type: "grpc"},
+		&Backend{URLPattern: "/", Type: "grpc", GRPC: GRPCConfig{Method: "pkg.Users/Get"}},
+		&Backend{URLPattern: "/", Type: "grpc", Encoding: "no-op", GRPC: GRPCConfig{DescriptorSets: []string{"users.pb"}, Method: "pkg.Users/Get"}},
+		&Backend{URLPattern: "/graphql", Type: "graphql"},
+		&Backend{URLPattern: "/graphql", Type: "graphql", GraphQL: GraphQLConfig{Query: "{ users { id } }", QueryPath: "users.graphql"}},
+	} {
+		subject := ServiceConfig{
+			Version:   1,
+			Host:      []string{"http://127.0.0.1:8080"},
+			Endpoints: []*EndpointConfig{&EndpointConfig{Endpoint: "/supu", Backend: []*Backend{backend}}},
+		}
+		if err := subject.Init(); err == nil {
+			t.Error("Error expected at the configuration init with the backend", backend)
+		}
+	}
+}
+
+func TestConfig_initSizeLimits(t *testing.T) {
+	backend := Backend{URLPattern: "/"}
+	custom := Backend{URLPattern: "/", MaxResponseSize: 10}
+	endpoint := EndpointConfig{Endpoint: "/supu", Backend: []*Backend{&backend, &custom}}
+	subject := ServiceConfig{
+		Version:         1,
+		Host:            []string{"http://127.0.0.1:8080"},
+		MaxRequestSize:  1024,
+		MaxResponseSize: 2048,
+		Endpoints:       []*EndpointConfig{&endpoint},
+	}
+	if err := subject.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if endpoint.MaxRequestSize != 1024 || backend.MaxResponseSize != 2048 || custom.MaxResponseSize != 10 {
+		t.Errorf("unexpected limits: %d %d %d", endpoint.MaxRequestSize, backend.MaxResponseSize, custom.MaxResponseSize)
+	}
+
+	subject = ServiceConfig{
+		Version:   1,
+		Host:      []string{"http://127.0.0.1:8080"},
+		Endpoints: []*EndpointConfig{&EndpointConfig{Endpoint: "/supu", Backend: []*Backend{&Backend{URLPattern: "/", MaxDepth: -1}}}},
+	}
+	if err := subject.Init(); err == nil {
+		t.Error("Error expected at the configuration init with a negative limit")
+	}
+}
+
+func TestConfig_initCompression(t *testing.T) {
+	backend := Backend{URLPattern: "/", AcceptEncoding: []string{"BR", "gzip"}}
+	inherited := EndpointConfig{Endpoint: "/supu", Backend: []*Backend{&backend}}
+	custom := EndpointConfig{
+		Endpoint:    "/tupu",
+		Backend:     []*Backend{&Backend{URLPattern: "/"}},
+		Compression: CompressionConfig{Encodings: []string{"Zstd"}},
+	}
+	subject := ServiceConfig{
+		Version:     1,
+		Host:        []string{"http://127.0.0.1:8080"},
+		Compression: CompressionConfig{Encodings: []string{"gzip"}, MinSize: 1024},
+		Endpoints:   []*EndpointConfig{&inherited, &custom},
+	}
+	if err := subject.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if len(inherited.Compression.Encodings) != 1 || inherited.Compression.Encodings[0] != "gzip" || inherited.Compression.MinSize != 1024 {
+		t.Errorf("unexpected inherited compression: %+v", inherited.Compression)
+	}
+	if len(custom.Compression.Encodings) != 1 || custom.Compression.Encodings[0] != "zstd" || custom.Compression.MinSize != 0 {
+		t.Errorf("unexpected custom compression: %+v", custom.Compression)
+	}
+	if backend.AcceptEncoding[0] != "br" {
+		t.Errorf("unexpected accepted encodings: %v", backend.AcceptEncoding)
+	}
+
+	for _, endpoint := range []*EndpointConfig{
+		{Endpoint: "/supu", Backend: []*Backend{&Backend{URLPattern: "/"}}, Compression: CompressionConfig{Encodings: []string{"lzw"}}},
+		{Endpoint: "/supu", Backend: []*Backend{&Backend{URLPattern: "/", AcceptEncoding: []string{"lzw"}}}},
+	} {
+		subject := ServiceConfig{Version: 1, Host: []string{"http://127.0.0.1:8080"}, Endpoints: []*EndpointConfig{endpoint}}
+		if err := subject.Init(); err == nil {
+			t.Error("Error expected at the configuration init with an unsupported content coding")
+		}
+	}
+
+	noop := &EndpointConfig{Endpoint: "/supu", OutputEncoding: "no-op", Backend: []*Backend{&Backend{URLPattern: "/", AcceptEncoding: []string{"gzip"}}}}
+	subject = ServiceConfig{Version: 1, Host: []string{"http://127.0.0.1:8080"}, Endpoints: []*EndpointConfig{noop}}
+	if err := subject.Init(); err == nil || !strings.Contains(err.Error(), "content codings") {
+		t.Error("Error expected at the configuration init with a no-op backend accepting content codings", err)
+	}
+}
+
+func TestConfig_initCSVDelimiter(t *testing.T) {
+	for delimiter, ok := range map[string]bool{"": true, ";": true, "\t": true, "\"": false, ";;": false} {
+		backend := Backend{URLPattern: "/", Encoding: "CSV", CSVDelimiter: delimiter}
+		subject := ServiceConfig{
+			Version:   1,
+			Host:      []string{"http://127.0.0.1:8080"},
+			Endpoints: []*EndpointConfig{&EndpointConfig{Endpoint: "/supu", Backend: []*Backend{&backend}}},
+		}
+		err := subject.Init()
+		if ok != (err == nil) {
+			t.Errorf("%q: unexpected result %v", delimiter, err)
+			continue
+		}
+		if ok && (backend.Encoding != "csv" || backend.Decoder == nil) {
+			t.Errorf("%q: decoder not set", delimiter)
+		}
+	}
+}
+
+func TestConfig_initHTTPClient(t *testing.T) {
+	for _, c := range []HTTPClientConfig{{MaxIdleConnsPerHost: -1}, {DialTimeout: -time.Second}} {
+		c := c
+		subject := ServiceConfig{
+			Version:   1,
+			Host:      []string{"http://127.0.0.1:8080"},
+			Endpoints: []*EndpointConfig{&EndpointConfig{Endpoint: "/supu", Backend: []*Backend{&Backend{URLPattern: "/", HTTPClient: &c}}}},
+		}
+		if err := subject.Init(); err == nil {
+			t.Errorf("Error expected at the configuration init with the http client %+v", c)
+		}
+	}
+}
+
+func TestConfig_initHTTPClientTLS(t *testing.T) {
+	for _, c := range []ClientTLSConfig{{ClientCert: "client.pem"}, {ClientKey: "client.key"}, {MinVersion: "2.0"}} {
+		c := c
+		subject := ServiceConfig{
+			Version:   1,
+			Host:      []string{"http://127.0.0.1:8080"},
+			Endpoints: []*EndpointConfig{&EndpointConfig{Endpoint: "/supu", Backend: []*Backend{&Backend{URLPattern: "/", HTTPClient: &HTTPClientConfig{TLS: &c}}}}},
+		}
+		if err := subject.Init(); err == nil {
+			t.Errorf("Error expected at the configuration init with the TLS settings %+v", c)
+		}
+	}
+
+	for name, want := range map[string]uint16{"": 0, "1.2": tls.VersionTLS12, "TLS1.3": tls.VersionTLS13} {
+		if v, err := ParseTLSVersion(name); err != nil || v != want {
+			t.Errorf("%s: unexpected version %d, %v", name, v, err)
+		}
+	}
+}
+
+func TestConfig_initListenerTLS(t *testing.T) {
+	kp := KeyPairConfig{CertFile: "cert.pem", KeyFile: "cert.key"}
+	for _, subject := range []ServiceConfig{
+		{TLS: &ServerTLSConfig{}},
+		{TLS: &ServerTLSConfig{Certificates: []KeyPairConfig{{CertFile: "cert.pem"}}}},
+		{TLS: &ServerTLSConfig{Certificates: []KeyPairConfig{kp}, MinVersion: "1.4"}},
+		{TLS: &ServerTLSConfig{Certificates: []KeyPairConfig{kp}, CipherSuites: []string{"TLS_SUPU"}}},
+		{TLS: &ServerTLSConfig{Certificates: []KeyPairConfig{kp}, ClientAuth: "always"}},
+		{TLS: &ServerTLSConfig{Certificates: []KeyPairConfig{kp}, RedirectPort: 8080}},
+		{TLS: &ServerTLSConfig{Certificates: []KeyPairConfig{kp}}, H2C: true},
+	} {
+		subject.Version = 1
+		if err := subject.Init(); err == nil {
+			t.Errorf("Error expected at the configuration init with the TLS settings %+v", subject.TLS)
+		}
+	}
+
+	subject := ServiceConfig{Version: 1, TLS: &ServerTLSConfig{
+		Certificates: []KeyPairConfig{kp},
+		CipherSuites: []string{"tls_ecdhe_rsa_with_aes_128_gcm_sha256"},
+		ClientAuth:   "VERIFY_IF_GIVEN",
+		RedirectPort: 80,
+	}}
+	if err := subject.Init(); err != nil {
+		t.Error(err)
+	}
+	if ids, err := ParseCipherSuites(subject.TLS.CipherSuites); err != nil || len(ids) != 1 || ids[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("unexpected cipher suites: %v, %v", ids, err)
+	}
+	if ca, _ := ParseClientAuth("", true); ca != tls.RequireAndVerifyClientCert {
+		t.Errorf("unexpected default client auth with client CAs: %v", ca)
+	}
+}
+
+func TestConfig_initCORS(t *testing.T) {
+	inherited := EndpointConfig{Endpoint: "/supu", Backend: []*Backend{&Backend{URLPattern: "/"}}}
+	custom := EndpointConfig{Endpoint: "/tupu", Backend: []*Backend{&Backend{URLPattern: "/"}}, CORS: &CORSConfig{AllowOrigins: []string{"https://tupu.com"}}}
+	subject := ServiceConfig{
+		Version:   1,
+		Host:      []string{"http://127.0.0.1:8080"},
+		CORS:      &CORSConfig{AllowOrigins: []string{"*"}, AllowMethods: []string{"get", "Post"}},
+		Endpoints: []*EndpointConfig{&inherited, &custom},
+	}
+	if err := subject.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if inherited.CORS != subject.CORS || custom.CORS.AllowOrigins[0] != "https://tupu.com" {
+		t.Errorf("unexpected CORS policies: %+v %+v", inherited.CORS, custom.CORS)
+	}
+	if m := inherited.CORS.AllowMethods; m[0] != "GET" || m[1] != "POST" {
+		t.Errorf("unexpected methods: %v", m)
+	}
+
+	for _, c := range []CORSConfig{
+		{},
+		{AllowOrigins: []string{"*"}, MaxAge: -time.Second},
+		{AllowOrigins: []string{"https://supu.com", "*"}, AllowCredentials: true},
+	} {
+		c := c
+		subject := ServiceConfig{
+			Version:   1,
+			Host:      []string{"http://127.0.0.1:8080"},
+			Endpoints: []*EndpointConfig{&EndpointConfig{Endpoint: "/supu", Backend: []*Backend{&Backend{URLPattern: "/"}}, CORS: &c}},
+		}
+		if err := subject.Init(); err == nil {
+			t.Errorf("Error expected at the configuration init with the CORS policy %+v", c)
+		}
+	}
+}
+
+func TestConfig_initJWT(t *testing.T) {
+	jwt := &JWTConfig{
+		Keys:            []JWTKeyConfig{{Secret: "supu"}},
+		Algorithms:      []string{"hs256", "eddsa"},
+		ClaimsToHeaders: map[string]string{"sub": "x-user-id"},
+		ClaimsToParams:  map[string]string{"tenant": "tenant"},
+	}
+	subject := ServiceConfig{
+		Version: 1,
+		Host:    []string{"http://127.0.0.1:8080"},
+		Endpoints: []*EndpointConfig{&EndpointConfig{
+			Endpoint: "/users/{id}",
+			Backend:  []*Backend{&Backend{URLPattern: "/tenants/{tenant}/users/{id}"}},
+			JWT:      jwt,
+		}},
+	}
+	if err := subject.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if jwt.Algorithms[0] != "HS256" || jwt.Algorithms[1] != "EdDSA" || jwt.RolesKey != "roles" || jwt.ClaimsToHeaders["sub"] != "X-User-Id" {
+		t.Errorf("unexpected JWT config: %+v", jwt)
+	}
+	if b := subject.Endpoints[0].Backend[0]; b.URLPattern != "/tenants/{{.Tenant}}/users/{{.Id}}" {
+		t.Errorf("unexpected url pattern: %s", b.URLPattern)
+	}
+
+	for _, c := range []JWTConfig{
+		{},
+		{Keys: []JWTKeyConfig{{}}},
+		{Keys: []JWTKeyConfig{{Secret: "supu", PublicKeyFile: "key.pem"}}},
+		{JWKSURL: "ftp://example.com/jwks.json"},
+		{JWKSFile: "jwks.json", Algorithms: []string{"none"}},
+		{JWKSFile: "jwks.json", Leeway: -time.Second},
+		{JWKSFile: "jwks.json", ClaimsToParams: map[string]string{"sub": "id"}},
+	} {
+		c := c
+		subject := ServiceConfig{
+			Version: 1,
+			Host:    []string{"http://127.0.0.1:8080"},
+			Endpoints: []*EndpointConfig{&EndpointConfig{
+				Endpoint: "/users/{id}",
+				Backend:  []*Backend{&Backend{URLPattern: "/users/{id}"}},
+				JWT:      &c,
+			}},
+		}
+		if err := subject.Init(); err == nil {
+			t.Errorf("Error expected at the configuration init with the JWT config %+v", c)
+		}
+	}
+}
+
+func TestConfig_initAPIKeys(t *testing.T) {
+	keys := &APIKeysConfig{Keys: []APIKeyConfig{{Key: "supu", Client: "supu", RateLimit: 10}}, ClientHeader: "x-client"}
+	subject := ServiceConfig{
+		Version: 1,
+		Host:    []string{"http://127.0.0.1:8080"},
+		APIKeys: keys,
+		Endpoints: []*EndpointConfig{
+			&EndpointConfig{Endpoint: "/private", Backend: []*Backend{&Backend{URLPattern: "/"}}, APIKey: true},
+			&EndpointConfig{Endpoint: "/public", Backend: []*Backend{&Backend{URLPattern: "/"}}},
+		},
+	}
+	if err := subject.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if keys.Header != "X-Api-Key" || keys.ClientHeader != "X-Client" {
+		t.Errorf("unexpected headers: %+v", keys)
+	}
+	if subject.Endpoints[0].APIKeys != keys || subject.Endpoints[1].APIKeys != nil {
+		t.Errorf("unexpected endpoint keys: %v %v", subject.Endpoints[0].APIKeys, subject.Endpoints[1].APIKeys)
+	}
+
+	for _, c := range []*APIKeysConfig{
+		{},
+		{Keys: []APIKeyConfig{{Client: "supu"}}},
+		{Keys: []APIKeyConfig{{Key: "supu"}, {Key: "supu"}}},
+		{Keys: []APIKeyConfig{{Key: "supu", RateLimit: -1}}},
+	} {
+		subject := ServiceConfig{
+			Version:   1,
+			Host:      []string{"http://127.0.0.1:8080"},
+			APIKeys:   c,
+			Endpoints: []*EndpointConfig{&EndpointConfig{Endpoint: "/supu", Backend: []*Backend{&Backend{URLPattern: "/"}}}},
+		}
+		if err := subject.Init(); err == nil {
+			t.Errorf("Error expected at the configuration init with the API keys %+v", c)
+		}
+	}
+
+	subject = ServiceConfig{
+		Version:   1,
+		Host:      []string{"http://127.0.0.1:8080"},
+		Endpoints: []*EndpointConfig{&EndpointConfig{Endpoint: "/supu", Backend: []*Backend{&Backend{URLPattern: "/"}}, APIKey: true}},
+	}
+	if err := subject.Init(); err == nil {
+		t.Error("Error expected at the configuration init of an endpoint requiring a missing API key")
+	}
+}
+
+func TestConfig_initRateLimit(t *testing.T) {
+	endpointLimit := &RateLimitConfig{MaxRate: 10, ClientMaxRate: 1, Key: "HEADER:x-client-id"}
+	backendLimit := &RateLimitConfig{MaxRate: 5}
+	subject := ServiceConfig{
+		Version: 1,
+		Host:    []string{"http://127.0.0.1:8080"},
+		Endpoints: []*EndpointConfig{
+			&EndpointConfig{
+				Endpoint:  "/supu",
+				RateLimit: endpointLimit,
+				Backend:   []*Backend{&Backend{URLPattern: "/", RateLimit: backendLimit}},
+			},
+		},
+	}
+	if err := subject.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if endpointLimit.Key != "header:X-Client-Id" {
+		t.Errorf("unexpected endpoint key: %s", endpointLimit.Key)
+	}
+	if backendLimit.Key != RateLimitKeyIP {
+		t.Errorf("unexpected backend key: %s", backendLimit.Key)
+	}
+
+	for _, r := range []*RateLimitConfig{
+		{},
+		{MaxRate: -1},
+		{MaxRate: 1, Capacity: -1},
+		{ClientMaxRate: 1, Key: "header"},
+		{ClientMaxRate: 1, Key: "cookie:supu"},
+	} {
+		for _, subject := range []ServiceConfig{
+			{
+				Version:   1,
+				Host:      []string{"http://127.0.0.1:8080"},
+				Endpoints: []*EndpointConfig{&EndpointConfig{Endpoint: "/supu", RateLimit: r, Backend: []*Backend{&Backend{URLPattern: "/"}}}},
+			},
+			{
+				Version:   1,
+				Host:      []string{"http://127.0.0.1:8080"},
+				Endpoints: []*EndpointConfig{&EndpointConfig{Endpoint: "/supu", Backend: []*Backend{&Backend{URLPattern: "/", RateLimit: r}}}},
+			},
+		} {
+			if err := subject.Init(); err == nil {
+				t.Errorf("Error expected at the configuration init with the rate limit %+v", r)
+			}
+		}
+	}
+}
+
+func TestConfig_initCache(t *testing.T) {
+	c := &CacheConfig{Store: "Memory", Headers: []string{"authorization"}}
+	subject := ServiceConfig{
+		Version:  1,
+		Host:     []string{"http://127.0.0.1:8080"},
+		CacheTTL: time.Minute,
+		Endpoints: []*EndpointConfig{
+			&EndpointConfig{Endpoint: "/supu", Cache: c, Backend: []*Backend{&Backend{URLPattern: "/"}}},
+		},
+	}
+	if err := subject.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Store != CacheMemoryStore || c.MaxEntries != DefaultCacheMaxEntries || c.Headers[0] != "Authorization" {
+		t.Errorf("unexpected cache config: %+v", c)
+	}
+
+	for i, e := range []*EndpointConfig{
+		{Endpoint: "/supu", Cache: &CacheConfig{}, Backend: []*Backend{&Backend{URLPattern: "/"}}},
+		{Endpoint: "/supu", CacheTTL: time.Minute, Method: "POST", Cache: &CacheConfig{}, Backend: []*Backend{&Backend{URLPattern: "/"}}},
+		{Endpoint: "/supu", CacheTTL: time.Minute, OutputEncoding: "no-op", Cache: &CacheConfig{}, Backend: []*Backend{&Backend{URLPattern: "/"}}},
+		{Endpoint: "/supu", CacheTTL: time.Minute, Cache: &CacheConfig{MaxSize: -1}, Backend: []*Backend{&Backend{URLPattern: "/"}}},
+	} {
+		subject := ServiceConfig{
+			Version:   1,
+			Host:      []string{"http://127.0.0.1:8080"},
+			Endpoints: []*EndpointConfig{e},
+		}
+		if err := subject.Init(); err == nil {
+			t.Errorf("#%d: Error expected at the configuration init with the cache %+v", i, e.Cache)
+		}
+	}
+}
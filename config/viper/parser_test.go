@@ -123,3 +123,40 @@ func TestNew_initError(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestNew_lb(t *testing.T) {
+	configPath := "/tmp/lb.json"
+	configContent := []byte(`{
+    "version": 1,
+    "host": ["http://127.0.0.1:8080", "http://127.0.0.1:8081"],
+    "endpoints": [
+        {
+            "endpoint": "/users/{user}",
+            "backend": [
+                {
+                    "url_pattern": "/users/{user}",
+                    "lb": {
+                        "strategy": "weighted_round_robin",
+                        "seed": 42,
+                        "weights": [3, 1],
+                        "hash_key": "param:user"
+                    }
+                }
+            ]
+        }
+    ]
+}`)
+	if err := ioutil.WriteFile(configPath, configContent, 0644); err != nil {
+		t.FailNow()
+	}
+	defer os.Remove(configPath)
+
+	cfg, err := New().Parse(configPath)
+	if err != nil {
+		t.Fatal("Unexpected error. Got", err.Error())
+	}
+	lb := cfg.Endpoints[0].Backend[0].LB
+	if lb.Strategy != "weighted_round_robin" || lb.Seed != 42 || len(lb.Weights) != 2 || lb.Weights[0] != 3 || lb.HashKey != "param:User" {
+		t.Errorf("unexpected lb config: %+v", lb)
+	}
+}
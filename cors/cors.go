@@ -0,0 +1,234 @@
+// Package cors provides the cross-origin resource sharing policies of the endpoints, shared by all
+// the routers
+package cors
+
+import (
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ph0m1/porta/config"
+)
+
+const (
+	headerOrigin           = "Origin"
+	headerRequestMethod    = "Access-Control-Request-Method"
+	headerRequestHeaders   = "Access-Control-Request-Headers"
+	headerAllowOrigin      = "Access-Control-Allow-Origin"
+	headerAllowMethods     = "Access-Control-Allow-Methods"
+	headerAllowHeaders     = "Access-Control-Allow-Headers"
+	headerAllowCredentials = "Access-Control-Allow-Credentials"
+	headerExposeHeaders    = "Access-Control-Expose-Headers"
+	headerMaxAge           = "Access-Control-Max-Age"
+)
+
+// Policy is the cross-origin resource sharing policy of an endpoint
+type Policy struct {
+	origins       []string
+	anyOrigin     bool
+	methods       []string
+	headers       map[string]struct{}
+	anyHeader     bool
+	exposeHeaders string
+	credentials   bool
+	maxAge        string
+}
+
+// New returns the policy defined by the received config, or nil when there is no config
+func New(cfg *config.CORSConfig) *Policy {
+	if cfg == nil {
+		return nil
+	}
+	p := &Policy{
+		methods:     cfg.AllowMethods,
+		headers:     map[string]struct{}{},
+		credentials: cfg.AllowCredentials,
+	}
+	for _, o := range cfg.AllowOrigins {
+		if o == "*" {
+			// any origin is never reflected with credentials, so the bare wildcard is not a pattern
+			p.anyOrigin = true
+			continue
+		}
+		p.origins = append(p.origins, strings.ToLower(o))
+	}
+	for _, h := range cfg.AllowHeaders {
+		if h == "*" {
+			p.anyHeader = true
+		}
+		p.headers[textproto.CanonicalMIMEHeaderKey(h)] = struct{}{}
+	}
+	if len(cfg.ExposeHeaders) > 0 {
+		p.exposeHeaders = strings.Join(cfg.ExposeHeaders, ", ")
+	}
+	if cfg.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+	return p
+}
+
+// SetHeaders adds the CORS headers to the response of an actual request, when its origin is allowed
+func (p *Policy) SetHeaders(h http.Header, r *http.Request) {
+	if !p.setOrigin(h, r) {
+		return
+	}
+	if p.exposeHeaders != "" {
+		h.Set(headerExposeHeaders, p.exposeHeaders)
+	}
+}
+
+// Handler returns a handler adding the CORS headers to the responses of the received one
+func (p *Policy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.SetHeaders(w.Header(), r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setOrigin sets the allowed origin of the response, reporting if the origin of the request is allowed
+func (p *Policy) setOrigin(h http.Header, r *http.Request) bool {
+	origin := r.Header.Get(headerOrigin)
+	if origin == "" {
+		return false
+	}
+	if p.anyOrigin && !p.credentials {
+		h.Set(headerAllowOrigin, "*")
+		return true
+	}
+	h.Add("Vary", headerOrigin)
+	if !p.allowOrigin(origin) {
+		return false
+	}
+	h.Set(headerAllowOrigin, origin)
+	if p.credentials {
+		h.Set(headerAllowCredentials, "true")
+	}
+	return true
+}
+
+func (p *Policy) allowOrigin(origin string) bool {
+	if p.anyOrigin && !p.credentials {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, o := range p.origins {
+		if matchOrigin(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrigin matches the origin with the pattern, where the * wildcard matches any sequence
+func matchOrigin(pattern, origin string) bool {
+	i := strings.IndexByte(pattern, '*')
+	if i == -1 {
+		return pattern == origin
+	}
+	prefix, suffix := pattern[:i], pattern[i+1:]
+	return len(origin) >= len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
+}
+
+// allowHeaders returns the requested headers when all of them are allowed
+func (p *Policy) allowHeaders(requested string) (string, bool) {
+	headers := []string{}
+	for _, h := range strings.Split(requested, ",") {
+		h = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(h))
+		if h == "" {
+			continue
+		}
+		if _, ok := p.headers[h]; !ok && !p.anyHeader {
+			return "", false
+		}
+		headers = append(headers, h)
+	}
+	return strings.Join(headers, ", "), true
+}
+
+// IsPreflight reports if the request is a CORS preflight request
+func IsPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get(headerOrigin) != "" && r.Header.Get(headerRequestMethod) != ""
+}
+
+// Policies are the policies of the endpoints sharing a path, by method
+type Policies map[string]*Policy
+
+// Preflight answers the preflight request with the policy of the endpoint handling the requested
+// method. The CORS headers are not added when the request is not allowed by the policy, so the
+// browser rejects the actual request
+func (ps Policies) Preflight(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Add("Vary", headerOrigin)
+	h.Add("Vary", headerRequestMethod)
+	h.Add("Vary", headerRequestHeaders)
+
+	method := strings.ToUpper(r.Header.Get(headerRequestMethod))
+	p, ok := ps[method]
+	if !ok && method == http.MethodHead {
+		p, ok = ps[http.MethodGet]
+	}
+	if !ok || p == nil || !p.allowMethod(method) || !p.allowOrigin(r.Header.Get(headerOrigin)) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	headers, ok := p.allowHeaders(r.Header.Get(headerRequestHeaders))
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	p.setOrigin(h, r)
+	h.Set(headerAllowMethods, strings.Join(p.allowedMethods(ps), ", "))
+	if headers != "" {
+		h.Set(headerAllowHeaders, headers)
+	}
+	if p.maxAge != "" {
+		h.Set(headerMaxAge, p.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler returns a handler answering the preflight requests and delegating the rest of requests
+// to the received handler. The rest of requests are rejected when there is no handler
+func (ps Policies) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsPreflight(r) {
+			ps.Preflight(w, r)
+			return
+		}
+		if next == nil {
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p *Policy) allowMethod(method string) bool {
+	if len(p.methods) == 0 {
+		return true
+	}
+	for _, m := range p.methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedMethods returns the configured methods or, by default, the ones of the endpoints with a policy
+func (p *Policy) allowedMethods(ps Policies) []string {
+	if len(p.methods) > 0 {
+		return p.methods
+	}
+	methods := []string{}
+	for m, policy := range ps {
+		if policy != nil {
+			methods = append(methods, m)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
@@ -0,0 +1,168 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func request(method, origin string, headers ...string) *http.Request {
+	r := httptest.NewRequest(method, "/supu", nil)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	for i := 0; i < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	return r
+}
+
+func TestPolicy_SetHeaders(t *testing.T) {
+	p := New(&config.CORSConfig{
+		AllowOrigins:     []string{"https://supu.com", "https://*.tupu.com"},
+		ExposeHeaders:    []string{"X-Total", "X-Page"},
+		AllowCredentials: true,
+	})
+	for origin, allowed := range map[string]bool{
+		"https://supu.com":       true,
+		"HTTPS://SUPU.COM":       true,
+		"https://api.tupu.com":   true,
+		"https://tupu.com":       false,
+		"http://api.tupu.com":    false,
+		"https://supu.com.evil":  false,
+		"https://evil.com?.tupu": false,
+	} {
+		h := http.Header{}
+		p.SetHeaders(h, request("GET", origin))
+		if allowed != (h.Get("Access-Control-Allow-Origin") == origin) {
+			t.Errorf("%s: unexpected headers %v", origin, h)
+		}
+		if allowed && (h.Get("Access-Control-Allow-Credentials") != "true" || h.Get("Access-Control-Expose-Headers") != "X-Total, X-Page") {
+			t.Errorf("%s: unexpected headers %v", origin, h)
+		}
+		if h.Get("Vary") != "Origin" {
+			t.Errorf("%s: the response should vary by origin: %v", origin, h)
+		}
+	}
+
+	h := http.Header{}
+	p.SetHeaders(h, request("GET", ""))
+	if len(h) != 0 {
+		t.Errorf("unexpected headers without origin: %v", h)
+	}
+
+	h = http.Header{}
+	New(&config.CORSConfig{AllowOrigins: []string{"*"}}).SetHeaders(h, request("GET", "https://supu.com"))
+	if h.Get("Access-Control-Allow-Origin") != "*" || h.Get("Vary") != "" {
+		t.Errorf("unexpected headers for any origin: %v", h)
+	}
+
+	h = http.Header{}
+	New(&config.CORSConfig{AllowOrigins: []string{"*"}, AllowCredentials: true}).SetHeaders(h, request("GET", "https://supu.com"))
+	if h.Get("Access-Control-Allow-Origin") != "" || h.Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("any origin should not be reflected with credentials: %v", h)
+	}
+
+	if New(nil) != nil {
+		t.Error("unexpected policy without config")
+	}
+}
+
+func TestPolicies_Preflight(t *testing.T) {
+	get := New(&config.CORSConfig{
+		AllowOrigins: []string{"https://supu.com"},
+		AllowHeaders: []string{"authorization", "X-Trace-Id"},
+		MaxAge:       10 * time.Minute,
+	})
+	post := New(&config.CORSConfig{AllowOrigins: []string{"*"}, AllowMethods: []string{"POST", "PUT"}, AllowHeaders: []string{"*"}})
+	ps := Policies{"GET": get, "POST": post, "DELETE": nil}
+
+	for _, tc := range []struct {
+		name    string
+		r       *http.Request
+		headers map[string]string
+	}{
+		{
+			name: "get",
+			r:    request("OPTIONS", "https://supu.com", "Access-Control-Request-Method", "GET", "Access-Control-Request-Headers", "authorization, x-trace-id"),
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":  "https://supu.com",
+				"Access-Control-Allow-Methods": "GET, POST",
+				"Access-Control-Allow-Headers": "Authorization, X-Trace-Id",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name:    "head",
+			r:       request("OPTIONS", "https://supu.com", "Access-Control-Request-Method", "HEAD"),
+			headers: map[string]string{"Access-Control-Allow-Origin": "https://supu.com"},
+		},
+		{
+			name:    "forbidden header",
+			r:       request("OPTIONS", "https://supu.com", "Access-Control-Request-Method", "GET", "Access-Control-Request-Headers", "X-Custom"),
+			headers: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:    "forbidden origin",
+			r:       request("OPTIONS", "https://tupu.com", "Access-Control-Request-Method", "GET"),
+			headers: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name: "post",
+			r:    request("OPTIONS", "https://tupu.com", "Access-Control-Request-Method", "POST", "Access-Control-Request-Headers", "X-Custom"),
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":  "*",
+				"Access-Control-Allow-Methods": "POST, PUT",
+				"Access-Control-Allow-Headers": "X-Custom",
+			},
+		},
+		{
+			name:    "endpoint without policy",
+			r:       request("OPTIONS", "https://supu.com", "Access-Control-Request-Method", "DELETE"),
+			headers: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:    "unknown endpoint",
+			r:       request("OPTIONS", "https://supu.com", "Access-Control-Request-Method", "PATCH"),
+			headers: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+	} {
+		if !IsPreflight(tc.r) {
+			t.Errorf("%s: the request should be a preflight one", tc.name)
+		}
+		w := httptest.NewRecorder()
+		ps.Preflight(w, tc.r)
+		if w.Code != http.StatusNoContent {
+			t.Errorf("%s: unexpected status code %d", tc.name, w.Code)
+		}
+		for k, v := range tc.headers {
+			if have := w.Header().Get(k); have != v {
+				t.Errorf("%s: %s: want %q, have %q", tc.name, k, v, have)
+			}
+		}
+	}
+}
+
+func TestPolicies_Handler(t *testing.T) {
+	ps := Policies{"GET": New(&config.CORSConfig{AllowOrigins: []string{"*"}})}
+	options := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+
+	for _, tc := range []struct {
+		next   http.Handler
+		r      *http.Request
+		status int
+	}{
+		{options, request("OPTIONS", "https://supu.com", "Access-Control-Request-Method", "GET"), http.StatusNoContent},
+		{options, request("OPTIONS", "https://supu.com"), http.StatusTeapot},
+		{nil, request("OPTIONS", ""), http.StatusMethodNotAllowed},
+	} {
+		w := httptest.NewRecorder()
+		ps.Handler(tc.next).ServeHTTP(w, tc.r)
+		if w.Code != tc.status {
+			t.Errorf("want %d, have %d", tc.status, w.Code)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package encoding
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+)
+
+// CSV is the name of the encoding decoding comma separated values
+const CSV = "csv"
+
+// CSVRowsKey is the key of the decoded map holding the rows of a CSV document
+const CSVRowsKey = "rows"
+
+// ErrInvalidDelimiter is returned when the CSV delimiter is a quote, a line break or an invalid rune
+var ErrInvalidDelimiter = errors.New("invalid csv delimiter")
+
+// CSVDecoder decodes a comma separated document. See NewCSVDecoder
+var CSVDecoder = NewCSVDecoder(',')
+
+// NewCSVDecoder returns a decoder of CSV documents using the received delimiter. The first row is
+// the header, naming the fields of the following ones, and the rows are returned as a list of
+// objects under the CSVRowsKey. All the values are strings and every row must have the same number
+// of fields as the header
+func NewCSVDecoder(delimiter rune) Decoder {
+	return func(r io.Reader, v *map[string]interface{}) error {
+		cr := csv.NewReader(r)
+		cr.Comma = delimiter
+		cr.ReuseRecord = true
+
+		rows := []interface{}{}
+		var header []string
+		for {
+			record, err := cr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if header == nil {
+				header = make([]string, len(record))
+				copy(header, record)
+				// the reports exported by some tools start with a byte order mark
+				header[0] = strings.TrimPrefix(header[0], "\ufeff")
+				continue
+			}
+			row := make(map[string]interface{}, len(header))
+			for i, name := range header {
+				row[name] = record[i]
+			}
+			rows = append(rows, row)
+		}
+		*v = map[string]interface{}{CSVRowsKey: rows}
+		return nil
+	}
+}
+
+// ValidCSVDelimiter reports if the received rune can be used as the delimiter of a CSV document
+func ValidCSVDelimiter(delimiter rune) bool {
+	return delimiter != 0 && delimiter != '"' && delimiter != '\r' && delimiter != '\n' && delimiter != 0xFFFD
+}
@@ -0,0 +1,57 @@
+package encoding
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCSVDecoder(t *testing.T) {
+	var v map[string]interface{}
+	body := "\ufeffid,name,tags\n1,supu,\"a,b\"\n2,tupu,\n"
+	if err := CSVDecoder(strings.NewReader(body), &v); err != nil {
+		t.Fatal(err)
+	}
+	if rows := fmt.Sprint(v[CSVRowsKey]); rows != "[map[id:1 name:supu tags:a,b] map[id:2 name:tupu tags:]]" {
+		t.Errorf("unexpected rows: %s", rows)
+	}
+
+	if err := NewCSVDecoder(';')(strings.NewReader("id;name\n1;supu\n"), &v); err != nil {
+		t.Fatal(err)
+	}
+	if rows := fmt.Sprint(v[CSVRowsKey]); rows != "[map[id:1 name:supu]]" {
+		t.Errorf("unexpected rows: %s", rows)
+	}
+
+	if err := CSVDecoder(strings.NewReader(""), &v); err != nil {
+		t.Fatal(err)
+	}
+	if rows, ok := v[CSVRowsKey].([]interface{}); !ok || len(rows) != 0 {
+		t.Errorf("unexpected rows: %v", v)
+	}
+
+	if err := CSVDecoder(strings.NewReader("id,name\n1\n"), &v); err == nil {
+		t.Error("error expected with a row missing fields")
+	}
+}
+
+func TestValidCSVDelimiter(t *testing.T) {
+	for r, want := range map[rune]bool{',': true, ';': true, '\t': true, '|': true, '"': false, '\n': false, '\r': false, 0: false} {
+		if ValidCSVDelimiter(r) != want {
+			t.Errorf("%q: want %v", r, want)
+		}
+	}
+}
+
+func TestFormDecoder(t *testing.T) {
+	var v map[string]interface{}
+	if err := FormDecoder(strings.NewReader("supu=tupu&tags=a&tags=b&empty=&space=a+b%21"), &v); err != nil {
+		t.Fatal(err)
+	}
+	if res := fmt.Sprint(v); res != "map[empty: space:a b! supu:tupu tags:[a b]]" {
+		t.Errorf("unexpected result: %s", res)
+	}
+	if err := FormDecoder(strings.NewReader("supu=%zz"), &v); err == nil {
+		t.Error("error expected with an invalid body")
+	}
+}
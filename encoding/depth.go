@@ -0,0 +1,270 @@
+package encoding
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// DefaultMaxDepth is the max nesting level accepted by the XML decoders, the same one enforced by
+// the encoding/json pkg
+const DefaultMaxDepth = 10000
+
+// ErrMaxDepthExceeded is returned when the decoded document is nested deeper than allowed
+var ErrMaxDepthExceeded = errors.New("max depth exceeded")
+
+// NewMaxDepthDecoder returns a decoder rejecting the documents decoded by d with more than maxDepth
+// nesting levels. The root map is the first level. The JSON and XML documents are rejected while
+// they are read, as soon as they are nested too deep, so the decoder never builds the nested values
+// and they can't exhaust the memory or the stack. The documents in other formats are only checked
+// once decoded
+func NewMaxDepthDecoder(d Decoder, maxDepth int) Decoder {
+	return func(r io.Reader, v *map[string]interface{}) error {
+		lr := &depthLimitedReader{r: r, max: maxDepth}
+		if err := d(lr, v); err != nil {
+			// the decoders may wrap the read errors
+			if lr.exceeded {
+				return ErrMaxDepthExceeded
+			}
+			return err
+		}
+		if depth(*v, maxDepth) > maxDepth {
+			return ErrMaxDepthExceeded
+		}
+		return nil
+	}
+}
+
+// depth returns the nesting level of v, capped at limit+1 so the walk stops as soon as it is over the limit
+func depth(v interface{}, limit int) int {
+	var children []interface{}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, e := range t {
+			children = append(children, e)
+		}
+	case map[interface{}]interface{}:
+		for _, e := range t {
+			children = append(children, e)
+		}
+	case []interface{}:
+		children = t
+	default:
+		return 0
+	}
+	if limit <= 0 {
+		return 1
+	}
+	max := 0
+	for _, e := range children {
+		if d := depth(e, limit-1); d > max {
+			max = d
+			if max >= limit {
+				break
+			}
+		}
+	}
+	return max + 1
+}
+
+// formats of the documents read by a depthLimitedReader, detected from their first byte
+const (
+	formatUnknown = iota
+	formatJSON
+	formatXML
+	formatOther
+)
+
+// states of the XML scanner of a depthLimitedReader
+const (
+	xmlText = iota
+	// after a <
+	xmlMarkup
+	xmlStartTag
+	xmlEndTag
+	// after a <!, until it is known to start a comment, a CDATA section or a declaration
+	xmlBang
+	xmlDeclaration
+	// skipping a comment, a CDATA section or a processing instruction until its terminator
+	xmlSkip
+)
+
+// depthLimitedReader reads a JSON or XML document failing as soon as its nesting level exceeds the
+// max depth. The JSON objects and arrays and the XML elements are a level each. The reader does not
+// validate the documents, so the malformed ones are left to the decoders
+type depthLimitedReader struct {
+	r        io.Reader
+	max      int
+	format   int
+	depth    int
+	exceeded bool
+
+	// json scanner
+	inString, escaped bool
+
+	// xml scanner
+	state    int
+	quote    byte
+	last     byte
+	brackets int
+	bang     []byte
+	term     string
+	tail     []byte
+}
+
+func (l *depthLimitedReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, ErrMaxDepthExceeded
+	}
+	n, err := l.r.Read(p)
+	for i := 0; i < n; i++ {
+		if !l.scan(p[i]) {
+			l.exceeded = true
+			return i, ErrMaxDepthExceeded
+		}
+	}
+	return n, err
+}
+
+// scan processes the next byte of the document, reporting whether the max depth is not exceeded
+func (l *depthLimitedReader) scan(b byte) bool {
+	switch l.format {
+	case formatUnknown:
+		switch b {
+		case ' ', '\t', '\r', '\n', 0xef, 0xbb, 0xbf:
+			// the leading spaces and the UTF-8 byte order mark
+			return true
+		case '{', '[':
+			l.format = formatJSON
+		case '<':
+			l.format = formatXML
+		default:
+			l.format = formatOther
+			return true
+		}
+		return l.scan(b)
+	case formatJSON:
+		return l.scanJSON(b)
+	case formatXML:
+		return l.scanXML(b)
+	}
+	return true
+}
+
+func (l *depthLimitedReader) scanJSON(b byte) bool {
+	if l.inString {
+		switch {
+		case l.escaped:
+			l.escaped = false
+		case b == '\\':
+			l.escaped = true
+		case b == '"':
+			l.inString = false
+		}
+		return true
+	}
+	switch b {
+	case '"':
+		l.inString = true
+	case '{', '[':
+		l.depth++
+		return l.depth <= l.max
+	case '}', ']':
+		l.depth--
+	}
+	return true
+}
+
+func (l *depthLimitedReader) scanXML(b byte) bool {
+	switch l.state {
+	case xmlText:
+		if b == '<' {
+			l.state = xmlMarkup
+		}
+	case xmlMarkup:
+		switch b {
+		case '/':
+			l.state = xmlEndTag
+		case '?':
+			l.skipUntil("?>")
+		case '!':
+			l.state = xmlBang
+			l.bang = l.bang[:0]
+		default:
+			l.state = xmlStartTag
+			l.quote = 0
+			l.last = b
+			l.depth++
+			return l.depth <= l.max
+		}
+	case xmlStartTag:
+		switch {
+		case l.quote != 0:
+			if b == l.quote {
+				l.quote = 0
+			}
+		case b == '"' || b == '\'':
+			l.quote = b
+		case b == '>':
+			// the empty elements (<a/>) are closed by their start tag
+			if l.last == '/' {
+				l.depth--
+			}
+			l.state = xmlText
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			l.last = b
+		}
+	case xmlEndTag:
+		if b == '>' {
+			l.depth--
+			l.state = xmlText
+		}
+	case xmlBang:
+		l.bang = append(l.bang, b)
+		switch bang := string(l.bang); {
+		case bang == "--":
+			l.skipUntil("-->")
+		case bang == "[CDATA[":
+			l.skipUntil("]]>")
+		case !strings.HasPrefix("--", bang) && !strings.HasPrefix("[CDATA[", bang):
+			l.state = xmlDeclaration
+			l.quote = 0
+			l.brackets = 0
+			for _, c := range l.bang {
+				l.scanXML(c)
+			}
+		}
+	case xmlDeclaration:
+		switch {
+		case l.quote != 0:
+			if b == l.quote {
+				l.quote = 0
+			}
+		case b == '"' || b == '\'':
+			l.quote = b
+		case b == '[':
+			l.brackets++
+		case b == ']':
+			l.brackets--
+		case b == '>' && l.brackets <= 0:
+			l.state = xmlText
+		}
+	case xmlSkip:
+		if len(l.tail) == len(l.term) {
+			copy(l.tail, l.tail[1:])
+			l.tail = l.tail[:len(l.tail)-1]
+		}
+		l.tail = append(l.tail, b)
+		if string(l.tail) == l.term {
+			l.state = xmlText
+		}
+	}
+	return true
+}
+
+func (l *depthLimitedReader) skipUntil(term string) {
+	l.state = xmlSkip
+	l.term = term
+	l.tail = l.tail[:0]
+}
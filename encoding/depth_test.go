@@ -0,0 +1,96 @@
+package encoding
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNewMaxDepthDecoder(t *testing.T) {
+	decoder := NewMaxDepthDecoder(JSONDecoder, 3)
+	for body, want := range map[string]error{
+		`{"a":1}`:                   nil,
+		`{"a":{"b":[1,2]}}`:         nil,
+		`{"a":{"b":[{"c":1}]}}`:     ErrMaxDepthExceeded,
+		`{"a":1,"b":[[[1]]],"c":2}`: ErrMaxDepthExceeded,
+	} {
+		var v map[string]interface{}
+		if err := decoder(strings.NewReader(body), &v); err != want {
+			t.Errorf("%s: want %v, have %v", body, want, err)
+		}
+	}
+}
+
+func TestXMLDecoder_maxDepth(t *testing.T) {
+	doc := strings.Repeat("<a>", DefaultMaxDepth+1) + strings.Repeat("</a>", DefaultMaxDepth+1)
+	var v map[string]interface{}
+	if err := XMLDecoder(strings.NewReader(doc), &v); err != ErrMaxDepthExceeded {
+		t.Errorf("want %v, have %v", ErrMaxDepthExceeded, err)
+	}
+}
+
+// endlessReader repeats the same string forever
+type endlessReader struct {
+	s string
+	i int
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.s[r.i%len(r.s)]
+		r.i++
+	}
+	return len(p), nil
+}
+
+func TestNewMaxDepthDecoder_whileReading(t *testing.T) {
+	for name, d := range map[string]Decoder{"json": JSONDecoder, "xml": XMLDecoder} {
+		prefix, nested := `{"a":`, &endlessReader{s: "["}
+		if name == "xml" {
+			prefix, nested = "<a>", &endlessReader{s: "<b>"}
+		}
+		// the documents never end, so they can only be rejected before they are decoded
+		var v map[string]interface{}
+		err := NewMaxDepthDecoder(d, 10)(io.MultiReader(strings.NewReader(prefix), nested), &v)
+		if err != ErrMaxDepthExceeded {
+			t.Errorf("%s: want %v, have %v", name, ErrMaxDepthExceeded, err)
+		}
+	}
+}
+
+func TestNewMaxDepthDecoder_xml(t *testing.T) {
+	decoder := NewMaxDepthDecoder(XMLDecoder, 3)
+	for body, want := range map[string]error{
+		`<a><b><c>1</c></b></a>`: nil,
+		`<?xml version="1.0"?><!DOCTYPE a [<!ELEMENT a ANY>]><a><!-- <b><c><d> --><b x="/>"><c>1</c></b></a>`: nil,
+		`<a><b><![CDATA[<c><d><e>]]></b><b/><b/></a>`:                                                         nil,
+		`<a><b><c><d>1</d></c></b></a>`:                                                                       ErrMaxDepthExceeded,
+		`<a><b><c><d/></c></b></a>`:                                                                           ErrMaxDepthExceeded,
+	} {
+		var v map[string]interface{}
+		if err := decoder(strings.NewReader(body), &v); err != want {
+			t.Errorf("%s: want %v, have %v", body, want, err)
+		}
+	}
+}
+
+func TestDepthLimitedReader(t *testing.T) {
+	for body, want := range map[string]int{
+		`{"a":"[{[{","b":"\"{[","c":[[1]]}`:         3,
+		` <a x='>' y="<b>"><b/><c></c><d>x</d></a>`: 2,
+		"<a><!-- --->--><b></b></a>":                2,
+		"supu: [[[[1]]]]":                           0,
+	} {
+		l := &depthLimitedReader{r: strings.NewReader(body), max: 100}
+		max := 0
+		for i := 0; i < len(body); i++ {
+			l.scan(body[i])
+			if l.depth > max {
+				max = l.depth
+			}
+		}
+		if max != want || l.depth != 0 {
+			t.Errorf("%s: want %d, have %d (%d)", body, want, max, l.depth)
+		}
+	}
+}
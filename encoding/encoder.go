@@ -0,0 +1,164 @@
+package encoding
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-yaml/yaml"
+)
+
+// Encoder writes the received data into w
+type Encoder func(w io.Writer, v map[string]interface{}) error
+
+const (
+	// NOOP is the name of the encoding passing the backend response to the client untouched
+	NOOP = "no-op"
+	// NEGOTIATE is the name of the output encoding selecting the encoder from the Accept header
+	NEGOTIATE = "negotiate"
+)
+
+type encoderEntry struct {
+	name       string
+	encoder    Encoder
+	mediaTypes []string
+}
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = []encoderEntry{
+		{JSON, JSONEncoder, []string{"application/json", "text/json"}},
+		{"xml", XMLEncoder, []string{"application/xml", "text/xml"}},
+		{"yaml", YAMLEncoder, []string{"application/x-yaml", "application/yaml", "text/yaml"}},
+		{"msgpack", MsgPackEncoder, []string{"application/msgpack", "application/x-msgpack"}},
+	}
+)
+
+// RegisterEncoder makes an encoder available by the provided name, so it can be selected from the
+// output_encoding field of the endpoint config or by the Accept header of the requests. The first
+// media type is the one used as Content-Type of the responses
+func RegisterEncoder(name string, e Encoder, mediaTypes ...string) {
+	entry := encoderEntry{strings.ToLower(name), e, mediaTypes}
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	for i := range encoders {
+		if encoders[i].name == entry.name {
+			encoders[i] = entry
+			return
+		}
+	}
+	encoders = append(encoders, entry)
+}
+
+// GetEncoder returns the encoder registered with the provided name and its Content-Type
+func GetEncoder(name string) (Encoder, string, error) {
+	name = strings.ToLower(name)
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	for _, e := range encoders {
+		if e.name == name {
+			return e.encoder, contentType(e), nil
+		}
+	}
+	return nil, "", ErrUnknownEncoding
+}
+
+// Negotiate returns the name of the registered encoder best matching the received Accept header
+// value, honouring the quality factors. JSON is selected when the header is empty or accepts any
+// media type. It returns false when none of the accepted media types has an encoder
+func Negotiate(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return JSON, true
+	}
+
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	ranges := []mediaRange{}
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, mediaRange{mt, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	for _, r := range ranges {
+		if r.mediaType == "*/*" {
+			return JSON, true
+		}
+		for _, e := range encoders {
+			for _, mt := range e.mediaTypes {
+				if mt == r.mediaType || (strings.HasSuffix(r.mediaType, "/*") && strings.HasPrefix(mt, strings.TrimSuffix(r.mediaType, "*"))) {
+					return e.name, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+func contentType(e encoderEntry) string {
+	if len(e.mediaTypes) == 0 {
+		return "application/octet-stream"
+	}
+	return e.mediaTypes[0]
+}
+
+// JSONEncoder writes the data as a JSON document
+func JSONEncoder(w io.Writer, v map[string]interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// YAMLEncoder writes the data as a YAML document
+func YAMLEncoder(w io.Writer, v map[string]interface{}) error {
+	b, err := yaml.Marshal(nativeNumbers(v))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// nativeNumbers returns a copy of the received value with the json.Number replaced by int64 or float64
+func nativeNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		if f, err := t.Float64(); err == nil {
+			return f
+		}
+		return t.String()
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			res[k] = nativeNumbers(e)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(t))
+		for i, e := range t {
+			res[i] = nativeNumbers(e)
+		}
+		return res
+	}
+	return v
+}
@@ -0,0 +1,136 @@
+package encoding
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                                      "json",
+		"*/*":                                   "json",
+		"application/xml":                       "xml",
+		"text/html, application/xml;q=0.9":      "xml",
+		"application/json;q=0.5, text/yaml":     "yaml",
+		"application/x-msgpack, */*;q=0.1":      "msgpack",
+		"application/msgpack;q=0, text/*;q=0.3": "json",
+	} {
+		name, ok := Negotiate(accept)
+		if !ok {
+			t.Errorf("%s: no encoder selected", accept)
+			continue
+		}
+		if name != want {
+			t.Errorf("%s: want %s, have %s", accept, want, name)
+		}
+	}
+	for _, accept := range []string{"text/html", "image/*", "application/xml;q=0"} {
+		if name, ok := Negotiate(accept); ok {
+			t.Errorf("%s: unexpected encoder %s", accept, name)
+		}
+	}
+}
+
+func TestRegisterEncoder(t *testing.T) {
+	RegisterEncoder("Plain", func(w io.Writer, v map[string]interface{}) error {
+		_, err := io.WriteString(w, "plain")
+		return err
+	}, "text/plain")
+
+	name, ok := Negotiate("text/plain")
+	if !ok || name != "plain" {
+		t.Errorf("unexpected negotiation result: %s %v", name, ok)
+	}
+	encoder, contentType, err := GetEncoder("plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "text/plain" {
+		t.Errorf("unexpected content type: %s", contentType)
+	}
+	buf := new(bytes.Buffer)
+	if err := encoder(buf, nil); err != nil || buf.String() != "plain" {
+		t.Errorf("unexpected result: %s %v", buf.String(), err)
+	}
+	if _, _, err := GetEncoder("unknown"); err != ErrUnknownEncoding {
+		t.Errorf("want %v, have %v", ErrUnknownEncoding, err)
+	}
+}
+
+func sampleData() map[string]interface{} {
+	return map[string]interface{}{
+		"id":    json.Number("42"),
+		"price": json.Number("1.5"),
+		"name":  "supu",
+		"tags":  []interface{}{"a", "b"},
+		"ok":    true,
+		"none":  nil,
+	}
+}
+
+func TestYAMLEncoder(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := YAMLEncoder(buf, sampleData()); err != nil {
+		t.Fatal(err)
+	}
+	want := "id: 42\nname: supu\nnone: null\nok: true\nprice: 1.5\ntags:\n- a\n- b\n"
+	if buf.String() != want {
+		t.Errorf("unexpected result:\n%s", buf.String())
+	}
+}
+
+func TestXMLEncoder(t *testing.T) {
+	data := sampleData()
+	data["user"] = map[string]interface{}{"-lang": "en", "#text": "tupu & co", "1st name": "foo"}
+	buf := new(bytes.Buffer)
+	if err := XMLEncoder(buf, data); err != nil {
+		t.Fatal(err)
+	}
+	want := `<response><id>42</id><name>supu</name><none></none><ok>true</ok><price>1.5</price><tags>a</tags><tags>b</tags>` +
+		`<user lang="en">tupu &amp; co<_1st_name>foo</_1st_name></user></response>`
+	if buf.String() != want {
+		t.Errorf("unexpected result:\n%s", buf.String())
+	}
+
+	var decoded map[string]interface{}
+	if err := XMLDecoder(strings.NewReader(buf.String()), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if tags := decoded["response"].(map[string]interface{})["tags"]; len(tags.([]interface{})) != 2 {
+		t.Errorf("unexpected tags: %v", tags)
+	}
+}
+
+func TestMsgPackEncoder(t *testing.T) {
+	for _, tc := range []struct {
+		data map[string]interface{}
+		want []byte
+	}{
+		{map[string]interface{}{}, []byte{0x80}},
+		{map[string]interface{}{"a": json.Number("1")}, []byte{0x81, 0xa1, 'a', 0x01}},
+		{map[string]interface{}{"a": json.Number("-1")}, []byte{0x81, 0xa1, 'a', 0xff}},
+		{map[string]interface{}{"a": json.Number("300")}, []byte{0x81, 0xa1, 'a', 0xcd, 0x01, 0x2c}},
+		{map[string]interface{}{"a": json.Number("-200")}, []byte{0x81, 0xa1, 'a', 0xd1, 0xff, 0x38}},
+		{map[string]interface{}{"a": json.Number("12345678901234567890")}, []byte{0x81, 0xa1, 'a', 0xcf, 0xab, 0x54, 0xa9, 0x8c, 0xeb, 0x1f, 0x0a, 0xd2}},
+		{map[string]interface{}{"a": json.Number("1.5")}, []byte{0x81, 0xa1, 'a', 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{map[string]interface{}{"a": nil, "b": true, "c": false}, []byte{0x83, 0xa1, 'a', 0xc0, 0xa1, 'b', 0xc3, 0xa1, 'c', 0xc2}},
+		{map[string]interface{}{"a": []interface{}{"x", 2.0}}, []byte{0x81, 0xa1, 'a', 0x92, 0xa1, 'x', 0xcb, 0x40, 0, 0, 0, 0, 0, 0, 0}},
+		{map[string]interface{}{"a": strings.Repeat("x", 40)}, append([]byte{0x81, 0xa1, 'a', 0xd9, 40}, strings.Repeat("x", 40)...)},
+	} {
+		buf := new(bytes.Buffer)
+		if err := MsgPackEncoder(buf, tc.data); err != nil {
+			t.Errorf("%v: unexpected error %v", tc.data, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), tc.want) {
+			t.Errorf("%v: want %x, have %x", tc.data, tc.want, buf.Bytes())
+		}
+	}
+
+	if err := MsgPackEncoder(new(bytes.Buffer), map[string]interface{}{"a": struct{}{}}); err == nil {
+		t.Error("error expected with an unsupported type")
+	}
+}
@@ -1,6 +1,108 @@
 package encoding
 
-import "io"
+import (
+	"errors"
+	"io"
+	"mime"
+	"strings"
+	"sync"
+)
 
 // Read from r, into map of interfaces
 type Decoder func(r io.Reader, v *map[string]interface{}) error
+
+const (
+	// JSON is the name of the default encoding
+	JSON = "json"
+	// AUTO is the name of the encoding selecting the decoder from the Content-Type of every response
+	AUTO = "auto"
+)
+
+// ErrUnknownEncoding is returned when there is no decoder registered with the requested name
+var ErrUnknownEncoding = errors.New("unknown encoding")
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[string]Decoder{
+		JSON:   JSONDecoder,
+		"xml":  XMLDecoder,
+		"yaml": YAMLDecoder,
+		"toml": TOMLDecoder,
+		CSV:    CSVDecoder,
+		FORM:   FormDecoder,
+	}
+	mediaTypes = map[string]string{
+		"application/json":                  JSON,
+		"text/json":                         JSON,
+		"application/xml":                   "xml",
+		"text/xml":                          "xml",
+		"application/yaml":                  "yaml",
+		"application/x-yaml":                "yaml",
+		"text/yaml":                         "yaml",
+		"application/toml":                  "toml",
+		"text/csv":                          CSV,
+		"application/csv":                   CSV,
+		"application/x-www-form-urlencoded": FORM,
+	}
+	suffixes = map[string]string{
+		"+json": JSON,
+		"+xml":  "xml",
+		"+yaml": "yaml",
+	}
+)
+
+// Register makes a decoder available by the provided name, so it can be selected from the encoding
+// field of the backend config. The decoder is also used by the auto encoding for the received media
+// types. Registering an already registered name replaces it
+func Register(name string, d Decoder, mediaTypes ...string) {
+	name = strings.ToLower(name)
+	decodersMu.Lock()
+	decoders[name] = d
+	for _, mt := range mediaTypes {
+		registerMediaType(strings.ToLower(mt), name)
+	}
+	decodersMu.Unlock()
+}
+
+func registerMediaType(mt, name string) {
+	if strings.HasPrefix(mt, "+") {
+		suffixes[mt] = name
+		return
+	}
+	mediaTypes[mt] = name
+}
+
+// Get returns the decoder registered with the provided name
+func Get(name string) (Decoder, error) {
+	decodersMu.RLock()
+	d, ok := decoders[strings.ToLower(name)]
+	decodersMu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownEncoding
+	}
+	return d, nil
+}
+
+// ForContentType returns the decoder registered for the media type of the received Content-Type
+// header value, matching also the structured syntax suffixes (like application/problem+json)
+func ForContentType(contentType string) (Decoder, bool) {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+
+	name, ok := mediaTypes[mt]
+	if !ok {
+		if i := strings.LastIndex(mt, "+"); i != -1 {
+			name, ok = suffixes[mt[i:]]
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+	d, ok := decoders[name]
+	return d, ok
+}
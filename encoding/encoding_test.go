@@ -0,0 +1,85 @@
+package encoding
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	for _, name := range []string{"json", "JSON", "xml", "yaml", "toml", "csv", "form"} {
+		if _, err := Get(name); err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+	}
+	if _, err := Get("unknown"); err != ErrUnknownEncoding {
+		t.Errorf("want %v, have %v", ErrUnknownEncoding, err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	decoder := func(r io.Reader, v *map[string]interface{}) error {
+		b, err := io.ReadAll(r)
+		*v = map[string]interface{}{"content": string(b)}
+		return err
+	}
+	Register("Plain", decoder, "text/plain", "+plain")
+
+	for _, d := range []Decoder{mustGet(t, "plain"), mustForContentType(t, "text/plain; charset=utf-8"), mustForContentType(t, "application/vnd.supu+plain")} {
+		var v map[string]interface{}
+		if err := d(strings.NewReader("supu"), &v); err != nil {
+			t.Error(err)
+		}
+		if v["content"] != "supu" {
+			t.Errorf("unexpected result: %v", v)
+		}
+	}
+}
+
+func TestForContentType(t *testing.T) {
+	for contentType, body := range map[string]string{
+		"application/json":                  `{"a":1}`,
+		"application/json; charset=utf-8":   `{"a":1}`,
+		"application/problem+json":          `{"a":1}`,
+		"application/x-yaml":                "a: 1",
+		"text/yaml":                         "a: 1",
+		"application/toml":                  "a = 1",
+		"text/csv; header=present":          "a\n1",
+		"application/x-www-form-urlencoded": "a=1",
+	} {
+		d, ok := ForContentType(contentType)
+		if !ok {
+			t.Errorf("%s: decoder not found", contentType)
+			continue
+		}
+		var v map[string]interface{}
+		if err := d(strings.NewReader(body), &v); err != nil {
+			t.Errorf("%s: unexpected error %v", contentType, err)
+		}
+		if len(v) != 1 {
+			t.Errorf("%s: unexpected result %v", contentType, v)
+		}
+	}
+
+	for _, contentType := range []string{"", "text/html", "application/octet-stream", "not a media type/"} {
+		if _, ok := ForContentType(contentType); ok {
+			t.Errorf("%s: unexpected decoder", contentType)
+		}
+	}
+}
+
+func mustGet(t *testing.T, name string) Decoder {
+	d, err := Get(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func mustForContentType(t *testing.T, contentType string) Decoder {
+	d, ok := ForContentType(contentType)
+	if !ok {
+		t.Fatal("decoder not found for", contentType)
+	}
+	return d
+}
@@ -0,0 +1,36 @@
+package encoding
+
+import (
+	"io"
+	"net/url"
+)
+
+// FORM is the name of the encoding decoding application/x-www-form-urlencoded bodies
+const FORM = "form"
+
+// FormDecoder decodes an application/x-www-form-urlencoded body. The keys with a single value are
+// decoded as strings and the repeated ones as lists of strings
+func FormDecoder(r io.Reader, v *map[string]interface{}) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	values, err := url.ParseQuery(string(b))
+	if err != nil {
+		return err
+	}
+	res := make(map[string]interface{}, len(values))
+	for k, vs := range values {
+		if len(vs) == 1 {
+			res[k] = vs[0]
+			continue
+		}
+		list := make([]interface{}, len(vs))
+		for i, s := range vs {
+			list[i] = s
+		}
+		res[k] = list
+	}
+	*v = res
+	return nil
+}
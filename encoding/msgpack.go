@@ -0,0 +1,182 @@
+package encoding
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// MsgPackEncoder writes the data as a MessagePack document. The json.Number values are encoded as
+// integers when possible and as float64 otherwise. Map keys are sorted, so the output is stable
+func MsgPackEncoder(w io.Writer, v map[string]interface{}) error {
+	bw := bufio.NewWriter(w)
+	if err := msgpackEncode(bw, v); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func msgpackEncode(w *bufio.Writer, v interface{}) error {
+	switch t := v.(type) {
+	case nil:
+		return w.WriteByte(0xc0)
+	case bool:
+		if t {
+			return w.WriteByte(0xc3)
+		}
+		return w.WriteByte(0xc2)
+	case string:
+		msgpackString(w, t)
+		return nil
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			msgpackInt(w, i)
+			return nil
+		}
+		if u, err := strconv.ParseUint(string(t), 10, 64); err == nil {
+			msgpackUint(w, u)
+			return nil
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return err
+		}
+		msgpackFloat(w, f)
+		return nil
+	case int:
+		msgpackInt(w, int64(t))
+	case int8:
+		msgpackInt(w, int64(t))
+	case int16:
+		msgpackInt(w, int64(t))
+	case int32:
+		msgpackInt(w, int64(t))
+	case int64:
+		msgpackInt(w, t)
+	case uint:
+		msgpackUint(w, uint64(t))
+	case uint8:
+		msgpackUint(w, uint64(t))
+	case uint16:
+		msgpackUint(w, uint64(t))
+	case uint32:
+		msgpackUint(w, uint64(t))
+	case uint64:
+		msgpackUint(w, t)
+	case float32:
+		msgpackFloat(w, float64(t))
+	case float64:
+		msgpackFloat(w, t)
+	case []interface{}:
+		msgpackHeader(w, len(t), 0x90, 0xdc, 0xdd)
+		for _, e := range t {
+			if err := msgpackEncode(w, e); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		msgpackHeader(w, len(keys), 0x80, 0xde, 0xdf)
+		for _, k := range keys {
+			msgpackString(w, k)
+			if err := msgpackEncode(w, t[k]); err != nil {
+				return err
+			}
+		}
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[fmt.Sprint(k)] = e
+		}
+		return msgpackEncode(w, m)
+	default:
+		return fmt.Errorf("msgpack: unsupported type %T", v)
+	}
+	return nil
+}
+
+// msgpackHeader writes the header of a collection of n elements using the fix, 16 and 32 bits formats
+func msgpackHeader(w *bufio.Writer, n int, fix, b16, b32 byte) {
+	switch {
+	case n < 16:
+		w.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		w.WriteByte(b16)
+		binary.Write(w, binary.BigEndian, uint16(n))
+	default:
+		w.WriteByte(b32)
+		binary.Write(w, binary.BigEndian, uint32(n))
+	}
+}
+
+func msgpackString(w *bufio.Writer, s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		w.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		w.WriteByte(0xd9)
+		w.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		w.WriteByte(0xda)
+		binary.Write(w, binary.BigEndian, uint16(n))
+	default:
+		w.WriteByte(0xdb)
+		binary.Write(w, binary.BigEndian, uint32(n))
+	}
+	w.WriteString(s)
+}
+
+func msgpackInt(w *bufio.Writer, i int64) {
+	switch {
+	case i >= 0:
+		msgpackUint(w, uint64(i))
+	case i >= -32:
+		w.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8:
+		w.WriteByte(0xd0)
+		w.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16:
+		w.WriteByte(0xd1)
+		binary.Write(w, binary.BigEndian, int16(i))
+	case i >= math.MinInt32:
+		w.WriteByte(0xd2)
+		binary.Write(w, binary.BigEndian, int32(i))
+	default:
+		w.WriteByte(0xd3)
+		binary.Write(w, binary.BigEndian, i)
+	}
+}
+
+func msgpackUint(w *bufio.Writer, u uint64) {
+	switch {
+	case u < 128:
+		w.WriteByte(byte(u))
+	case u <= math.MaxUint8:
+		w.WriteByte(0xcc)
+		w.WriteByte(byte(u))
+	case u <= math.MaxUint16:
+		w.WriteByte(0xcd)
+		binary.Write(w, binary.BigEndian, uint16(u))
+	case u <= math.MaxUint32:
+		w.WriteByte(0xce)
+		binary.Write(w, binary.BigEndian, uint32(u))
+	default:
+		w.WriteByte(0xcf)
+		binary.Write(w, binary.BigEndian, u)
+	}
+}
+
+func msgpackFloat(w *bufio.Writer, f float64) {
+	w.WriteByte(0xcb)
+	binary.Write(w, binary.BigEndian, math.Float64bits(f))
+}
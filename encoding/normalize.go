@@ -0,0 +1,107 @@
+package encoding
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Normalize converts the received value into the tree produced by the JSONDecoder, so the responses
+// are formatted and merged the same way whatever their encoding: maps with string keys, slices of
+// interface{}, json.Number for the numbers, RFC 3339 strings for the times and strings for the rest
+// of the scalars without a JSON representation, like NaN or the local dates
+func Normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil, string, bool, json.Number:
+		return t
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = Normalize(e)
+		}
+		return t
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[fmt.Sprint(k)] = Normalize(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range t {
+			t[i] = Normalize(e)
+		}
+		return t
+	case int:
+		return json.Number(strconv.FormatInt(int64(t), 10))
+	case int8:
+		return json.Number(strconv.FormatInt(int64(t), 10))
+	case int16:
+		return json.Number(strconv.FormatInt(int64(t), 10))
+	case int32:
+		return json.Number(strconv.FormatInt(int64(t), 10))
+	case int64:
+		return json.Number(strconv.FormatInt(t, 10))
+	case uint:
+		return json.Number(strconv.FormatUint(uint64(t), 10))
+	case uint8:
+		return json.Number(strconv.FormatUint(uint64(t), 10))
+	case uint16:
+		return json.Number(strconv.FormatUint(uint64(t), 10))
+	case uint32:
+		return json.Number(strconv.FormatUint(uint64(t), 10))
+	case uint64:
+		return json.Number(strconv.FormatUint(t, 10))
+	case float32:
+		return normalizeFloat(float64(t), 32)
+	case float64:
+		return normalizeFloat(t, 64)
+	case time.Time:
+		return t.Format(time.RFC3339Nano)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		list := make([]interface{}, rv.Len())
+		for i := range list {
+			list[i] = Normalize(rv.Index(i).Interface())
+		}
+		return list
+	case reflect.Map:
+		m := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			m[fmt.Sprint(iter.Key().Interface())] = Normalize(iter.Value().Interface())
+		}
+		return m
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil
+		}
+		return Normalize(rv.Elem().Interface())
+	}
+	if s, ok := v.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprint(v)
+}
+
+func normalizeFloat(f float64, bitSize int) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'g', -1, bitSize)
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, bitSize))
+}
+
+// normalizeMap applies Normalize to the values of the decoded map
+func normalizeMap(v *map[string]interface{}) {
+	if *v == nil {
+		return
+	}
+	Normalize(*v)
+}
@@ -0,0 +1,68 @@
+package encoding
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecoders_normalized(t *testing.T) {
+	expected := map[string]interface{}{}
+	if err := JSONDecoder(strings.NewReader(`{
+		"id": 9007199254740993,
+		"price": 1.5,
+		"name": "supu",
+		"active": true,
+		"user": {"id": 1, "tags": ["a", "b"]},
+		"items": [{"id": 1}, {"id": 2}]
+	}`), &expected); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, doc := range map[string]string{
+		"yaml": "id: 9007199254740993\nprice: 1.5\nname: supu\nactive: true\nuser:\n  id: 1\n  tags: [a, b]\nitems:\n- id: 1\n- id: 2\n",
+		"toml": "id = 9007199254740993\nprice = 1.5\nname = \"supu\"\nactive = true\n[user]\nid = 1\ntags = [\"a\", \"b\"]\n[[items]]\nid = 1\n[[items]]\nid = 2\n",
+	} {
+		decoder, err := Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v map[string]interface{}
+		if err := decoder(strings.NewReader(doc), &v); err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, expected) {
+			t.Errorf("%s: unexpected result %#v", name, v)
+		}
+		if _, err := json.Marshal(v); err != nil {
+			t.Errorf("%s: the result can't be marshaled: %v", name, err)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	date := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	v := Normalize(map[interface{}]interface{}{
+		1:       uint8(2),
+		"float": float32(0.1),
+		"nan":   math.NaN(),
+		"date":  date,
+		"list":  []map[string]interface{}{{"a": int16(-3)}},
+		"bytes": []byte("supu"),
+	})
+	want := map[string]interface{}{
+		"1":     json.Number("2"),
+		"float": json.Number("0.1"),
+		"nan":   "NaN",
+		"date":  "2024-01-02T03:04:05Z",
+		"list":  []interface{}{map[string]interface{}{"a": json.Number("-3")}},
+		"bytes": "[115 117 112 117]",
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("unexpected result %#v", v)
+	}
+}
@@ -1,7 +1,6 @@
 package encoding
 
 import (
-	"fmt"
 	"io"
 
 	"github.com/BurntSushi/toml"
@@ -12,16 +11,13 @@ import (
 //	return err
 //}
 
+// TOMLDecoder decodes a TOML document into a map normalized as the ones returned by the JSONDecoder
 func TOMLDecoder(r io.Reader, v *map[string]interface{}) error {
-	md, err := toml.NewDecoder(r).Decode(v)
-	if err != nil {
+	// the undecoded keys are not checked: decoding into a generic map keeps every key, but the
+	// toml pkg reports the keys of the nested tables as undecoded
+	if _, err := toml.NewDecoder(r).Decode(v); err != nil {
 		return err
 	}
-
-	// 检查是否有未解码的键（多余的配置项）
-	if undecoded := md.Undecoded(); len(undecoded) > 0 {
-		return fmt.Errorf("unknown configuration keys: %v", undecoded)
-	}
-
+	normalizeMap(v)
 	return nil
 }
@@ -1,10 +1,285 @@
 package encoding
 
 import (
+	"bufio"
 	"encoding/xml"
+	"fmt"
 	"io"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
+const (
+	// NamespaceStrip removes the namespace from the element and attribute names
+	NamespaceStrip = iota
+	// NamespacePrefix keeps the namespace prefix used in the document (prefix:name) and the xmlns attributes
+	NamespacePrefix
+)
+
+// XMLOptions defines the conventions used to convert a XML document into a map
+type XMLOptions struct {
+	// prefix added to the attribute names
+	AttributePrefix string
+	// key for the text of the elements with attributes or children
+	TextKey string
+	// how to handle the namespaces (NamespaceStrip or NamespacePrefix)
+	Namespaces int
+	// names of the elements to be always decoded as arrays, even if they appear once
+	ForceArray []string
+}
+
+// DefaultXMLOptions are the conventions used by the XMLDecoder
+var DefaultXMLOptions = XMLOptions{
+	AttributePrefix: "-",
+	TextKey:         "#text",
+	Namespaces:      NamespaceStrip,
+}
+
+var defaultXMLDecoder = NewXMLDecoder(DefaultXMLOptions)
+
+// XMLDecoder decodes a XML document into a map using the DefaultXMLOptions conventions
 func XMLDecoder(r io.Reader, v *map[string]interface{}) error {
-	return xml.NewDecoder(r).Decode(v)
+	return defaultXMLDecoder(r, v)
+}
+
+// NewXMLDecoder returns a decoder converting XML documents into maps with the received conventions.
+// The root element is the only key of the resulting map. Elements without attributes nor children
+// are decoded as strings, repeated elements as arrays and the rest as maps, with their attributes
+// prefixed and their text under the text key
+func NewXMLDecoder(opts XMLOptions) Decoder {
+	x := xmlDecoder{opts: opts, forceArray: make(map[string]struct{}, len(opts.ForceArray))}
+	for _, name := range opts.ForceArray {
+		x.forceArray[name] = struct{}{}
+	}
+	return x.decode
+}
+
+type xmlDecoder struct {
+	opts       XMLOptions
+	forceArray map[string]struct{}
+}
+
+func (x xmlDecoder) decode(r io.Reader, v *map[string]interface{}) error {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = charsetReader
+	for {
+		tok, err := x.token(d)
+		if err != nil {
+			return err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			name := x.name(start.Name)
+			value, err := x.element(d, start, 1)
+			if err != nil {
+				return err
+			}
+			if _, ok := x.forceArray[name]; ok {
+				value = []interface{}{value}
+			}
+			*v = map[string]interface{}{name: value}
+			return nil
+		}
+	}
+}
+
+func (x xmlDecoder) element(d *xml.Decoder, start xml.StartElement, level int) (interface{}, error) {
+	if level > DefaultMaxDepth {
+		return nil, ErrMaxDepthExceeded
+	}
+	node := map[string]interface{}{}
+	for _, a := range start.Attr {
+		if x.opts.Namespaces == NamespaceStrip && (a.Name.Space == "xmlns" || a.Name.Local == "xmlns") {
+			continue
+		}
+		node[x.opts.AttributePrefix+x.name(a.Name)] = a.Value
+	}
+
+	text := strings.Builder{}
+	for {
+		tok, err := x.token(d)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child, err := x.element(d, t, level+1)
+			if err != nil {
+				return nil, err
+			}
+			x.add(node, x.name(t.Name), child)
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+			if len(node) == 0 {
+				return s, nil
+			}
+			if s != "" {
+				node[x.opts.TextKey] = s
+			}
+			return node, nil
+		}
+	}
+}
+
+func (x xmlDecoder) add(node map[string]interface{}, name string, value interface{}) {
+	current, ok := node[name]
+	if !ok {
+		if _, force := x.forceArray[name]; force {
+			value = []interface{}{value}
+		}
+		node[name] = value
+		return
+	}
+	if list, ok := current.([]interface{}); ok {
+		node[name] = append(list, value)
+		return
+	}
+	node[name] = []interface{}{current, value}
+}
+
+func (x xmlDecoder) token(d *xml.Decoder) (xml.Token, error) {
+	if x.opts.Namespaces == NamespacePrefix {
+		return d.RawToken()
+	}
+	return d.Token()
+}
+
+func (x xmlDecoder) name(n xml.Name) string {
+	if x.opts.Namespaces == NamespacePrefix && n.Space != "" {
+		return n.Space + ":" + n.Local
+	}
+	return n.Local
+}
+
+// charsetReader supports the documents declaring themselves as US-ASCII or ISO-8859-1 encoded,
+// besides the UTF-8 ones supported by the xml pkg
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return input, nil
+	case "iso-8859-1", "iso8859-1", "latin1", "latin-1":
+		return &latin1Reader{r: bufio.NewReader(input)}, nil
+	}
+	return nil, fmt.Errorf("xml: unsupported charset %s", charset)
+}
+
+type latin1Reader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(l.buf) > 0 {
+			c := copy(p[n:], l.buf)
+			l.buf = l.buf[c:]
+			n += c
+			continue
+		}
+		b, err := l.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		if b < utf8.RuneSelf {
+			p[n] = b
+			n++
+			continue
+		}
+		l.buf = utf8.AppendRune(l.buf[:0], rune(b))
+	}
+	return n, nil
+}
+
+// XMLEncoder writes the data as a XML document rooted at a response element, reversing the
+// DefaultXMLOptions conventions: the keys with the attribute prefix become attributes, the text key
+// becomes the text of the element and the arrays become repeated elements. Keys are sorted and the
+// chars not allowed in the element names are replaced by underscores
+func XMLEncoder(w io.Writer, v map[string]interface{}) error {
+	e := xml.NewEncoder(w)
+	if err := xmlEncode(e, "response", v, DefaultXMLOptions); err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+func xmlEncode(e *xml.Encoder, name string, v interface{}, opts XMLOptions) error {
+	if list, ok := v.([]interface{}); ok {
+		for _, item := range list {
+			if err := xmlEncode(e, name, item, opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	start := xml.StartElement{Name: xml.Name{Local: xmlName(name)}}
+	node, isMap := v.(map[string]interface{})
+	keys := make([]string, 0, len(node))
+	for k := range node {
+		if opts.AttributePrefix != "" && strings.HasPrefix(k, opts.AttributePrefix) {
+			start.Attr = append(start.Attr, xml.Attr{
+				Name:  xml.Name{Local: xmlName(strings.TrimPrefix(k, opts.AttributePrefix))},
+				Value: fmt.Sprint(node[k]),
+			})
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Slice(start.Attr, func(i, j int) bool { return start.Attr[i].Name.Local < start.Attr[j].Name.Local })
+	sort.Strings(keys)
+
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	switch {
+	case isMap:
+		for _, k := range keys {
+			if k == opts.TextKey {
+				if err := e.EncodeToken(xml.CharData(fmt.Sprint(node[k]))); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := xmlEncode(e, k, node[k], opts); err != nil {
+				return err
+			}
+		}
+	case v != nil:
+		if err := e.EncodeToken(xml.CharData(fmt.Sprint(v))); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+func xmlName(name string) string {
+	b := strings.Builder{}
+	for i, r := range name {
+		switch {
+		case r == '_' || r == ':' || unicode.IsLetter(r):
+		case i > 0 && (r == '-' || r == '.' || unicode.IsDigit(r)):
+		default:
+			if i == 0 && unicode.IsDigit(r) {
+				b.WriteRune('_')
+				b.WriteRune(r)
+				continue
+			}
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
 }
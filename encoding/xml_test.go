@@ -0,0 +1,110 @@
+package encoding
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const soapResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:m="http://www.example.org/stock">
+  <soap:Header>
+    <m:Trans soap:mustUnderstand="1">234</m:Trans>
+  </soap:Header>
+  <soap:Body>
+    <m:GetStockPriceResponse>
+      <m:Price currency="USD">34.5</m:Price>
+      <m:Quote date="2024-01-01">
+        <m:Value>34.1</m:Value>
+      </m:Quote>
+      <m:Quote date="2024-01-02">
+        <m:Value>34.5</m:Value>
+      </m:Quote>
+      <m:Comment><![CDATA[<b>stable</b> & growing]]></m:Comment>
+      <m:Empty/>
+    </m:GetStockPriceResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+func TestXMLDecoder_soap(t *testing.T) {
+	var v map[string]interface{}
+	if err := XMLDecoder(strings.NewReader(soapResponse), &v); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"Envelope":{"Body":{"GetStockPriceResponse":{` +
+		`"Comment":"<b>stable</b> & growing",` +
+		`"Empty":"",` +
+		`"Price":{"#text":"34.5","-currency":"USD"},` +
+		`"Quote":[{"-date":"2024-01-01","Value":"34.1"},{"-date":"2024-01-02","Value":"34.5"}]}},` +
+		`"Header":{"Trans":{"#text":"234","-mustUnderstand":"1"}}}}`
+	assertJSON(t, v, expected)
+}
+
+func TestNewXMLDecoder_prefixedNamespaces(t *testing.T) {
+	decoder := NewXMLDecoder(XMLOptions{
+		AttributePrefix: "@",
+		TextKey:         "_",
+		Namespaces:      NamespacePrefix,
+		ForceArray:      []string{"m:Price", "soap:Header"},
+	})
+	var v map[string]interface{}
+	if err := decoder(strings.NewReader(soapResponse), &v); err != nil {
+		t.Fatal(err)
+	}
+
+	envelope := v["soap:Envelope"].(map[string]interface{})
+	if envelope["@xmlns:m"] != "http://www.example.org/stock" {
+		t.Errorf("xmlns attribute not kept: %v", envelope)
+	}
+	assertJSON(t, envelope["soap:Header"], `[{"m:Trans":{"@soap:mustUnderstand":"1","_":"234"}}]`)
+	response := envelope["soap:Body"].(map[string]interface{})["m:GetStockPriceResponse"]
+	assertJSON(t, response.(map[string]interface{})["m:Price"], `[{"@currency":"USD","_":"34.5"}]`)
+}
+
+func TestXMLDecoder_mixedContent(t *testing.T) {
+	var v map[string]interface{}
+	if err := XMLDecoder(strings.NewReader(`<p id="1">Hello <b>world</b> again<i/></p>`), &v); err != nil {
+		t.Fatal(err)
+	}
+	assertJSON(t, v, `{"p":{"#text":"Hello  again","-id":"1","b":"world","i":""}}`)
+}
+
+func TestXMLDecoder_latin1(t *testing.T) {
+	doc := "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><name>Jos\xe9</name>"
+	var v map[string]interface{}
+	if err := XMLDecoder(strings.NewReader(doc), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v["name"] != "José" {
+		t.Errorf("unexpected result: %v", v)
+	}
+}
+
+func TestXMLDecoder_ko(t *testing.T) {
+	for _, doc := range []string{
+		"",
+		"<a><b></a>",
+		"<a><b>",
+		`<?xml version="1.0" encoding="EBCDIC"?><a/>`,
+	} {
+		var v map[string]interface{}
+		if err := XMLDecoder(strings.NewReader(doc), &v); err == nil {
+			t.Errorf("error expected decoding %q. have %v", doc, v)
+		}
+	}
+}
+
+func assertJSON(t *testing.T, v interface{}, expected string) {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	if have := strings.TrimSpace(buf.String()); have != expected {
+		t.Errorf("unexpected result.\nwant: %s\nhave: %s", expected, have)
+	}
+}
@@ -6,6 +6,11 @@ import (
 	"github.com/go-yaml/yaml"
 )
 
+// YAMLDecoder decodes a YAML document into a map normalized as the ones returned by the JSONDecoder
 func YAMLDecoder(r io.Reader, v *map[string]interface{}) error {
-	return yaml.NewDecoder(r).Decode(v)
+	if err := yaml.NewDecoder(r).Decode(v); err != nil {
+		return err
+	}
+	normalizeMap(v)
+	return nil
 }
@@ -0,0 +1,285 @@
+// Package jwt provides the validation of the JSON web tokens required by the endpoints, shared by
+// all the routers, and the forwarding of their claims to the backends
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+var (
+	// ErrMissingToken is returned when the request has no bearer token
+	ErrMissingToken = errors.New("jwt: missing bearer token")
+	// ErrInvalidToken is returned when the token is malformed or its signature can't be verified
+	ErrInvalidToken = errors.New("jwt: invalid token")
+	// ErrExpired is returned when the token is expired or not valid yet
+	ErrExpired = errors.New("jwt: token expired or not valid yet")
+	// ErrInvalidClaims is returned when the issuer or the audience of the token are not the expected ones
+	ErrInvalidClaims = errors.New("jwt: invalid issuer or audience")
+	// ErrForbidden is returned when the token has none of the roles allowed by the endpoint
+	ErrForbidden = errors.New("jwt: forbidden")
+)
+
+// Claims are the claims of a validated token
+type Claims map[string]interface{}
+
+// Get returns the value of the claim with the received dotted path (ie: realm_access.roles)
+func (c Claims) Get(path string) (interface{}, bool) {
+	var v interface{} = map[string]interface{}(c)
+	for _, part := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// String returns the value of the claim with the received dotted path as a string. The lists are
+// joined with commas
+func (c Claims) String(path string) (string, bool) {
+	v, ok := c.Get(path)
+	if !ok || v == nil {
+		return "", false
+	}
+	switch t := v.(type) {
+	case string:
+		return t, true
+	case []interface{}:
+		values := make([]string, len(t))
+		for i, e := range t {
+			values[i] = fmt.Sprint(e)
+		}
+		return strings.Join(values, ","), true
+	case map[string]interface{}:
+		b, err := json.Marshal(t)
+		return string(b), err == nil
+	}
+	return fmt.Sprint(v), true
+}
+
+// Validator validates the tokens of the requests with the settings of an endpoint
+type Validator struct {
+	cfg        *config.JWTConfig
+	algorithms map[string]struct{}
+	keys       []keySource
+	now        func() time.Time
+}
+
+// New returns the validator defined by the received config, or nil when there is no config. The
+// static keys are loaded immediately, while the JWK set is loaded with the first request
+func New(cfg *config.JWTConfig) (*Validator, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	v := &Validator{cfg: cfg, algorithms: map[string]struct{}{}, now: time.Now}
+	for _, a := range cfg.Algorithms {
+		v.algorithms[a] = struct{}{}
+	}
+	if len(cfg.Keys) > 0 {
+		keys, err := newStaticKeys(cfg.Keys)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, keys)
+	}
+	if cfg.JWKSURL != "" || cfg.JWKSFile != "" {
+		v.keys = append(v.keys, newJWKS(cfg.JWKSURL, cfg.JWKSFile, cfg.JWKSCacheDuration))
+	}
+	return v, nil
+}
+
+// ValidateRequest validates the bearer token of the Authorization header of the request
+func (v *Validator) ValidateRequest(r *http.Request) (Claims, error) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, ErrMissingToken
+	}
+	return v.Validate(token)
+}
+
+// Validate checks the signature and the claims of the token, returning its claims
+func (v *Validator) Validate(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if _, ok := v.algorithms[header.Alg]; len(v.algorithms) > 0 && !ok {
+		return nil, ErrInvalidToken
+	}
+	alg, ok := algorithms[header.Alg]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !v.verify(alg, header.Kid, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	claims := Claims{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verify checks the signature with the keys matching the kid, stopping at the first one verifying it
+func (v *Validator) verify(alg algorithm, kid string, signed, signature []byte) bool {
+	for _, source := range v.keys {
+		for _, key := range source.keys(kid) {
+			if alg(key, signed, signature) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v *Validator) validateClaims(claims Claims) error {
+	now := v.now()
+	if exp, ok := numericDate(claims, "exp"); ok && !now.Before(exp.Add(v.cfg.Leeway)) {
+		return ErrExpired
+	}
+	if nbf, ok := numericDate(claims, "nbf"); ok && now.Add(v.cfg.Leeway).Before(nbf) {
+		return ErrExpired
+	}
+	if v.cfg.Issuer != "" && claims["iss"] != v.cfg.Issuer {
+		return ErrInvalidClaims
+	}
+	if len(v.cfg.Audience) > 0 && !containsAny(values(claims["aud"]), v.cfg.Audience) {
+		return ErrInvalidClaims
+	}
+	if len(v.cfg.Roles) > 0 {
+		roles, _ := claims.Get(v.cfg.RolesKey)
+		if !containsAny(values(roles), v.cfg.Roles) {
+			return ErrForbidden
+		}
+	}
+	return nil
+}
+
+// Handler returns a handler validating the requests before passing them to the next handler, with
+// the claims of the token in their context. The requests without a valid token are rejected with
+// a 401 status code and the ones without the required roles with a 403 status code
+func (v *Validator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := v.ValidateRequest(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", Challenge(err))
+			http.Error(w, err.Error(), Status(err))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), claims)))
+	})
+}
+
+// Status returns the status code of the responses to the requests rejected with the received error
+func Status(err error) int {
+	if errors.Is(err, ErrForbidden) {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+// Challenge returns the value of the WWW-Authenticate header of the responses to the requests
+// rejected with the received error, as defined by RFC 6750
+func Challenge(err error) string {
+	switch {
+	case errors.Is(err, ErrMissingToken):
+		return "Bearer"
+	case errors.Is(err, ErrForbidden):
+		return `Bearer error="insufficient_scope"`
+	}
+	return `Bearer error="invalid_token", error_description=` + strconv.Quote(err.Error())
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of the context carrying the claims
+func NewContext(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// FromContext returns the claims carried by the context, if any
+func FromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(Claims)
+	return claims, ok
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// numericDate returns the time of the claim holding the seconds since the epoch
+func numericDate(claims Claims, name string) (time.Time, bool) {
+	n, ok := claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(f*float64(time.Second))), true
+}
+
+// values returns the strings of a claim holding a list or a space separated string
+func values(v interface{}) []string {
+	switch t := v.(type) {
+	case string:
+		return strings.Fields(t)
+	case []interface{}:
+		res := make([]string, 0, len(t))
+		for _, e := range t {
+			if s, ok := e.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+func containsAny(have, want []string) bool {
+	for _, h := range have {
+		for _, w := range want {
+			if h == w {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,414 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/proxy"
+)
+
+func segment(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// sign returns a token with the received claims signed with the key
+func sign(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	signed := segment(header) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	var err error
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		if err == nil {
+			signature = make([]byte, 64)
+			r.FillBytes(signature[:32])
+			s.FillBytes(signature[32:])
+		}
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(k, []byte(signed))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writePublicKey(t *testing.T, key interface{}) string {
+	b, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func jwk(kid string, key interface{}) map[string]string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(k.N.Bytes()), "e": b64([]byte{1, 0, 1})}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(k.X.FillBytes(make([]byte, 32))), "y": b64(k.Y.FillBytes(make([]byte, 32)))}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": b64(k)}
+	}
+	return nil
+}
+
+func jwksDocument(keys ...map[string]string) []byte {
+	b, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	return b
+}
+
+func TestValidator_staticKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	secret := []byte("supu-secret")
+	v, err := New(&config.JWTConfig{
+		Keys: []config.JWTKeyConfig{
+			{ID: "hmac", Secret: string(secret)},
+			{ID: "rsa", PublicKeyFile: writePublicKey(t, &rsaKey.PublicKey)},
+		},
+		Issuer:   "https://issuer.example.com",
+		Audience: []string{"porta", "other"},
+		Leeway:   time.Minute,
+		Roles:    []string{"admin"},
+		RolesKey: "realm_access.roles",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	v.now = func() time.Time { return now }
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"sub":          "42",
+			"iss":          "https://issuer.example.com",
+			"aud":          []string{"porta"},
+			"exp":          now.Unix() + 60,
+			"realm_access": map[string]interface{}{"roles": []string{"user", "admin"}},
+		}
+	}
+	with := func(k string, val interface{}) map[string]interface{} {
+		c := valid()
+		if val == nil {
+			delete(c, k)
+		} else {
+			c[k] = val
+		}
+		return c
+	}
+	rsaPEM, _ := os.ReadFile(v.cfg.Keys[1].PublicKeyFile)
+
+	for i, tc := range []struct {
+		token string
+		err   error
+	}{
+		{sign(t, "HS256", "hmac", secret, valid()), nil},
+		{sign(t, "RS256", "rsa", rsaKey, valid()), nil},
+		{sign(t, "RS256", "", rsaKey, valid()), nil},
+		{sign(t, "RS256", "hmac", rsaKey, valid()), ErrInvalidToken},
+		{sign(t, "HS256", "rsa", rsaPEM, valid()), ErrInvalidToken},
+		{sign(t, "HS256", "hmac", []byte("wrong"), valid()), ErrInvalidToken},
+		{sign(t, "HS256", "hmac", secret, with("aud", "porta")), nil},
+		{sign(t, "HS256", "hmac", secret, with("aud", "tupu")), ErrInvalidClaims},
+		{sign(t, "HS256", "hmac", secret, with("aud", nil)), ErrInvalidClaims},
+		{sign(t, "HS256", "hmac", secret, with("iss", "https://evil.example.com")), ErrInvalidClaims},
+		{sign(t, "HS256", "hmac", secret, with("exp", now.Unix()-30)), nil},
+		{sign(t, "HS256", "hmac", secret, with("exp", now.Unix()-90)), ErrExpired},
+		{sign(t, "HS256", "hmac", secret, with("nbf", now.Unix()+90)), ErrExpired},
+		{sign(t, "HS256", "hmac", secret, with("realm_access", map[string]interface{}{"roles": "user admin"})), nil},
+		{sign(t, "HS256", "hmac", secret, with("realm_access", map[string]interface{}{"roles": []string{"user"}})), ErrForbidden},
+		{sign(t, "HS256", "hmac", secret, with("realm_access", nil)), ErrForbidden},
+		{segment(map[string]string{"alg": "none"}) + "." + segment(valid()) + ".", ErrInvalidToken},
+		{"supu.tupu", ErrInvalidToken},
+	} {
+		_, err := v.Validate(tc.token)
+		if err != tc.err {
+			t.Errorf("#%d: want %v, have %v", i, tc.err, err)
+		}
+	}
+
+	v.algorithms = map[string]struct{}{"RS256": {}}
+	if _, err := v.Validate(sign(t, "HS256", "hmac", secret, valid())); err != ErrInvalidToken {
+		t.Errorf("the algorithms not allowed should be rejected: %v", err)
+	}
+}
+
+func TestValidator_jwks(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPublic, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	document := atomic.Value{}
+	document.Store(jwksDocument(jwk("ec", &ecKey.PublicKey), jwk("ed", edPublic)))
+	var loads int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&loads, 1)
+		w.Write(document.Load().([]byte))
+	}))
+	defer server.Close()
+
+	defer func(d time.Duration) { jwksMinRefreshInterval = d }(jwksMinRefreshInterval)
+	jwksMinRefreshInterval = 0
+
+	v, err := New(&config.JWTConfig{JWKSURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]interface{}{"sub": "42"}
+	for _, token := range []string{
+		sign(t, "ES256", "ec", ecKey, claims),
+		sign(t, "EdDSA", "ed", edKey, claims),
+		sign(t, "EdDSA", "", edKey, claims),
+	} {
+		if c, err := v.Validate(token); err != nil || c["sub"] != "42" {
+			t.Errorf("unexpected result: %v %v", c, err)
+		}
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("the JWK set should be cached: %d loads", n)
+	}
+
+	// a token signed with an unknown key triggers a reload of the set
+	token := sign(t, "RS256", "rsa", rsaKey, claims)
+	if _, err := v.Validate(token); err != ErrInvalidToken {
+		t.Errorf("want %v, have %v", ErrInvalidToken, err)
+	}
+	document.Store(jwksDocument(jwk("rsa", &rsaKey.PublicKey)))
+	if _, err := v.Validate(token); err != nil {
+		t.Errorf("the rotated key was not loaded: %v", err)
+	}
+	if _, err := v.Validate(sign(t, "ES256", "ec", ecKey, claims)); err != ErrInvalidToken {
+		t.Errorf("the removed key should not verify the tokens: %v", err)
+	}
+
+	// the keys are kept when the set can't be loaded
+	document.Store([]byte("not a JWK set"))
+	v.Validate(sign(t, "ES256", "unknown", ecKey, claims))
+	if _, err := v.Validate(token); err != nil {
+		t.Errorf("the previous keys should be kept: %v", err)
+	}
+}
+
+func TestValidator_jwksDown(t *testing.T) {
+	edPublic, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	var loads int32
+	release := make(chan struct{})
+	up := atomic.Value{}
+	up.Store(false)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		if !up.Load().(bool) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(jwksDocument(jwk("ed", edPublic)))
+	}))
+	defer server.Close()
+
+	v, err := New(&config.JWTConfig{JWKSURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := sign(t, "EdDSA", "ed", edKey, map[string]interface{}{"sub": "42"})
+
+	// the requests do not wait for the load in progress
+	done := make(chan error)
+	go func() {
+		_, err := v.Validate(token)
+		done <- err
+	}()
+	for atomic.LoadInt32(&loads) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := v.Validate(token); err != ErrInvalidToken {
+		t.Errorf("want %v, have %v", ErrInvalidToken, err)
+	}
+	close(release)
+	if err := <-done; err != ErrInvalidToken {
+		t.Errorf("want %v, have %v", ErrInvalidToken, err)
+	}
+
+	// the failed load is not retried until the min refresh interval passes
+	up.Store(true)
+	for i := 0; i < 5; i++ {
+		if _, err := v.Validate(token); err != ErrInvalidToken {
+			t.Errorf("#%d: want %v, have %v", i, ErrInvalidToken, err)
+		}
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("unexpected number of loads: %d", n)
+	}
+
+	defer func(d time.Duration) { jwksMinRefreshInterval = d }(jwksMinRefreshInterval)
+	jwksMinRefreshInterval = 0
+	if _, err := v.Validate(token); err != nil {
+		t.Errorf("the JWK set was not loaded again: %v", err)
+	}
+}
+
+func TestValidator_jwksFile(t *testing.T) {
+	edPublic, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwksDocument(jwk("ed", edPublic)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := New(&config.JWTConfig{JWKSFile: path, Algorithms: []string{"EdDSA"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Validate(sign(t, "EdDSA", "ed", edKey, map[string]interface{}{})); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := New(&config.JWTConfig{Keys: []config.JWTKeyConfig{{PublicKeyFile: "/nowhere/key.pem"}}}); err == nil {
+		t.Error("error expected with an unknown key file")
+	}
+	if v, err := New(nil); v != nil || err != nil {
+		t.Errorf("unexpected validator without config: %v %v", v, err)
+	}
+}
+
+func TestValidator_jwksSymmetricKeys(t *testing.T) {
+	edPublic, _, _ := ed25519.GenerateKey(rand.Reader)
+	secret := []byte("supu")
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	document := jwksDocument(jwk("ed", edPublic), map[string]string{"kty": "oct", "kid": "hs", "k": base64.RawURLEncoding.EncodeToString(secret)})
+	if err := os.WriteFile(path, document, 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := ParseJWKS(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keys["hs"]; ok || len(keys) != 1 {
+		t.Errorf("the symmetric keys should be ignored: %v", keys)
+	}
+
+	v, err := New(&config.JWTConfig{JWKSFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Validate(sign(t, "HS256", "hs", secret, map[string]interface{}{})); err == nil {
+		t.Error("the token signed with the symmetric key of the JWK set should be rejected")
+	}
+}
+
+func TestValidator_Handler(t *testing.T) {
+	secret := []byte("supu-secret")
+	v, _ := New(&config.JWTConfig{Keys: []config.JWTKeyConfig{{Secret: string(secret)}}, Roles: []string{"admin"}, RolesKey: "roles"})
+	handler := v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := FromContext(r.Context())
+		fmt.Fprint(w, claims["sub"])
+	}))
+
+	for _, tc := range []struct {
+		authorization string
+		status        int
+		challenge     string
+	}{
+		{"", http.StatusUnauthorized, "Bearer"},
+		{"Basic c3VwdTp0dXB1", http.StatusUnauthorized, "Bearer"},
+		{"Bearer supu", http.StatusUnauthorized, `Bearer error="invalid_token", error_description="jwt: invalid token"`},
+		{"Bearer " + sign(t, "HS256", "", secret, map[string]interface{}{"sub": "42", "roles": "user"}), http.StatusForbidden, `Bearer error="insufficient_scope"`},
+		{"bearer " + sign(t, "HS256", "", secret, map[string]interface{}{"sub": "42", "roles": "admin"}), http.StatusOK, ""},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tc.status || w.Header().Get("WWW-Authenticate") != tc.challenge {
+			t.Errorf("%s: unexpected response %d %v", tc.authorization, w.Code, w.Header())
+		}
+		if tc.status == http.StatusOK && w.Body.String() != "42" {
+			t.Errorf("unexpected body: %s", w.Body.String())
+		}
+	}
+}
+
+func TestNewMiddleware(t *testing.T) {
+	cfg := &config.JWTConfig{
+		ClaimsToHeaders: map[string]string{"sub": "X-User", "email": "X-Email", "org.id": "X-Org"},
+		ClaimsToParams:  map[string]string{"tenant": "tenant"},
+		ClaimsToQuery:   map[string]string{"scope": "scope", "missing": "flag"},
+	}
+	var received *proxy.Request
+	p := NewMiddleware(cfg)(proxy.NewRequestBuilderMiddleware(&config.Backend{URLPattern: "/tenants/{{.Tenant}}/users/{{.Id}}"})(
+		func(_ context.Context, r *proxy.Request) (*proxy.Response, error) {
+			received = r
+			return nil, nil
+		}))
+
+	request := &proxy.Request{
+		Params:  map[string]string{"Id": "1"},
+		Query:   url.Values{"flag": {"spoofed"}, "page": {"2"}},
+		Headers: map[string][]string{"X-Email": {"spoofed@example.com"}, "Content-Type": {"application/json"}},
+	}
+	ctx := NewContext(context.Background(), Claims{
+		"sub":    "42",
+		"tenant": "acme/evil",
+		"scope":  []interface{}{"read", "write"},
+		"org":    map[string]interface{}{"id": json.Number("7")},
+	})
+	if _, err := p(ctx, request); err != nil {
+		t.Fatal(err)
+	}
+
+	if received.Path != "/tenants/acme%2Fevil/users/1" {
+		t.Errorf("unexpected path: %s", received.Path)
+	}
+	for k, want := range map[string]string{"X-User": "[42]", "X-Email": "[]", "X-Org": "[7]", "Content-Type": "[application/json]"} {
+		if have := fmt.Sprint(received.Headers[k]); have != want {
+			t.Errorf("%s: want %s, have %s", k, want, have)
+		}
+	}
+	if q := received.Query.Encode(); q != "page=2&scope=read%2Cwrite" {
+		t.Errorf("unexpected query: %s", q)
+	}
+	if len(request.Params) != 1 || len(request.Headers) != 2 || len(request.Query) != 2 {
+		t.Errorf("the original request was modified: %+v", request)
+	}
+}
@@ -0,0 +1,345 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// algorithm verifies the signatures of a signing algorithm with the received key
+type algorithm func(key interface{}, signed, signature []byte) bool
+
+// algorithms are the supported signing algorithms, by name. The keys of the wrong type never verify
+// a signature, so a public key can't be used as an HMAC secret
+var algorithms = map[string]algorithm{
+	"HS256": hmacAlgorithm(crypto.SHA256),
+	"HS384": hmacAlgorithm(crypto.SHA384),
+	"HS512": hmacAlgorithm(crypto.SHA512),
+	"RS256": rsaAlgorithm(crypto.SHA256, false),
+	"RS384": rsaAlgorithm(crypto.SHA384, false),
+	"RS512": rsaAlgorithm(crypto.SHA512, false),
+	"PS256": rsaAlgorithm(crypto.SHA256, true),
+	"PS384": rsaAlgorithm(crypto.SHA384, true),
+	"PS512": rsaAlgorithm(crypto.SHA512, true),
+	"ES256": ecdsaAlgorithm(crypto.SHA256, elliptic.P256()),
+	"ES384": ecdsaAlgorithm(crypto.SHA384, elliptic.P384()),
+	"ES512": ecdsaAlgorithm(crypto.SHA512, elliptic.P521()),
+	"EdDSA": func(key interface{}, signed, signature []byte) bool {
+		k, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(k, signed, signature)
+	},
+}
+
+func digest(h crypto.Hash, signed []byte) []byte {
+	w := h.New()
+	w.Write(signed)
+	return w.Sum(nil)
+}
+
+func hmacAlgorithm(h crypto.Hash) algorithm {
+	return func(key interface{}, signed, signature []byte) bool {
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(h.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	}
+}
+
+func rsaAlgorithm(h crypto.Hash, pss bool) algorithm {
+	return func(key interface{}, signed, signature []byte) bool {
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		if pss {
+			return rsa.VerifyPSS(k, h, digest(h, signed), signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+		return rsa.VerifyPKCS1v15(k, h, digest(h, signed), signature) == nil
+	}
+}
+
+// ecdsaAlgorithm verifies the signatures made of the r and s values, each one padded to the size of
+// the curve
+func ecdsaAlgorithm(h crypto.Hash, curve elliptic.Curve) algorithm {
+	size := (curve.Params().BitSize + 7) / 8
+	return func(key interface{}, signed, signature []byte) bool {
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || k.Curve != curve || len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(k, digest(h, signed), r, s)
+	}
+}
+
+// keySource provides the keys that may verify the tokens with the received key id
+type keySource interface {
+	keys(kid string) []interface{}
+}
+
+type staticKey struct {
+	id  string
+	key interface{}
+}
+
+// staticKeys are the keys defined in the config
+type staticKeys []staticKey
+
+func newStaticKeys(cfg []config.JWTKeyConfig) (staticKeys, error) {
+	keys := make(staticKeys, 0, len(cfg))
+	for _, k := range cfg {
+		if k.Secret != "" {
+			keys = append(keys, staticKey{id: k.ID, key: []byte(k.Secret)})
+			continue
+		}
+		b, err := os.ReadFile(k.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParsePublicKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: parsing the public key %s: %w", k.PublicKeyFile, err)
+		}
+		keys = append(keys, staticKey{id: k.ID, key: key})
+	}
+	return keys, nil
+}
+
+// keys returns the keys with the received id and the ones without id
+func (s staticKeys) keys(kid string) []interface{} {
+	res := []interface{}{}
+	for _, k := range s {
+		if k.id == "" || kid == "" || k.id == kid {
+			res = append(res, k.key)
+		}
+	}
+	return res
+}
+
+// ParsePublicKey returns the RSA, ECDSA or Ed25519 public key of the received PEM block, holding
+// either a public key (PKIX or PKCS #1) or a certificate
+func ParsePublicKey(b []byte) (interface{}, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+var (
+	// jwksDefaultCacheDuration is the time the JWK sets are cached when the config does not define it
+	jwksDefaultCacheDuration = 15 * time.Minute
+	// jwksMinRefreshInterval is the min time between the loads of a JWK set not triggered by the
+	// expiration of its cache, so the tokens signed with unknown keys can't be used to flood the
+	// JWKS endpoint and the requests fail fast while it is down
+	jwksMinRefreshInterval = 10 * time.Second
+	// jwksClient is the http client loading the JWK sets
+	jwksClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// jwks is a JWK set loaded from a URL or a file. The set is loaded again when the cache expires or
+// when a token is signed with an unknown key, so the keys can be rotated. The previous keys are
+// kept when the set can't be loaded
+type jwks struct {
+	url, file string
+	ttl       time.Duration
+
+	mu       sync.Mutex
+	set      map[string][]interface{}
+	loadedAt time.Time
+	loading  bool
+}
+
+func newJWKS(url, file string, ttl time.Duration) *jwks {
+	if ttl == 0 {
+		ttl = jwksDefaultCacheDuration
+	}
+	return &jwks{url: url, file: file, ttl: ttl}
+}
+
+// keys returns the keys with the received id, or all of them when the id is empty. Only the
+// request triggering a load waits for it, out of the lock, while the others use the current keys
+func (j *jwks) keys(kid string) []interface{} {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	if j.shouldLoad(kid, now) {
+		j.loading = true
+		j.loadedAt = now
+		j.mu.Unlock()
+		set, err := j.load()
+		j.mu.Lock()
+		j.loading = false
+		if err == nil {
+			j.set = set
+		}
+	}
+	if kid != "" {
+		return j.set[kid]
+	}
+	res := []interface{}{}
+	for _, keys := range j.set {
+		res = append(res, keys...)
+	}
+	return res
+}
+
+// shouldLoad reports whether the set must be loaded to return the keys with the received id. The
+// loads are spaced by the min refresh interval, even when there is no set yet
+func (j *jwks) shouldLoad(kid string, now time.Time) bool {
+	elapsed := now.Sub(j.loadedAt)
+	if j.loading || elapsed < jwksMinRefreshInterval {
+		return false
+	}
+	if j.set == nil || elapsed >= j.ttl {
+		return true
+	}
+	_, ok := j.set[kid]
+	return kid != "" && !ok
+}
+
+func (j *jwks) load() (map[string][]interface{}, error) {
+	b, err := j.read()
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(b)
+}
+
+func (j *jwks) read() ([]byte, error) {
+	if j.file != "" {
+		return os.ReadFile(j.file)
+	}
+	resp, err := jwksClient.Get(j.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwt: unexpected status code loading the JWK set: %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS returns the signature keys of the received JWK set, by key id. The keys with an
+// unsupported type and the encryption keys are ignored. The symmetric keys are ignored too, so
+// whoever serves the JWK set can not sign tokens: the HMAC secrets are only taken from the static
+// keys of the config
+func ParseJWKS(b []byte) (map[string][]interface{}, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+	res := map[string][]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		res[k.Kid] = append(res[k.Kid], key)
+	}
+	return res, nil
+}
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid EC point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,62 @@
+package jwt
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/proxy"
+)
+
+// NewMiddleware returns a proxy middleware forwarding the claims of the validated token, carried by
+// the context, as the headers, params and query string params defined by the config. The headers
+// and query string params sent by the client with the same names are replaced, or removed when the
+// token has no such claim, so the backends can trust them. The values of the params are path
+// escaped, so they can't change the path of the backend requests
+func NewMiddleware(cfg *config.JWTConfig) proxy.Middleware {
+	return func(next ...proxy.Proxy) proxy.Proxy {
+		if len(next) > 1 {
+			panic(proxy.ErrTooManyProxies)
+		}
+		if cfg == nil || len(cfg.ClaimsToHeaders)+len(cfg.ClaimsToParams)+len(cfg.ClaimsToQuery) == 0 {
+			return next[0]
+		}
+		return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+			claims, _ := FromContext(ctx)
+			r := request.Clone()
+
+			r.Headers = make(map[string][]string, len(request.Headers)+len(cfg.ClaimsToHeaders))
+			for k, v := range request.Headers {
+				r.Headers[k] = v
+			}
+			for claim, header := range cfg.ClaimsToHeaders {
+				delete(r.Headers, header)
+				if v, ok := claims.String(claim); ok {
+					r.Headers[header] = []string{v}
+				}
+			}
+
+			r.Params = make(map[string]string, len(request.Params)+len(cfg.ClaimsToParams))
+			for k, v := range request.Params {
+				r.Params[k] = v
+			}
+			for claim, param := range cfg.ClaimsToParams {
+				v, _ := claims.String(claim)
+				r.Params[strings.Title(param)] = url.PathEscape(v)
+			}
+
+			r.Query = make(url.Values, len(request.Query)+len(cfg.ClaimsToQuery))
+			for k, v := range request.Query {
+				r.Query[k] = v
+			}
+			for claim, param := range cfg.ClaimsToQuery {
+				r.Query.Del(param)
+				if v, ok := claims.String(claim); ok {
+					r.Query.Set(param, v)
+				}
+			}
+			return next[0](ctx, &r)
+		}
+	}
+}
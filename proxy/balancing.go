@@ -2,43 +2,322 @@ package proxy
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/logging"
 	"github.com/ph0m1/porta/sd"
 )
 
+// NewLoadBalancedMiddleware creates a load balanced middleware using the strategy defined in the
+// lb section of the backend config. Round robin is used when no strategy is defined
+func NewLoadBalancedMiddleware(remote *config.Backend) (Middleware, error) {
+	return NewLoadBalancedMiddlewareWithLogger(remote, nil)
+}
+
+// NewLoadBalancedMiddlewareWithLogger creates a load balanced middleware like NewLoadBalancedMiddleware,
+// reporting the tier in use by the backends with host tiers to the injected logger
+func NewLoadBalancedMiddlewareWithLogger(remote *config.Backend, logger logging.Logger) (Middleware, error) {
+	strategy := remote.LB.Strategy
+	if strategy == "" {
+		strategy = DefaultBalancer
+	}
+	balancersMu.RLock()
+	bf, ok := balancers[strategy]
+	balancersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown load balancing strategy: %s", strategy)
+	}
+
+	if len(remote.HostTiers) == 0 {
+		subscriber, err := NewSubscriber(remote)
+		if err != nil {
+			return nil, err
+		}
+		lb, err := bf(remote, subscriber)
+		if err != nil {
+			return nil, err
+		}
+		return newLoadBalancedMiddleware(lb, newHashKeyExtractor(remote.LB)), nil
+	}
+
+	tiers := make([]sd.Tier, len(remote.HostTiers))
+	for i, t := range remote.HostTiers {
+		tiers[i] = sd.Tier{Name: t.Name, Subscriber: sd.FixedSubscriber(t.Host)}
+	}
+	lb, err := sd.NewTieredLB(tiers, func(subscriber sd.Subscriber) (sd.Balancer, error) {
+		return bf(remote, subscriber)
+	}, remote.LB.MaxFails, remote.LB.FailTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if logger != nil {
+		lb = &tierLogger{Balancer: lb, logger: logger, name: remote.URLPattern}
+	}
+	return newLoadBalancedMiddleware(lb, newHashKeyExtractor(remote.LB)), nil
+}
+
+// DefaultBalancer is the strategy used by the backends without an explicit one
+const DefaultBalancer = "round_robin"
+
+// BalancerFactory creates a balancer for the received backend config, using the subscriber as
+// source of hosts
+type BalancerFactory func(remote *config.Backend, subscriber sd.Subscriber) (sd.Balancer, error)
+
+var (
+	balancersMu sync.RWMutex
+	balancers   = map[string]BalancerFactory{
+		"round_robin":          roundRobinBalancer,
+		"random":               randomBalancer,
+		"weighted_round_robin": weightedRoundRobinBalancer,
+		"least_connections":    leastConnectionsBalancer,
+		"p2c_ewma":             p2cEWMABalancer,
+		"consistent_hash":      consistentHashBalancer,
+	}
+)
+
+// RegisterBalancer makes a balancing strategy available by the provided name, so it can be selected
+// from the lb section of the backend config. Registering an already registered name replaces it
+func RegisterBalancer(name string, bf BalancerFactory) {
+	balancersMu.Lock()
+	balancers[strings.ToLower(name)] = bf
+	balancersMu.Unlock()
+}
+
+func roundRobinBalancer(_ *config.Backend, subscriber sd.Subscriber) (sd.Balancer, error) {
+	return sd.NewRoundRobinLB(subscriber), nil
+}
+
+func randomBalancer(remote *config.Backend, subscriber sd.Subscriber) (sd.Balancer, error) {
+	return sd.NewRandomLB(subscriber, seed(remote.LB)), nil
+}
+
+func weightedRoundRobinBalancer(remote *config.Backend, subscriber sd.Subscriber) (sd.Balancer, error) {
+	weights := make(map[string]int, len(remote.LB.Weights))
+	for i, w := range remote.LB.Weights {
+		weights[remote.Host[i]] = w
+	}
+	return sd.NewWeightedRoundRobinLB(subscriber, weights), nil
+}
+
+func leastConnectionsBalancer(_ *config.Backend, subscriber sd.Subscriber) (sd.Balancer, error) {
+	return sd.NewLeastConnectionsLB(subscriber), nil
+}
+
+func p2cEWMABalancer(remote *config.Backend, subscriber sd.Subscriber) (sd.Balancer, error) {
+	return sd.NewP2CEWMALB(subscriber, seed(remote.LB), sd.DefaultEWMADecay), nil
+}
+
+func consistentHashBalancer(remote *config.Backend, subscriber sd.Subscriber) (sd.Balancer, error) {
+	if remote.LB.HashKey == "" {
+		return nil, ErrNoHashKey
+	}
+	return sd.NewConsistentHashLB(subscriber, sd.DefaultReplicas), nil
+}
+
+func seed(cfg config.LBConfig) int64 {
+	if cfg.Seed != 0 {
+		return cfg.Seed
+	}
+	return time.Now().UnixNano()
+}
+
+// SubscriberFactory creates the subscriber providing the hosts of the received backend
+type SubscriberFactory func(remote *config.Backend) (sd.Subscriber, error)
+
+var (
+	subscribersMu sync.RWMutex
+	subscribers   = map[string]SubscriberFactory{
+		config.StaticSD: staticSubscriber,
+	}
+)
+
+// RegisterSubscriber makes a service discovery subscriber available by the provided name, so it can be
+// selected from the sd field of the backend config. Registering an already registered name replaces it
+func RegisterSubscriber(name string, sf SubscriberFactory) {
+	subscribersMu.Lock()
+	subscribers[strings.ToLower(name)] = sf
+	subscribersMu.Unlock()
+}
+
+// NewSubscriber returns the subscriber defined by the sd field of the backend config. The static host
+// list is used when none is defined
+func NewSubscriber(remote *config.Backend) (sd.Subscriber, error) {
+	name := strings.ToLower(remote.SD)
+	if name == "" {
+		name = config.StaticSD
+	}
+	subscribersMu.RLock()
+	sf, ok := subscribers[name]
+	subscribersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown service discovery: %s", remote.SD)
+	}
+	return sf(remote)
+}
+
+func staticSubscriber(remote *config.Backend) (sd.Subscriber, error) {
+	return sd.FixedSubscriber(remote.Host), nil
+}
+
+// ErrNoHashKey is returned when a hashing strategy is selected without defining the hash key
+var ErrNoHashKey = errors.New("the hash key is required by the selected load balancing strategy")
+
+// hashKeyExtractor returns the value of the request attribute used as hash key
+type hashKeyExtractor func(r *Request) string
+
+func newHashKeyExtractor(cfg config.LBConfig) hashKeyExtractor {
+	source, name := cfg.HashKeySource()
+	switch source {
+	case config.HashKeyFromParam:
+		return func(r *Request) string { return r.Params[name] }
+	case config.HashKeyFromHeader:
+		return func(r *Request) string {
+			if v := r.Headers[name]; len(v) > 0 {
+				return v[0]
+			}
+			return ""
+		}
+	case config.HashKeyFromQuery:
+		return func(r *Request) string { return r.Query.Get(name) }
+	}
+	return nil
+}
+
 func NewRoundRobinLoadBalancedMiddleware(remote *config.Backend) Middleware {
-	return newLoadBalancedMiddleware(sd.NewRoundRobinLB(sd.FixedSubscriber(remote.Host)))
+	return newLoadBalancedMiddleware(sd.NewRoundRobinLB(sd.FixedSubscriber(remote.Host)), nil)
 }
 
 func NewRandomLoadBalancedMiddleware(remote *config.Backend) Middleware {
-	return newLoadBalancedMiddleware(sd.NewRandomLB(sd.FixedSubscriber(remote.Host), time.Now().UnixNano()))
+	return newLoadBalancedMiddleware(sd.NewRandomLB(sd.FixedSubscriber(remote.Host), time.Now().UnixNano()), nil)
 }
 
-func newLoadBalancedMiddleware(lb sd.Balancer) Middleware {
+// TierHeader is the header telling the backends the tier of the host receiving the request, when
+// the backend hosts are grouped in tiers
+const TierHeader = "X-Porta-Tier"
+
+func newLoadBalancedMiddleware(lb sd.Balancer, hashKey hashKeyExtractor) Middleware {
+	tracker, _ := lb.(sd.Tracker)
+	reporter, _ := lb.(sd.TierReporter)
 	return func(next ...Proxy) Proxy {
 		if len(next) > 1 {
 			panic(ErrTooManyProxies)
 		}
 		return func(ctx context.Context, request *Request) (*Response, error) {
-			host, err := lb.Host()
+			lbCtx := ctx
+			if hashKey != nil {
+				if key := hashKey(request); key != "" {
+					lbCtx = sd.NewContextWithHashKey(ctx, key)
+				}
+			}
+			host, err := lb.Host(lbCtx)
 			if err != nil {
 				return nil, err
 			}
 			r := request.Clone()
+			if reporter != nil {
+				if tier := reporter.Tier(host); tier != "" {
+					r.Headers = withHeader(r.Headers, TierHeader, tier)
+				}
+			}
 
 			rawURL := []byte{}
 			rawURL = append(rawURL, host...)
 			rawURL = append(rawURL, r.Path...)
 			r.URL, err = url.Parse(string(rawURL))
 			if err != nil {
+				if tracker != nil {
+					tracker.Release(host, 0, err)
+				}
 				return nil, err
 			}
 			r.URL.RawQuery = r.Query.Encode()
 
-			return next[0](ctx, &r)
+			if tracker == nil {
+				return next[0](ctx, &r)
+			}
+			begin := time.Now()
+			response, err := next[0](ctx, &r)
+			tracker.Release(host, time.Since(begin), hostError(response, err))
+			return response, err
+		}
+	}
+}
+
+// hostError returns the error of a request to be reported to the balancers: the transport errors,
+// the timeouts, the cancellations and the 5xx responses. The rest of the errors, like the 4xx
+// responses or the decoding ones, are caused by the request, so the host is reported as healthy
+func hostError(response *Response, err error) error {
+	if err == nil {
+		if response != nil && response.Metadata.StatusCode >= http.StatusInternalServerError {
+			return &StatusCodeError{StatusCode: response.Metadata.StatusCode, Err: ErrInvalidStatusCode}
 		}
+		return nil
+	}
+	var netErr net.Error
+	var statusErr *StatusCodeError
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr):
+		return err
+	case errors.As(err, &statusErr) && statusErr.StatusCode >= http.StatusInternalServerError:
+		return err
+	}
+	return nil
+}
+
+// tierLogger is a balancer decorator logging the tier of every selected host and the tier changes
+type tierLogger struct {
+	sd.Balancer
+	logger logging.Logger
+	name   string
+	mu     sync.Mutex
+	tier   string
+}
+
+func (t *tierLogger) Host(ctx context.Context) (string, error) {
+	host, err := t.Balancer.Host(ctx)
+	if err != nil {
+		return host, err
+	}
+	tier := t.Balancer.(sd.TierReporter).Tier(host)
+	t.logger.Debug(t.name, "using host", host, "from tier", tier)
+
+	t.mu.Lock()
+	previous := t.tier
+	t.tier = tier
+	t.mu.Unlock()
+	if previous != "" && previous != tier {
+		t.logger.Warning(t.name, "switching from tier", previous, "to tier", tier)
+	}
+	return host, nil
+}
+
+// Tier implements the sd.TierReporter interface
+func (t *tierLogger) Tier(host string) string {
+	return t.Balancer.(sd.TierReporter).Tier(host)
+}
+
+// Release implements the sd.Tracker interface
+func (t *tierLogger) Release(host string, latency time.Duration, err error) {
+	if tracker, ok := t.Balancer.(sd.Tracker); ok {
+		tracker.Release(host, latency, err)
+	}
+}
+
+// withHeader returns a copy of the headers with the received one set, so the headers shared by the
+// requests to the other backends are not changed
+func withHeader(headers map[string][]string, name, value string) map[string][]string {
+	h := make(map[string][]string, len(headers)+1)
+	for k, v := range headers {
+		h[k] = v
 	}
+	h[name] = []string{value}
+	return h
 }
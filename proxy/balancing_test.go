@@ -0,0 +1,283 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/logging/gologging"
+	"github.com/ph0m1/porta/sd"
+)
+
+func TestNewLoadBalancedMiddleware_consistentHash(t *testing.T) {
+	backend := config.Backend{
+		Host: []string{"http://a", "http://b", "http://c", "http://d"},
+		LB:   config.LBConfig{Strategy: "consistent_hash", HashKey: "header:X-User-Id"},
+	}
+	mw, err := NewLoadBalancedMiddleware(&backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var host string
+	p := mw(func(_ context.Context, r *Request) (*Response, error) {
+		host = r.URL.Host
+		return &Response{IsComplete: true}, nil
+	})
+
+	for _, user := range []string{"1", "2", "3", "4", "5"} {
+		request := Request{
+			Path:    "/supu",
+			Query:   url.Values{},
+			Headers: map[string][]string{"X-User-Id": {user}},
+		}
+		if _, err := p(context.Background(), &request); err != nil {
+			t.Fatal(err)
+		}
+		first := host
+		for i := 0; i < 10; i++ {
+			p(context.Background(), &request)
+			if host != first {
+				t.Errorf("user %s moved from %s to %s", user, first, host)
+			}
+		}
+	}
+}
+
+func TestNewLoadBalancedMiddleware_noHashKey(t *testing.T) {
+	backend := config.Backend{
+		Host: []string{"http://a"},
+		LB:   config.LBConfig{Strategy: "consistent_hash"},
+	}
+	if _, err := NewLoadBalancedMiddleware(&backend); err != ErrNoHashKey {
+		t.Errorf("want %v, have %v", ErrNoHashKey, err)
+	}
+}
+
+func TestNewHashKeyExtractor(t *testing.T) {
+	request := Request{
+		Params:  map[string]string{"User": "param"},
+		Headers: map[string][]string{"X-User-Id": {"header"}},
+		Query:   url.Values{"user": {"query"}},
+	}
+	for key, want := range map[string]string{
+		"param:User":       "param",
+		"header:X-User-Id": "header",
+		"query:user":       "query",
+		"query:unknown":    "",
+	} {
+		extractor := newHashKeyExtractor(config.LBConfig{HashKey: key})
+		if have := extractor(&request); have != want {
+			t.Errorf("%s: want %s, have %s", key, want, have)
+		}
+	}
+	if newHashKeyExtractor(config.LBConfig{}) != nil {
+		t.Error("unexpected extractor for an empty hash key")
+	}
+}
+
+func TestRegisterBalancer(t *testing.T) {
+	var subscribed []string
+	RegisterBalancer("First_Host", func(remote *config.Backend, subscriber sd.Subscriber) (sd.Balancer, error) {
+		subscribed, _ = subscriber.Hosts()
+		return firstHostLB{subscriber}, nil
+	})
+
+	backend := config.Backend{
+		Host: []string{"http://a", "http://b"},
+		LB:   config.LBConfig{Strategy: "first_host"},
+	}
+	mw, err := NewLoadBalancedMiddleware(&backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscribed) != 2 {
+		t.Errorf("unexpected hosts: %v", subscribed)
+	}
+
+	p := mw(func(_ context.Context, r *Request) (*Response, error) {
+		if r.URL.Host != "a" {
+			t.Errorf("unexpected host: %s", r.URL.Host)
+		}
+		return &Response{IsComplete: true}, nil
+	})
+	for i := 0; i < 5; i++ {
+		p(context.Background(), &Request{Path: "/", Query: url.Values{}})
+	}
+}
+
+func TestNewLoadBalancedMiddleware_seed(t *testing.T) {
+	backend := config.Backend{
+		Host: []string{"http://a", "http://b", "http://c", "http://d"},
+		LB:   config.LBConfig{Strategy: "random", Seed: 42},
+	}
+	sequence := func() []string {
+		hosts := []string{}
+		mw, err := NewLoadBalancedMiddleware(&backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := mw(func(_ context.Context, r *Request) (*Response, error) {
+			hosts = append(hosts, r.URL.Host)
+			return &Response{IsComplete: true}, nil
+		})
+		for i := 0; i < 20; i++ {
+			p(context.Background(), &Request{Path: "/", Query: url.Values{}})
+		}
+		return hosts
+	}
+	first, second := sequence(), sequence()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Errorf("the seeded random balancer is not deterministic: %v %v", first, second)
+			break
+		}
+	}
+}
+
+type firstHostLB struct {
+	subscriber sd.Subscriber
+}
+
+func (f firstHostLB) Host(_ context.Context) (string, error) {
+	hosts, err := f.subscriber.Hosts()
+	if err != nil || len(hosts) == 0 {
+		return "", sd.ErrNoHosts
+	}
+	return hosts[0], nil
+}
+
+func TestNewLoadBalancedMiddlewareWithLogger_tiers(t *testing.T) {
+	buff := bytes.NewBuffer(make([]byte, 1024))
+	logger, err := gologging.NewLogger("DEBUG", buff, "pref")
+	if err != nil {
+		t.Fatal("building the logger: ", err.Error())
+	}
+	backend := config.Backend{
+		URLPattern: "/supu",
+		Host:       []string{"http://a", "http://b"},
+		HostTiers: []config.HostTier{
+			{Name: "dc1", Host: []string{"http://a"}},
+			{Name: "dc2", Host: []string{"http://b"}},
+		},
+		LB: config.LBConfig{MaxFails: 1},
+	}
+	mw, err := NewLoadBalancedMiddlewareWithLogger(&backend, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tiers := []string{}
+	p := mw(func(_ context.Context, r *Request) (*Response, error) {
+		tiers = append(tiers, r.Headers[TierHeader]...)
+		if r.URL.Host == "a" {
+			return nil, &StatusCodeError{StatusCode: http.StatusBadGateway, Err: ErrInvalidStatusCode}
+		}
+		return &Response{IsComplete: true}, nil
+	})
+	headers := map[string][]string{"X-Supu": {"tupu"}}
+	if _, err := p(context.Background(), &Request{Path: "/", Query: url.Values{}, Headers: headers}); !errors.Is(err, ErrInvalidStatusCode) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := p(context.Background(), &Request{Path: "/", Query: url.Values{}, Headers: headers}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(tiers) != 2 || tiers[0] != "dc1" || tiers[1] != "dc2" {
+		t.Errorf("unexpected tiers sent to the backends: %v", tiers)
+	}
+	if len(headers) != 1 {
+		t.Errorf("the request headers were changed: %v", headers)
+	}
+	if !strings.Contains(buff.String(), "switching from tier dc1 to tier dc2") {
+		t.Errorf("tier change not logged: %s", buff.String())
+	}
+}
+
+func TestNewLoadBalancedMiddleware_tiersClientErrors(t *testing.T) {
+	backend := config.Backend{
+		URLPattern: "/supu",
+		Host:       []string{"http://a", "http://b"},
+		HostTiers: []config.HostTier{
+			{Name: "dc1", Host: []string{"http://a"}},
+			{Name: "dc2", Host: []string{"http://b"}},
+		},
+		LB: config.LBConfig{MaxFails: 1},
+	}
+	mw, err := NewLoadBalancedMiddleware(&backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hosts := []string{}
+	p := mw(func(_ context.Context, r *Request) (*Response, error) {
+		hosts = append(hosts, r.URL.Host)
+		return nil, &StatusCodeError{StatusCode: http.StatusBadRequest, Err: ErrInvalidStatusCode}
+	})
+	for i := 0; i < 3; i++ {
+		if _, err := p(context.Background(), &Request{Path: "/", Query: url.Values{}}); !errors.Is(err, ErrInvalidStatusCode) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	// the 4xx responses are caused by the requests, so the host is not ejected
+	if fmt.Sprint(hosts) != "[a a a]" {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+}
+
+func TestHostError(t *testing.T) {
+	for i, tc := range []struct {
+		response *Response
+		err      error
+		failed   bool
+	}{
+		{&Response{IsComplete: true}, nil, false},
+		{&Response{Metadata: Metadata{StatusCode: http.StatusServiceUnavailable}}, nil, true},
+		{&Response{Metadata: Metadata{StatusCode: http.StatusNotFound}}, nil, false},
+		{nil, &StatusCodeError{StatusCode: http.StatusInternalServerError, Err: ErrInvalidStatusCode}, true},
+		{nil, &StatusCodeError{StatusCode: http.StatusUnauthorized, Err: ErrInvalidStatusCode}, false},
+		{nil, &url.Error{Op: "Get", URL: "http://a", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, true},
+		{nil, context.DeadlineExceeded, true},
+		{nil, context.Canceled, true},
+		{nil, ErrResponseTooLarge, false},
+		{nil, errors.New("invalid character"), false},
+	} {
+		if err := hostError(tc.response, tc.err); (err != nil) != tc.failed {
+			t.Errorf("#%d: unexpected host error %v", i, err)
+		}
+	}
+}
+
+func TestRegisterSubscriber(t *testing.T) {
+	RegisterSubscriber("Supu", func(remote *config.Backend) (sd.Subscriber, error) {
+		return sd.FixedSubscriber{remote.SDConfig["host"].(string)}, nil
+	})
+
+	backend := config.Backend{
+		Host:     []string{"http://a"},
+		SD:       "supu",
+		SDConfig: map[string]interface{}{"host": "http://discovered"},
+	}
+	mw, err := NewLoadBalancedMiddleware(&backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := mw(func(_ context.Context, r *Request) (*Response, error) {
+		if r.URL.Host != "discovered" {
+			t.Errorf("unexpected host: %s", r.URL.Host)
+		}
+		return &Response{IsComplete: true}, nil
+	})
+	p(context.Background(), &Request{Path: "/", Query: url.Values{}})
+
+	backend.SD = "unknown"
+	if _, err := NewLoadBalancedMiddleware(&backend); err == nil {
+		t.Error("error expected with an unknown service discovery")
+	}
+}
@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/ph0m1/porta/cache"
+	"github.com/ph0m1/porta/config"
+)
+
+// NewCacheMiddleware returns a middleware keeping the complete responses of the endpoint in the
+// store defined by its cache config for its cache TTL. The concurrent requests missing the cache
+// with the same key wait for the first one instead of calling the next proxy. The middleware does
+// nothing when the endpoint has no cache config
+func NewCacheMiddleware(cfg *config.EndpointConfig) (Middleware, error) {
+	store, err := cache.New(cfg.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		if store == nil {
+			return next[0]
+		}
+		keys := newCacheKeyBuilder(cfg)
+		calls := &cacheCalls{calls: map[string]*cacheCall{}}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			key := keys.key(request)
+			if value, ok := store.Get(ctx, key); ok {
+				if response, err := decodeCachedResponse(value); err == nil {
+					return response, nil
+				}
+			}
+
+			call, leader := calls.join(key)
+			if !leader {
+				select {
+				case <-call.done:
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+				if call.value != nil {
+					if response, err := decodeCachedResponse(call.value); err == nil {
+						return response, nil
+					}
+				}
+				// the first request got nothing to share, so the request is not coalesced
+				return next[0](ctx, request)
+			}
+			defer calls.leave(key, call)
+
+			response, err := next[0](ctx, request)
+			if err != nil || response == nil || !response.IsComplete || response.Io != nil {
+				return response, err
+			}
+			if value, err := json.Marshal(cachedResponse{Data: response.Data, Metadata: response.Metadata}); err == nil {
+				store.Set(ctx, key, value, cfg.CacheTTL)
+				call.value = value
+			}
+			return response, nil
+		}
+	}, nil
+}
+
+// cachedResponse is the content of the cached responses. Only the complete ones are cached
+type cachedResponse struct {
+	Data     map[string]interface{} `json:"data"`
+	Metadata Metadata               `json:"metadata"`
+}
+
+// decodeCachedResponse returns a new response with the cached content, so the consumers of the
+// cached responses can not change the ones returned to the others. The numbers are kept as
+// json.Number, as in the responses decoded from the backends
+func decodeCachedResponse(value []byte) (*Response, error) {
+	var c cachedResponse
+	d := json.NewDecoder(bytes.NewReader(value))
+	d.UseNumber()
+	if err := d.Decode(&c); err != nil {
+		return nil, err
+	}
+	return &Response{Data: c.Data, Metadata: c.Metadata, IsComplete: true}, nil
+}
+
+// cacheCall is the backend call of the first request missing the cache with a key
+type cacheCall struct {
+	done chan struct{}
+	// encoded response of the call, set before closing the done channel when it was cached
+	value []byte
+}
+
+// cacheCalls holds the backend calls in progress by their cache key
+type cacheCalls struct {
+	mu    sync.Mutex
+	calls map[string]*cacheCall
+}
+
+// join returns the call in progress with the key, reporting whether it was created by the caller,
+// who must make the call and leave it
+func (c *cacheCalls) join(key string) (*cacheCall, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if call, ok := c.calls[key]; ok {
+		return call, false
+	}
+	call := &cacheCall{done: make(chan struct{})}
+	c.calls[key] = call
+	return call, true
+}
+
+// leave removes the finished call, waking up the requests waiting for it
+func (c *cacheCalls) leave(key string, call *cacheCall) {
+	c.mu.Lock()
+	delete(c.calls, key)
+	c.mu.Unlock()
+	close(call.done)
+}
+
+// cacheKeyBuilder creates the cache keys of the requests to an endpoint with the parts of the
+// requests selected by its cache config
+type cacheKeyBuilder struct {
+	prefix      string
+	params      []string
+	queryString []string
+	headers     []string
+}
+
+func newCacheKeyBuilder(cfg *config.EndpointConfig) cacheKeyBuilder {
+	b := cacheKeyBuilder{
+		prefix:      cfg.Method + " " + cfg.Endpoint,
+		queryString: cfg.Cache.QueryString,
+		headers:     cfg.Cache.Headers,
+	}
+	for _, p := range cfg.Cache.Params {
+		b.params = append(b.params, strings.Title(p))
+	}
+	return b
+}
+
+// ignoredCacheKeyHeaders are the headers added to every request to the backends, not included in
+// the cache keys by default
+var ignoredCacheKeyHeaders = map[string]struct{}{"X-Forwarded-For": {}, "User-Agent": {}}
+
+// key returns the cache key of the request: the hash of the method and path of the endpoint and
+// the selected params, query string params and headers of the request
+func (b cacheKeyBuilder) key(request *Request) string {
+	params := url.Values{}
+	if len(b.params) == 0 {
+		for k, v := range request.Params {
+			params.Set(k, v)
+		}
+	}
+	for _, k := range b.params {
+		if v, ok := request.Params[k]; ok {
+			params.Set(k, v)
+		}
+	}
+	query := url.Values{}
+	if len(b.queryString) == 0 {
+		for k, v := range request.Query {
+			query[k] = v
+		}
+	}
+	for _, k := range b.queryString {
+		if v, ok := request.Query[k]; ok {
+			query[k] = v
+		}
+	}
+	headers := url.Values{}
+	if len(b.headers) == 0 {
+		for k, v := range request.Headers {
+			if _, ok := ignoredCacheKeyHeaders[k]; !ok {
+				headers[k] = v
+			}
+		}
+	}
+	for _, k := range b.headers {
+		if v, ok := request.Headers[k]; ok {
+			headers[k] = v
+		}
+	}
+	hash := sha256.Sum256([]byte(b.prefix + "\n" + params.Encode() + "\n" + query.Encode() + "\n" + headers.Encode()))
+	return hex.EncodeToString(hash[:])
+}
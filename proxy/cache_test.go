@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func newCacheTestProxy(t *testing.T, cfg *config.EndpointConfig, next Proxy) Proxy {
+	mw, err := NewCacheMiddleware(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mw(next)
+}
+
+func TestNewCacheMiddleware(t *testing.T) {
+	var calls int32
+	p := newCacheTestProxy(t, &config.EndpointConfig{
+		Endpoint: "/users/{user}",
+		Method:   "GET",
+		CacheTTL: time.Minute,
+		Cache:    &config.CacheConfig{MaxEntries: 10, Headers: []string{"Authorization"}},
+	}, func(_ context.Context, r *Request) (*Response, error) {
+		n := atomic.AddInt32(&calls, 1)
+		return &Response{Data: map[string]interface{}{"user": r.Params["User"], "call": fmt.Sprint(n)}, IsComplete: true}, nil
+	})
+
+	for i, tc := range []struct {
+		user, authorization, forwardedFor string
+		call                              string
+	}{
+		{"supu", "", "10.0.0.1", "1"},
+		{"supu", "", "10.0.0.2", "1"},
+		{"tupu", "", "10.0.0.1", "2"},
+		{"supu", "Bearer supu", "10.0.0.1", "3"},
+		{"supu", "Bearer supu", "10.0.0.1", "3"},
+	} {
+		resp, err := p(context.Background(), &Request{
+			Params:  map[string]string{"User": tc.user},
+			Headers: map[string][]string{"Authorization": {tc.authorization}, "X-Forwarded-For": {tc.forwardedFor}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.IsComplete || resp.Data["user"] != tc.user || resp.Data["call"] != tc.call {
+			t.Errorf("#%d: unexpected response %+v", i, resp)
+		}
+		// the cached responses are not shared
+		resp.Data["user"] = "changed"
+	}
+}
+
+func TestNewCacheMiddleware_numbers(t *testing.T) {
+	p := newCacheTestProxy(t, &config.EndpointConfig{
+		Endpoint: "/users",
+		Method:   "GET",
+		CacheTTL: time.Minute,
+		Cache:    &config.CacheConfig{MaxEntries: 10},
+	}, func(_ context.Context, _ *Request) (*Response, error) {
+		return &Response{Data: map[string]interface{}{"id": json.Number("12345678901234567890")}, IsComplete: true}, nil
+	})
+
+	for i := 0; i < 2; i++ {
+		resp, err := p(context.Background(), &Request{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := json.Marshal(resp.Data)
+		if string(b) != `{"id":12345678901234567890}` {
+			t.Errorf("#%d: unexpected response %s", i, b)
+		}
+	}
+}
+
+func TestNewCacheMiddleware_notCached(t *testing.T) {
+	var calls int32
+	responses := []*Response{
+		{Data: map[string]interface{}{"supu": 1}},
+		nil,
+		{IsComplete: true, Io: newDummyReadCloser("supu")},
+	}
+	p := newCacheTestProxy(t, &config.EndpointConfig{Endpoint: "/supu", Method: "GET", CacheTTL: time.Minute, Cache: &config.CacheConfig{}}, func(_ context.Context, _ *Request) (*Response, error) {
+		n := atomic.AddInt32(&calls, 1)
+		if int(n) > len(responses) {
+			return nil, errors.New("supu")
+		}
+		return responses[n-1], nil
+	})
+	for i := 0; i < len(responses)+2; i++ {
+		p(context.Background(), &Request{})
+	}
+	if calls != int32(len(responses)+2) {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
+
+func TestNewCacheMiddleware_coalescing(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	p := newCacheTestProxy(t, &config.EndpointConfig{Endpoint: "/supu", Method: "GET", CacheTTL: time.Minute, Cache: &config.CacheConfig{}}, func(_ context.Context, _ *Request) (*Response, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &Response{Data: map[string]interface{}{"supu": "tupu"}, IsComplete: true}, nil
+	})
+
+	total := 10
+	wg := sync.WaitGroup{}
+	wg.Add(total)
+	for i := 0; i < total; i++ {
+		go func() {
+			defer wg.Done()
+			resp, err := p(context.Background(), &Request{})
+			if err != nil || resp.Data["supu"] != "tupu" {
+				t.Errorf("unexpected response: %v %v", resp, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
+
+func TestNewCacheMiddleware_waitingCanceled(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	p := newCacheTestProxy(t, &config.EndpointConfig{Endpoint: "/supu", Method: "GET", CacheTTL: time.Minute, Cache: &config.CacheConfig{}}, func(_ context.Context, _ *Request) (*Response, error) {
+		<-release
+		return &Response{IsComplete: true}, nil
+	})
+	go p(context.Background(), &Request{})
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p(ctx, &Request{}); err != context.DeadlineExceeded {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewCacheMiddleware_noCache(t *testing.T) {
+	var calls int32
+	p := newCacheTestProxy(t, &config.EndpointConfig{Endpoint: "/supu", Method: "GET", CacheTTL: time.Minute}, func(_ context.Context, _ *Request) (*Response, error) {
+		atomic.AddInt32(&calls, 1)
+		return &Response{IsComplete: true}, nil
+	})
+	p(context.Background(), &Request{})
+	p(context.Background(), &Request{})
+	if calls != 2 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+
+	if _, err := NewCacheMiddleware(&config.EndpointConfig{Cache: &config.CacheConfig{Store: "unknown"}}); err == nil {
+		t.Error("error expected with an unknown store")
+	}
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// NewHTTPClientFactory returns a factory of the http client configured by the received settings.
+// The client and its transport are created once, so all the requests of the backend share the same
+// connection pool. The default http client is used when there are no settings.
+//
+// When the settings include the TLS section, the transport is replaced every time the CA bundles or
+// the client certificate change on disk
+func NewHTTPClientFactory(cfg *config.HTTPClientConfig) HTTPClientFactory {
+	if cfg == nil {
+		return NewHttpClient
+	}
+	var transport http.RoundTripper = NewTransport(cfg)
+	if cfg.TLS != nil {
+		transport = newReloadingTransport(cfg)
+	}
+	client := &http.Client{Transport: transport}
+	return func(_ context.Context) *http.Client { return client }
+}
+
+// NewTransport returns a transport with the settings of the default one, replacing the ones defined
+// by the received config
+func NewTransport(cfg *config.HTTPClientConfig) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.DialTimeout > 0 || cfg.KeepAlive > 0 {
+		dialer := &net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: cfg.KeepAlive}
+		t.DialContext = dialer.DialContext
+	}
+	if cfg.MaxIdleConns > 0 {
+		t.MaxIdleConns = cfg.MaxIdleConns
+	}
+	if cfg.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	}
+	if cfg.MaxConnsPerHost > 0 {
+		t.MaxConnsPerHost = cfg.MaxConnsPerHost
+	}
+	if cfg.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+	if cfg.TLSHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = cfg.TLSHandshakeTimeout
+	}
+	if cfg.ResponseHeaderTimeout > 0 {
+		t.ResponseHeaderTimeout = cfg.ResponseHeaderTimeout
+	}
+	t.DisableKeepAlives = cfg.DisableKeepAlives
+	t.DisableCompression = cfg.DisableCompression
+	if cfg.DisableHTTP2 {
+		// a non nil empty map disables the HTTP/2 upgrade of the TLS connections
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t
+}
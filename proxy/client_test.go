@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestNewHTTPClientFactory(t *testing.T) {
+	if NewHTTPClientFactory(nil)(context.Background()) != http.DefaultClient {
+		t.Error("the default client should be used without settings")
+	}
+
+	cf := NewHTTPClientFactory(&config.HTTPClientConfig{MaxIdleConnsPerHost: 10})
+	client := cf(context.Background())
+	if client == http.DefaultClient || client != cf(context.Background()) {
+		t.Error("the client should be created once per factory")
+	}
+	if client.Transport.(*http.Transport).MaxIdleConnsPerHost != 10 {
+		t.Errorf("unexpected transport: %+v", client.Transport)
+	}
+}
+
+func TestNewTransport(t *testing.T) {
+	tr := NewTransport(&config.HTTPClientConfig{
+		MaxIdleConns:          20,
+		MaxIdleConnsPerHost:   5,
+		MaxConnsPerHost:       50,
+		IdleConnTimeout:       time.Minute,
+		DialTimeout:           time.Second,
+		TLSHandshakeTimeout:   2 * time.Second,
+		ResponseHeaderTimeout: 3 * time.Second,
+		DisableKeepAlives:     true,
+		DisableCompression:    true,
+	})
+	if tr.MaxIdleConns != 20 || tr.MaxIdleConnsPerHost != 5 || tr.MaxConnsPerHost != 50 || tr.IdleConnTimeout != time.Minute ||
+		tr.TLSHandshakeTimeout != 2*time.Second || tr.ResponseHeaderTimeout != 3*time.Second || !tr.DisableKeepAlives || !tr.DisableCompression {
+		t.Errorf("unexpected transport: %+v", tr)
+	}
+	if tr == http.DefaultTransport {
+		t.Error("the default transport should not be modified")
+	}
+
+	tr = NewTransport(&config.HTTPClientConfig{})
+	defaultTransport := http.DefaultTransport.(*http.Transport)
+	if tr.MaxIdleConns != defaultTransport.MaxIdleConns || tr.IdleConnTimeout != defaultTransport.IdleConnTimeout || !tr.ForceAttemptHTTP2 {
+		t.Errorf("the default settings were not kept: %+v", tr)
+	}
+}
+
+func TestNewTransport_http2(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig
+
+	for disabled, want := range map[bool]string{false: "HTTP/2.0", true: "HTTP/1.1"} {
+		tr := NewTransport(&config.HTTPClientConfig{DisableHTTP2: disabled})
+		tr.TLSClientConfig = tlsConfig.Clone()
+		resp, err := (&http.Client{Transport: tr}).Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.Proto != want {
+			t.Errorf("disabled: %v, want %s, have %s", disabled, want, resp.Proto)
+		}
+	}
+}
+
+func TestNewTransport_responseHeaderTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	client := NewHTTPClientFactory(&config.HTTPClientConfig{ResponseHeaderTimeout: 10 * time.Millisecond})(context.Background())
+	if _, err := client.Get(server.URL); err == nil {
+		t.Error("timeout error expected")
+	}
+}
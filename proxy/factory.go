@@ -1,6 +1,10 @@
 package proxy
 
 import (
+	"fmt"
+	"strings"
+	"sync"
+
 	"github.com/ph0m1/porta/config"
 	"github.com/ph0m1/porta/logging"
 )
@@ -31,6 +35,14 @@ func (pf defaultFactory) New(cfg *config.EndpointConfig) (p Proxy, err error) {
 	default:
 		p, err = pf.newMulti(cfg)
 	}
+	if err != nil {
+		return
+	}
+	cache, err := NewCacheMiddleware(cfg)
+	if err != nil {
+		return nil, err
+	}
+	p = cache(p)
 	return
 }
 
@@ -38,8 +50,17 @@ func (pf defaultFactory) newMulti(cfg *config.EndpointConfig) (p Proxy, err erro
 	backendProxy := make([]Proxy, len(cfg.Backend))
 
 	for i, backend := range cfg.Backend {
-		backendProxy[i] = pf.backendFactory(backend)
-		backendProxy[i] = NewRoundRobinLoadBalancedMiddleware(backend)(backendProxy[i])
+		lb, err := NewLoadBalancedMiddlewareWithLogger(backend, pf.logger)
+		if err != nil {
+			return nil, err
+		}
+		backendProxy[i], err = pf.newBackend(backend)
+		if err != nil {
+			return nil, err
+		}
+		backendProxy[i] = lb(backendProxy[i])
+		// every concurrent call takes a token, so the backend never receives more requests than allowed
+		backendProxy[i] = NewRateLimitMiddleware(backend.RateLimit)(backendProxy[i])
 		if backend.ConcurrentCalls > 1 {
 			backendProxy[i] = NewConcurrentMiddleware(backend)(backendProxy[i])
 		}
@@ -50,11 +71,52 @@ func (pf defaultFactory) newMulti(cfg *config.EndpointConfig) (p Proxy, err erro
 }
 
 func (pf defaultFactory) newSingle(cfg *config.EndpointConfig) (p Proxy, err error) {
-	p = pf.backendFactory(cfg.Backend[0])
-	p = NewRoundRobinLoadBalancedMiddleware(cfg.Backend[0])(p)
+	lb, err := NewLoadBalancedMiddlewareWithLogger(cfg.Backend[0], pf.logger)
+	if err != nil {
+		return
+	}
+	p, err = pf.newBackend(cfg.Backend[0])
+	if err != nil {
+		return
+	}
+	p = lb(p)
+	// every concurrent call takes a token, so the backend never receives more requests than allowed
+	p = NewRateLimitMiddleware(cfg.Backend[0].RateLimit)(p)
 	if cfg.Backend[0].ConcurrentCalls > 1 {
 		p = NewConcurrentMiddleware(cfg.Backend[0])(p)
 	}
 	p = NewRequestBuilderMiddleware(cfg.Backend[0])(p)
 	return
 }
+
+// newBackend returns the proxy consuming the backend with the protocol defined by its type. The
+// backend factory of the proxy factory is used for the http backends
+func (pf defaultFactory) newBackend(remote *config.Backend) (Proxy, error) {
+	name := strings.ToLower(remote.Type)
+	if name == "" || name == config.HTTPBackend {
+		return pf.backendFactory(remote), nil
+	}
+	backendBuildersMu.RLock()
+	bb, ok := backendBuilders[name]
+	backendBuildersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown backend type: %s", remote.Type)
+	}
+	return bb(remote)
+}
+
+// BackendBuilder creates the proxy consuming a backend with a protocol other than http
+type BackendBuilder func(remote *config.Backend) (Proxy, error)
+
+var (
+	backendBuildersMu sync.RWMutex
+	backendBuilders   = map[string]BackendBuilder{}
+)
+
+// RegisterBackend makes a backend type available by the provided name, so it can be selected from the
+// type field of the backend config. Registering an already registered name replaces it
+func RegisterBackend(name string, bb BackendBuilder) {
+	backendBuildersMu.Lock()
+	backendBuilders[strings.ToLower(name)] = bb
+	backendBuildersMu.Unlock()
+}
@@ -5,11 +5,13 @@ import (
 	"golang.org/x/net/context"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/ph0m1/porta/config"
 	"github.com/ph0m1/porta/logging/gologging"
+	"github.com/ph0m1/porta/ratelimit"
 )
 
 func TestNewDefautFactory(t *testing.T) {
@@ -106,3 +108,91 @@ func TestNewDefautFactory(t *testing.T) {
 		t.Errorf("The factory returned an unexpected error: %s\n", err.Error())
 	}
 }
+
+func TestNewDefautFactory_rateLimitedConcurrentCalls(t *testing.T) {
+	var calls int32
+	factory := NewDefaultFactory(func(_ *config.Backend) Proxy {
+		return func(_ context.Context, _ *Request) (*Response, error) {
+			atomic.AddInt32(&calls, 1)
+			// the incomplete responses wait for all the concurrent calls
+			return &Response{}, nil
+		}
+	}, nil)
+	backend := config.Backend{
+		URLPattern:      "/foo",
+		Host:            []string{"http://example.com"},
+		Timeout:         time.Second,
+		ConcurrentCalls: 3,
+		RateLimit:       &config.RateLimitConfig{MaxRate: 0.001, Capacity: 3},
+	}
+	p, err := factory.New(&config.EndpointConfig{Backend: []*config.Backend{&backend}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every concurrent call takes a token
+	if _, err := p(context.Background(), &Request{Path: "/foo"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p(context.Background(), &Request{Path: "/foo"}); ratelimit.RetryAfter(err) == "" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("unexpected number of calls to the backend: %d", n)
+	}
+}
+
+func TestNewDefautFactory_unknownBalancer(t *testing.T) {
+	factory := NewDefaultFactory(func(_ *config.Backend) Proxy { return NoopProxy }, nil)
+	backend := config.Backend{
+		Host: []string{"http://example.com"},
+		LB:   config.LBConfig{Strategy: "unknown"},
+	}
+	endpoint := config.EndpointConfig{Backend: []*config.Backend{&backend}}
+	if _, err := factory.New(&endpoint); err == nil {
+		t.Error("The factory should fail with an unknown balancing strategy")
+	}
+	endpoint.Backend = append(endpoint.Backend, &backend)
+	if _, err := factory.New(&endpoint); err == nil {
+		t.Error("The factory should fail with an unknown balancing strategy")
+	}
+}
+
+func TestNewDefautFactory_backendTypes(t *testing.T) {
+	factory := NewDefaultFactory(func(_ *config.Backend) Proxy {
+		return func(_ context.Context, _ *Request) (*Response, error) {
+			return &Response{Data: map[string]interface{}{"type": "http"}, IsComplete: true}, nil
+		}
+	}, nil)
+	RegisterBackend("Supu", func(remote *config.Backend) (Proxy, error) {
+		return func(_ context.Context, _ *Request) (*Response, error) {
+			return &Response{Data: map[string]interface{}{"type": remote.Type}, IsComplete: true}, nil
+		}, nil
+	})
+
+	for _, backendType := range []string{"", "http", "supu"} {
+		backend := config.Backend{Host: []string{"http://example.com"}, Type: backendType}
+		p, err := factory.New(&config.EndpointConfig{Backend: []*config.Backend{&backend}})
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", backendType, err)
+			continue
+		}
+		resp, err := p(context.Background(), &Request{})
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", backendType, err)
+			continue
+		}
+		want := backendType
+		if want == "" {
+			want = "http"
+		}
+		if resp.Data["type"] != want {
+			t.Errorf("%s: unexpected response %v", backendType, resp.Data)
+		}
+	}
+
+	backend := config.Backend{Host: []string{"http://example.com"}, Type: "unknown"}
+	if _, err := factory.New(&config.EndpointConfig{Backend: []*config.Backend{&backend, &backend}}); err == nil {
+		t.Error("The factory should fail with an unknown backend type")
+	}
+}
@@ -0,0 +1,216 @@
+// Package graphql provides the proxies turning the endpoint calls into graphql operations
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/encoding"
+	"github.com/ph0m1/porta/proxy"
+)
+
+// ErrInvalidStatusCode is returned when the graphql server answers with a status other than 2xx
+var ErrInvalidStatusCode = errors.New("graphql: invalid status code")
+
+// Error is an error reported by the graphql server
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// Errors is returned when the graphql server reports errors without returning any data
+type Errors []Error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Message
+	}
+	return "graphql: " + strings.Join(msgs, "; ")
+}
+
+// NewBackend is a proxy.BackendBuilder creating the proxy sending the operation defined by the
+// graphql section of the backend config. It can be registered with
+//
+//	proxy.RegisterBackend(config.GraphQLBackend, graphql.NewBackend)
+func NewBackend(remote *config.Backend) (proxy.Proxy, error) {
+	return NewProxy(remote, proxy.NewHTTPClientFactory(remote.HTTPClient))
+}
+
+// NewProxy returns a proxy posting the operation defined by the backend config to the URL of the
+// request with the clients created by the received factory.
+//
+// The variables of the operation are taken from the URL params and the query string params with
+// the same name, falling back to the defaults defined in the config, and coerced to the types
+// declared by the operation (Int, Float, Boolean and lists of them). The data of the graphql
+// response is the data of the proxy response. When the graphql server reports errors along with
+// data, the response is marked as incomplete and the errors are added under the errors key. When
+// there is no data, the errors are returned as an Errors error
+func NewProxy(remote *config.Backend, clientFactory proxy.HTTPClientFactory) (proxy.Proxy, error) {
+	query := remote.GraphQL.Query
+	if remote.GraphQL.QueryPath != "" {
+		b, err := os.ReadFile(remote.GraphQL.QueryPath)
+		if err != nil {
+			return nil, err
+		}
+		query = string(b)
+	}
+	if strings.TrimSpace(query) == "" {
+		return nil, errors.New("graphql: empty query")
+	}
+	definitions := variableDefinitions(query)
+	formatter := proxy.NewEntityFormatter(remote.Target, remote.Whitelist, remote.Blacklist, remote.Group, remote.Mapping)
+	decode := encoding.JSONDecoder
+	if remote.MaxDepth > 0 {
+		// the data is nested under the data key of the graphql response
+		decode = encoding.NewMaxDepthDecoder(decode, remote.MaxDepth+1)
+	}
+
+	return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+		body, err := json.Marshal(graphQLRequest{
+			Query:         query,
+			OperationName: remote.GraphQL.OperationName,
+			Variables:     variables(definitions, remote.GraphQL.Variables, request),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		u := *request.URL
+		u.RawQuery = ""
+		req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range request.Headers {
+			req.Header[k] = v
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		if request.Body != nil {
+			request.Body.Close()
+		}
+
+		resp, err := clientFactory(ctx).Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return nil, &proxy.StatusCodeError{StatusCode: resp.StatusCode, Err: ErrInvalidStatusCode}
+		}
+		if remote.MaxResponseSize > 0 {
+			if resp.ContentLength > remote.MaxResponseSize {
+				return nil, proxy.ErrResponseTooLarge
+			}
+			resp.Body = proxy.NewLimitedReadCloser(resp.Body, remote.MaxResponseSize, proxy.ErrResponseTooLarge)
+		}
+
+		var data map[string]interface{}
+		if err := decode(resp.Body, &data); err != nil {
+			return nil, err
+		}
+		result, _ := data["data"].(map[string]interface{})
+		graphQLErrors, _ := data["errors"].([]interface{})
+		if result == nil {
+			if len(graphQLErrors) == 0 {
+				return nil, errors.New("graphql: response without data")
+			}
+			return nil, toErrors(graphQLErrors)
+		}
+
+		r := formatter.Format(proxy.Response{Data: result, IsComplete: len(graphQLErrors) == 0})
+		if len(graphQLErrors) > 0 {
+			r.Data["errors"] = graphQLErrors
+		}
+		return &r, nil
+	}, nil
+}
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// variableDefinitionPattern matches the variable definitions of the operations ($name: Type)
+var variableDefinitionPattern = regexp.MustCompile(`\$([_A-Za-z][_0-9A-Za-z]*)\s*:\s*(\[?\s*[_A-Za-z][_0-9A-Za-z]*)`)
+
+// variableDefinitions returns the base type of the variables declared by the operations, prefixed
+// by [ for the lists
+func variableDefinitions(query string) map[string]string {
+	definitions := map[string]string{}
+	for _, m := range variableDefinitionPattern.FindAllStringSubmatch(query, -1) {
+		definitions[m[1]] = strings.Join(strings.Fields(m[2]), "")
+	}
+	return definitions
+}
+
+func variables(definitions map[string]string, defaults map[string]interface{}, request *proxy.Request) map[string]interface{} {
+	vars := make(map[string]interface{}, len(definitions))
+	for k, v := range defaults {
+		vars[k] = v
+	}
+	for name, typ := range definitions {
+		for k, v := range request.Query {
+			if strings.EqualFold(k, name) && len(v) > 0 {
+				vars[name] = coerce(typ, v)
+			}
+		}
+		for k, v := range request.Params {
+			if strings.EqualFold(k, name) {
+				vars[name] = coerce(typ, []string{v})
+			}
+		}
+	}
+	return vars
+}
+
+func coerce(typ string, values []string) interface{} {
+	if strings.HasPrefix(typ, "[") {
+		list := make([]interface{}, len(values))
+		for i, v := range values {
+			list[i] = coerceScalar(typ[1:], v)
+		}
+		return list
+	}
+	return coerceScalar(typ, values[0])
+}
+
+func coerceScalar(typ, v string) interface{} {
+	switch typ {
+	case "Int":
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return i
+		}
+	case "Float":
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	case "Boolean":
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return v
+}
+
+func toErrors(list []interface{}) Errors {
+	res := make(Errors, 0, len(list))
+	for _, e := range list {
+		m, _ := e.(map[string]interface{})
+		err := Error{Message: fmt.Sprint(m["message"])}
+		err.Path, _ = m["path"].([]interface{})
+		res = append(res, err)
+	}
+	return res
+}
@@ -0,0 +1,132 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/proxy"
+)
+
+const usersQuery = `query User($id: ID!, $limit: Int, $active: Boolean, $tags: [String!]) {
+	user(id: $id) { name posts(limit: $limit, active: $active, tags: $tags) { title } }
+}`
+
+func newGraphQLServer(t *testing.T, received chan<- map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || r.URL.RawQuery != "" {
+			t.Errorf("unexpected request: %s %s %v", r.Method, r.URL, r.Header)
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		received <- body
+
+		vars, _ := body["variables"].(map[string]interface{})
+		w.Header().Set("Content-Type", "application/json")
+		switch vars["id"] {
+		case "partial":
+			w.Write([]byte(`{"data":{"user":{"name":"supu","posts":null}},"errors":[{"message":"posts unavailable","path":["user","posts"]}]}`))
+		case "missing":
+			w.Write([]byte(`{"data":null,"errors":[{"message":"user not found"}]}`))
+		case "broken":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Write([]byte(`{"data":{"user":{"name":"supu","posts":[{"title":"hello"}]}}}`))
+		}
+	}))
+}
+
+func TestNewBackend(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	server := newGraphQLServer(t, received)
+	defer server.Close()
+
+	p, err := NewBackend(&config.Backend{
+		Type:   config.GraphQLBackend,
+		Target: "user",
+		GraphQL: config.GraphQLConfig{
+			Query:         usersQuery,
+			OperationName: "User",
+			Variables:     map[string]interface{}{"limit": 10, "active": true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(server.URL + "/graphql?limit=2")
+	resp, err := p(context.Background(), &proxy.Request{
+		URL:     u,
+		Params:  map[string]string{"Id": "42"},
+		Query:   url.Values{"limit": {"2"}, "tags": {"a", "b"}, "ignored": {"x"}},
+		Headers: map[string][]string{"Authorization": {"Bearer token"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsComplete || resp.Data["name"] != "supu" {
+		t.Errorf("unexpected response: %v", resp)
+	}
+
+	body := <-received
+	if body["query"] != usersQuery || body["operationName"] != "User" {
+		t.Errorf("unexpected operation: %v", body)
+	}
+	if vars := fmt.Sprint(body["variables"]); vars != "map[active:true id:42 limit:2 tags:[a b]]" {
+		t.Errorf("unexpected variables: %s", vars)
+	}
+}
+
+func TestNewBackend_errors(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	server := newGraphQLServer(t, received)
+	defer server.Close()
+
+	queryPath := filepath.Join(t.TempDir(), "user.graphql")
+	if err := os.WriteFile(queryPath, []byte(usersQuery), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewBackend(&config.Backend{Type: config.GraphQLBackend, GraphQL: config.GraphQLConfig{QueryPath: queryPath}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(server.URL + "/graphql")
+
+	resp, err := p(context.Background(), &proxy.Request{URL: u, Params: map[string]string{"Id": "partial"}})
+	<-received
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.IsComplete {
+		t.Error("the response with errors should be incomplete")
+	}
+	if errs, ok := resp.Data["errors"].([]interface{}); !ok || len(errs) != 1 || resp.Data["user"] == nil {
+		t.Errorf("unexpected response: %v", resp.Data)
+	}
+
+	_, err = p(context.Background(), &proxy.Request{URL: u, Params: map[string]string{"Id": "missing"}})
+	<-received
+	if errs, ok := err.(Errors); !ok || errs.Error() != "graphql: user not found" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err = p(context.Background(), &proxy.Request{URL: u, Params: map[string]string{"Id": "broken"}})
+	<-received
+	if !errors.Is(err, ErrInvalidStatusCode) {
+		t.Errorf("want %v, have %v", ErrInvalidStatusCode, err)
+	}
+
+	if _, err := NewBackend(&config.Backend{Type: config.GraphQLBackend, GraphQL: config.GraphQLConfig{QueryPath: "/nowhere/user.graphql"}}); err == nil {
+		t.Error("error expected with an unknown query file")
+	}
+}
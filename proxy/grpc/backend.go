@@ -0,0 +1,352 @@
+// Package grpc provides the proxies consuming unary grpc methods described by descriptor sets
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/encoding"
+	"github.com/ph0m1/porta/proxy"
+)
+
+var (
+	// ErrUnknownMethod is returned when the method is not defined by the descriptor sets
+	ErrUnknownMethod = errors.New("grpc: method not found in the descriptor sets")
+	// ErrStreamingMethod is returned when the method is a client or server streaming one
+	ErrStreamingMethod = errors.New("grpc: only the unary methods are supported")
+)
+
+// NewBackend is a proxy.BackendBuilder creating the proxy calling the unary method defined by the
+// grpc section of the backend config. It can be registered with
+//
+//	proxy.RegisterBackend(config.GRPCBackend, grpc.NewBackend)
+//
+// The request message is built from the JSON body of the request, the query string params and the
+// URL params, in that order, so the URL params take precedence. The query string params and the
+// URL params are matched with the fields of the message by name, ignoring the case, and their
+// nested fields can be set with dotted names (ie: filter.active). The response message is converted
+// into a map using the protobuf JSON mapping with the original field names, so it can be filtered,
+// mapped and merged like the responses of the http backends.
+//
+// The hosts with the https scheme are called over TLS, with the TLS settings of the http client
+// config of the backend, and the rest over plain text. The TLS files are reloaded when they change
+// on disk, so the new connections use the rotated certificates
+func NewBackend(remote *config.Backend) (proxy.Proxy, error) {
+	files, err := LoadDescriptorSets(remote.GRPC.DescriptorSets...)
+	if err != nil {
+		return nil, err
+	}
+	method, err := FindMethod(files, remote.GRPC.Method)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{}
+	if remote.HTTPClient != nil && remote.HTTPClient.TLS != nil {
+		if tlsConfig, err = proxy.NewReloadingTLSConfig(remote.HTTPClient.TLS); err != nil {
+			return nil, err
+		}
+	}
+	return NewProxy(remote, method, newConnPool(tlsConfig).conn), nil
+}
+
+// LoadDescriptorSets reads the serialized FileDescriptorSets stored at the received paths
+func LoadDescriptorSets(paths ...string) (*protoregistry.Files, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := map[string]struct{}{}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		s := &descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(b, s); err != nil {
+			return nil, fmt.Errorf("grpc: parsing the descriptor set %s: %w", path, err)
+		}
+		for _, f := range s.File {
+			if _, ok := seen[f.GetName()]; ok {
+				continue
+			}
+			seen[f.GetName()] = struct{}{}
+			set.File = append(set.File, f)
+		}
+	}
+	return protodesc.NewFiles(set)
+}
+
+// FindMethod returns the descriptor of the method with the received full name. Both the
+// package.Service/Method and the package.Service.Method formats are accepted
+func FindMethod(files *protoregistry.Files, name string) (protoreflect.MethodDescriptor, error) {
+	name = strings.Replace(strings.TrimPrefix(name, "/"), "/", ".", 1)
+	d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMethod, name)
+	}
+	method, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMethod, name)
+	}
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		return nil, ErrStreamingMethod
+	}
+	return method, nil
+}
+
+// ConnFactory returns the client connection to the received target. The https scheme requests a
+// TLS connection
+type ConnFactory func(scheme, target string) (gogrpc.ClientConnInterface, error)
+
+// NewProxy returns a proxy calling the received unary method with the connections provided by the
+// ConnFactory
+func NewProxy(remote *config.Backend, method protoreflect.MethodDescriptor, connFactory ConnFactory) proxy.Proxy {
+	formatter := proxy.NewEntityFormatter(remote.Target, remote.Whitelist, remote.Blacklist, remote.Group, remote.Mapping)
+	fullMethod := fmt.Sprintf("/%s/%s", method.Parent().FullName(), method.Name())
+	callOptions := []gogrpc.CallOption{}
+	if remote.MaxResponseSize > 0 {
+		callOptions = append(callOptions, gogrpc.MaxCallRecvMsgSize(int(remote.MaxResponseSize)))
+	}
+
+	return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+		in, err := newRequestMessage(method.Input(), request)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := connFactory(request.URL.Scheme, request.URL.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		out := dynamicpb.NewMessage(method.Output())
+		ctx = metadata.NewOutgoingContext(ctx, outgoingMetadata(request.Headers))
+		if err := conn.Invoke(ctx, fullMethod, in, out, callOptions...); err != nil {
+			if remote.MaxResponseSize > 0 && status.Code(err) == codes.ResourceExhausted && strings.Contains(status.Convert(err).Message(), "larger than max") {
+				return nil, proxy.ErrResponseTooLarge
+			}
+			return nil, err
+		}
+
+		data, err := toMap(out)
+		if err != nil {
+			return nil, err
+		}
+		r := formatter.Format(proxy.Response{Data: data, IsComplete: true})
+		return &r, nil
+	}
+}
+
+func newRequestMessage(md protoreflect.MessageDescriptor, request *proxy.Request) (*dynamicpb.Message, error) {
+	msg := dynamicpb.NewMessage(md)
+	if request.Body != nil {
+		b, err := io.ReadAll(request.Body)
+		request.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(b)) > 0 {
+			if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(b, msg); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for k, v := range request.Query {
+		if err := setField(msg, k, v); err != nil {
+			return nil, err
+		}
+	}
+	for k, v := range request.Params {
+		if err := setField(msg, k, []string{v}); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// setField sets the values to the field with the received dotted path. Unknown fields are ignored
+func setField(msg protoreflect.Message, path string, values []string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		fd := findField(msg.Descriptor(), part)
+		if fd == nil || fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+			return nil
+		}
+		msg = msg.Mutable(fd).Message()
+	}
+
+	fd := findField(msg.Descriptor(), parts[len(parts)-1])
+	if fd == nil || fd.IsMap() {
+		return nil
+	}
+	if !fd.IsList() {
+		v, err := parseValue(fd, values[0])
+		if err != nil {
+			return err
+		}
+		msg.Set(fd, v)
+		return nil
+	}
+	list := msg.Mutable(fd).List()
+	for _, s := range values {
+		v, err := parseValue(fd, s)
+		if err != nil {
+			return err
+		}
+		list.Append(v)
+	}
+	return nil
+}
+
+func findField(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	fields := md.Fields()
+	if fd := fields.ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	if fd := fields.ByJSONName(name); fd != nil {
+		return fd
+	}
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if strings.EqualFold(string(fd.Name()), name) || strings.EqualFold(fd.JSONName(), name) {
+			return fd
+		}
+	}
+	return nil
+}
+
+func parseValue(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	var (
+		v   interface{}
+		err error
+	)
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BoolKind:
+		var b bool
+		b, err = strconv.ParseBool(s)
+		v = b
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		var i int64
+		i, err = strconv.ParseInt(s, 10, 32)
+		v = int32(i)
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err = strconv.ParseInt(s, 10, 64)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		var u uint64
+		u, err = strconv.ParseUint(s, 10, 32)
+		v = uint32(u)
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err = strconv.ParseUint(s, 10, 64)
+	case protoreflect.FloatKind:
+		var f float64
+		f, err = strconv.ParseFloat(s, 32)
+		v = float32(f)
+	case protoreflect.DoubleKind:
+		v, err = strconv.ParseFloat(s, 64)
+	case protoreflect.BytesKind:
+		v, err = base64.StdEncoding.DecodeString(s)
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		var i int64
+		i, err = strconv.ParseInt(s, 10, 32)
+		v = protoreflect.EnumNumber(i)
+	default:
+		return protoreflect.Value{}, fmt.Errorf("grpc: the field %s can't be set from a string", fd.FullName())
+	}
+	if err != nil {
+		return protoreflect.Value{}, fmt.Errorf("grpc: invalid value for the field %s: %w", fd.FullName(), err)
+	}
+	return protoreflect.ValueOf(v), nil
+}
+
+// toMap converts the message into a map using the protobuf JSON mapping, so the 64 bits integers
+// are strings and the well known types get their JSON representation
+func toMap(msg proto.Message) (map[string]interface{}, error) {
+	b, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	var data map[string]interface{}
+	err = encoding.JSONDecoder(bytes.NewReader(b), &data)
+	return data, err
+}
+
+// reservedHeaders are the request headers not sent as metadata, because they are managed by grpc
+var reservedHeaders = map[string]struct{}{
+	"connection":        {},
+	"content-type":      {},
+	"host":              {},
+	"te":                {},
+	"transfer-encoding": {},
+	"user-agent":        {},
+}
+
+func outgoingMetadata(headers map[string][]string) metadata.MD {
+	md := metadata.MD{}
+	for k, v := range headers {
+		k = strings.ToLower(k)
+		if _, ok := reservedHeaders[k]; ok || strings.HasPrefix(k, "grpc-") || strings.HasPrefix(k, ":") {
+			continue
+		}
+		md[k] = v
+	}
+	return md
+}
+
+// connPool keeps a client connection per target, shared by all the requests of a backend. The TLS
+// connections use the TLS settings of the backend
+type connPool struct {
+	mu    sync.Mutex
+	conns map[string]*gogrpc.ClientConn
+	tls   *tls.Config
+}
+
+func newConnPool(tlsConfig *tls.Config) *connPool {
+	return &connPool{conns: map[string]*gogrpc.ClientConn{}, tls: tlsConfig}
+}
+
+func (p *connPool) conn(scheme, target string) (gogrpc.ClientConnInterface, error) {
+	key := scheme + "://" + target
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.conns[key]; ok {
+		return c, nil
+	}
+	creds := insecure.NewCredentials()
+	if scheme == "https" {
+		creds = credentials.NewTLS(p.tls.Clone())
+	}
+	c, err := gogrpc.NewClient(target, gogrpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	p.conns[key] = c
+	return c, nil
+}
@@ -0,0 +1,321 @@
+package grpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/proxy"
+)
+
+func field(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+	f := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(number),
+		Type:     typ.Enum(),
+		Label:    label.Enum(),
+	}
+	if typeName != "" {
+		f.TypeName = proto.String(typeName)
+	}
+	return f
+}
+
+func writeDescriptorSet(t *testing.T) string {
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("users.proto"),
+		Package: proto.String("porta.test"),
+		Syntax:  proto.String("proto3"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Kind"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("UNKNOWN"), Number: proto.Int32(0)},
+				{Name: proto.String("ADMIN"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name:  proto.String("Filter"),
+				Field: []*descriptorpb.FieldDescriptorProto{field("active", 1, descriptorpb.FieldDescriptorProto_TYPE_BOOL, optional, "")},
+			},
+			{
+				Name: proto.String("GetUserRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64, optional, ""),
+					field("name", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+					field("tags", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, repeated, ""),
+					field("filter", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional, ".porta.test.Filter"),
+					field("kind", 5, descriptorpb.FieldDescriptorProto_TYPE_ENUM, optional, ".porta.test.Kind"),
+				},
+			},
+			{
+				Name: proto.String("User"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT32, optional, ""),
+					field("greeting", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+					field("tags", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, repeated, ""),
+					field("active", 4, descriptorpb.FieldDescriptorProto_TYPE_BOOL, optional, ""),
+					field("kind", 5, descriptorpb.FieldDescriptorProto_TYPE_ENUM, optional, ".porta.test.Kind"),
+					field("trace", 6, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Users"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{Name: proto.String("GetUser"), InputType: proto.String(".porta.test.GetUserRequest"), OutputType: proto.String(".porta.test.User")},
+				{Name: proto.String("WatchUsers"), InputType: proto.String(".porta.test.GetUserRequest"), OutputType: proto.String(".porta.test.User"), ServerStreaming: proto.Bool(true)},
+			},
+		}},
+	}
+	b, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "users.pb")
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newUsersServer starts a grpc server implementing the GetUser method with dynamic messages, over
+// TLS when a TLS config is received
+func newUsersServer(t *testing.T, method protoreflect.MethodDescriptor, tlsConfig *tls.Config) string {
+	handler := func(_ interface{}, stream gogrpc.ServerStream) error {
+		name, _ := gogrpc.MethodFromServerStream(stream)
+		if name != "/porta.test.Users/GetUser" {
+			return status.Errorf(codes.Unimplemented, "unknown method %s", name)
+		}
+		in := dynamicpb.NewMessage(method.Input())
+		if err := stream.RecvMsg(in); err != nil {
+			return err
+		}
+		get := func(m protoreflect.Message, name string) protoreflect.Value {
+			return m.Get(m.Descriptor().Fields().ByName(protoreflect.Name(name)))
+		}
+		if get(in, "id").Int() == 404 {
+			return status.Error(codes.NotFound, "user not found")
+		}
+
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		out := dynamicpb.NewMessage(method.Output())
+		fields := out.Descriptor().Fields()
+		out.Set(fields.ByName("id"), protoreflect.ValueOfInt32(int32(get(in, "id").Int())))
+		out.Set(fields.ByName("greeting"), protoreflect.ValueOfString("hello "+get(in, "name").String()))
+		tags := out.Mutable(fields.ByName("tags")).List()
+		for i, l := 0, get(in, "tags").List(); i < l.Len(); i++ {
+			tags.Append(l.Get(i))
+		}
+		out.Set(fields.ByName("active"), get(get(in, "filter").Message(), "active"))
+		out.Set(fields.ByName("kind"), get(in, "kind"))
+		out.Set(fields.ByName("trace"), protoreflect.ValueOfString(strings.Join(md.Get("x-trace-id"), ",")))
+		return stream.SendMsg(out)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig != nil {
+		server := gogrpc.NewServer(gogrpc.UnknownServiceHandler(handler), gogrpc.Creds(credentials.NewTLS(tlsConfig)))
+		go server.Serve(l)
+		t.Cleanup(server.Stop)
+		return "https://" + l.Addr().String()
+	}
+	server := gogrpc.NewServer(gogrpc.UnknownServiceHandler(handler))
+	go server.Serve(l)
+	t.Cleanup(server.Stop)
+	return "http://" + l.Addr().String()
+}
+
+func TestNewBackend(t *testing.T) {
+	descriptorSet := writeDescriptorSet(t)
+	files, err := LoadDescriptorSets(descriptorSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	method, err := FindMethod(files, "porta.test.Users/GetUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := newUsersServer(t, method, nil)
+
+	p, err := NewBackend(&config.Backend{
+		Type:      config.GRPCBackend,
+		GRPC:      config.GRPCConfig{DescriptorSets: []string{descriptorSet}, Method: "porta.test.Users/GetUser"},
+		Blacklist: []string{"active"},
+		Mapping:   map[string]string{"greeting": "message"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(host + "/ignored")
+	resp, err := p(context.Background(), &proxy.Request{
+		Method:  "POST",
+		URL:     u,
+		Query:   url.Values{"tags": []string{"a", "b"}, "filter.active": []string{"true"}, "kind": []string{"ADMIN"}},
+		Params:  map[string]string{"Id": "42"},
+		Headers: map[string][]string{"X-Trace-Id": {"abc"}, "User-Agent": {"porta"}},
+		Body:    io.NopCloser(strings.NewReader(`{"name":"supu","id":"1","unknown":true}`)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsComplete {
+		t.Error("the response should be complete")
+	}
+	for k, want := range map[string]string{
+		"id":      "42",
+		"message": "hello supu",
+		"tags":    "[a b]",
+		"kind":    "ADMIN",
+		"trace":   "abc",
+	} {
+		if have := fmt.Sprint(resp.Data[k]); have != want {
+			t.Errorf("%s: want %s, have %s", k, want, have)
+		}
+	}
+	if _, ok := resp.Data["active"]; ok {
+		t.Errorf("the blacklisted field was not removed: %v", resp.Data)
+	}
+
+	u.Path = "/"
+	_, err = p(context.Background(), &proxy.Request{URL: u, Params: map[string]string{"Id": "404"}})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = p(context.Background(), &proxy.Request{URL: u, Params: map[string]string{"Id": "tupu"}}); err == nil {
+		t.Error("error expected with an invalid param")
+	}
+}
+
+func TestNewBackend_tls(t *testing.T) {
+	descriptorSet := writeDescriptorSet(t)
+	files, err := LoadDescriptorSets(descriptorSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	method, err := FindMethod(files, "porta.test.Users/GetUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "users"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:              []string{"users"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	host := newUsersServer(t, method, &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}})
+	u, _ := url.Parse(host + "/")
+
+	for _, tc := range []struct {
+		name string
+		tls  *config.ClientTLSConfig
+		ok   bool
+	}{
+		{name: "trusted CA", tls: &config.ClientTLSConfig{CACerts: []string{caCert}, ServerName: "users"}, ok: true},
+		{name: "system CAs", tls: &config.ClientTLSConfig{ServerName: "users"}},
+		{name: "wrong server name", tls: &config.ClientTLSConfig{CACerts: []string{caCert}, ServerName: "supu"}},
+	} {
+		p, err := NewBackend(&config.Backend{
+			Type:       config.GRPCBackend,
+			GRPC:       config.GRPCConfig{DescriptorSets: []string{descriptorSet}, Method: "porta.test.Users/GetUser"},
+			HTTPClient: &config.HTTPClientConfig{TLS: tc.tls},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		resp, err := p(ctx, &proxy.Request{URL: u, Params: map[string]string{"Id": "42"}})
+		cancel()
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("%s: error expected", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if id := fmt.Sprint(resp.Data["id"]); id != "42" {
+			t.Errorf("%s: unexpected response: %v", tc.name, resp.Data)
+		}
+	}
+
+	_, err = NewBackend(&config.Backend{
+		Type:       config.GRPCBackend,
+		GRPC:       config.GRPCConfig{DescriptorSets: []string{descriptorSet}, Method: "porta.test.Users/GetUser"},
+		HTTPClient: &config.HTTPClientConfig{TLS: &config.ClientTLSConfig{CACerts: []string{"/nowhere/ca.pem"}}},
+	})
+	if err == nil {
+		t.Error("error expected with a missing CA bundle")
+	}
+}
+
+func TestNewBackend_ko(t *testing.T) {
+	descriptorSet := writeDescriptorSet(t)
+	for _, cfg := range []config.GRPCConfig{
+		{DescriptorSets: []string{"/nowhere/users.pb"}, Method: "porta.test.Users/GetUser"},
+		{DescriptorSets: []string{descriptorSet}, Method: "porta.test.Users/Unknown"},
+		{DescriptorSets: []string{descriptorSet}, Method: "porta.test.User"},
+	} {
+		if _, err := NewBackend(&config.Backend{Type: config.GRPCBackend, GRPC: cfg}); err == nil {
+			t.Errorf("error expected with the config %v", cfg)
+		}
+	}
+	_, err := NewBackend(&config.Backend{Type: config.GRPCBackend, GRPC: config.GRPCConfig{DescriptorSets: []string{descriptorSet}, Method: "/porta.test.Users/WatchUsers"}})
+	if !errors.Is(err, ErrStreamingMethod) {
+		t.Errorf("want %v, have %v", ErrStreamingMethod, err)
+	}
+}
@@ -3,21 +3,39 @@ package proxy
 import (
 	"context"
 	"errors"
+	"io"
 	"net/http"
+	"strings"
 
+	"github.com/ph0m1/porta/compression"
 	"github.com/ph0m1/porta/config"
 	"github.com/ph0m1/porta/encoding"
 )
 
 var ErrInvalidStatusCode = errors.New("Invalid status code")
 
+// StatusCodeError is returned when a backend answers with an unexpected status code. It wraps the
+// invalid status code error of the backend, so it can be matched with errors.Is
+type StatusCodeError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *StatusCodeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusCodeError) Unwrap() error {
+	return e.Err
+}
+
 // creates http client based with the received context
 type HTTPClientFactory func(ctx context.Context) *http.Client
 
 func NewHttpClient(_ context.Context) *http.Client { return http.DefaultClient }
 
 func httpProxy(backend *config.Backend) Proxy {
-	return NewHttpProxy(backend, NewHttpClient, backend.Decoder)
+	return NewHttpProxy(backend, NewHTTPClientFactory(backend.HTTPClient), backend.Decoder)
 }
 
 func NewRequestBuilderMiddleware(remote *config.Backend) Middleware {
@@ -43,6 +61,13 @@ func NewHttpProxy(remote *config.Backend, clientFactory HTTPClientFactory, decod
 			return nil, err
 		}
 		requestToBackend.Header = request.Headers
+		if len(remote.AcceptEncoding) > 0 {
+			requestToBackend.Header = make(http.Header, len(request.Headers)+1)
+			for k, v := range request.Headers {
+				requestToBackend.Header[k] = v
+			}
+			requestToBackend.Header.Set("Accept-Encoding", strings.Join(remote.AcceptEncoding, ", "))
+		}
 
 		resp, err := clientFactory(ctx).Do(requestToBackend.WithContext(ctx))
 		requestToBackend.Body.Close()
@@ -55,16 +80,66 @@ func NewHttpProxy(remote *config.Backend, clientFactory HTTPClientFactory, decod
 		if err != nil {
 			return nil, err
 		}
+		if ce := resp.Header.Get("Content-Encoding"); ce != "" && remote.Encoding != encoding.NOOP {
+			body, err := compression.NewReader(ce, resp.Body)
+			if err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+			resp.Body = readCloser{body, resp.Body}
+			resp.ContentLength = -1
+		}
+		if remote.MaxResponseSize > 0 {
+			if resp.ContentLength > remote.MaxResponseSize {
+				resp.Body.Close()
+				return nil, ErrResponseTooLarge
+			}
+			resp.Body = NewLimitedReadCloser(resp.Body, remote.MaxResponseSize, ErrResponseTooLarge)
+		}
+		if remote.Encoding == encoding.NOOP {
+			// the body, status and headers are passed to the client untouched, so the
+			// formatter is skipped and the body is streamed by the router
+			return &Response{
+				IsComplete: true,
+				Io:         resp.Body,
+				Metadata:   Metadata{Headers: resp.Header, StatusCode: resp.StatusCode},
+			}, nil
+		}
 		if resp.StatusCode != http.StatusCreated {
-			return nil, ErrInvalidStatusCode
+			resp.Body.Close()
+			return nil, &StatusCodeError{StatusCode: resp.StatusCode, Err: ErrInvalidStatusCode}
+		}
+		decoder := decode
+		if remote.Encoding == encoding.AUTO {
+			if d, ok := encoding.ForContentType(resp.Header.Get("Content-Type")); ok {
+				decoder = d
+			}
+		}
+		if remote.MaxDepth > 0 {
+			decoder = encoding.NewMaxDepthDecoder(decoder, remote.MaxDepth)
 		}
 		var data map[string]interface{}
-		err = decode(resp.Body, &data)
+		err = decoder(resp.Body, &data)
 		resp.Body.Close()
 		if err != nil {
+			// some decoders wrap the read errors, hiding the size limit one
+			if limited, ok := resp.Body.(*LimitedReadCloser); ok && limited.Exceeded() {
+				return nil, ErrResponseTooLarge
+			}
 			return nil, err
 		}
-		r := formatter.Format(Response{data, true})
+		r := formatter.Format(Response{Data: data, IsComplete: true})
 		return &r, nil
 	}
 }
+
+// readCloser reads from the decompressing reader and closes both the reader and the original body
+type readCloser struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+func (r readCloser) Close() error {
+	r.ReadCloser.Close()
+	return r.body.Close()
+}
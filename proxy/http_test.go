@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/compression"
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/encoding"
+)
+
+func TestNewHttpProxy_autoEncoding(t *testing.T) {
+	bodies := map[string]string{
+		"/json": `{"supu":"json"}`,
+		"/xml":  `<doc><supu>xml</supu></doc>`,
+		"/yaml": "supu: yaml",
+		"/none": `{"supu":"fallback"}`,
+	}
+	contentTypes := map[string]string{
+		"/json": "application/json; charset=utf-8",
+		"/xml":  "application/xml",
+		"/yaml": "application/x-yaml",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct, ok := contentTypes[r.URL.Path]; ok {
+			w.Header().Set("Content-Type", ct)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(bodies[r.URL.Path]))
+	}))
+	defer server.Close()
+
+	backend := &config.Backend{Encoding: encoding.AUTO}
+	p := NewHttpProxy(backend, NewHttpClient, encoding.JSONDecoder)
+
+	for path := range bodies {
+		u, _ := url.Parse(server.URL + path)
+		resp, err := p(context.Background(), &Request{Method: "GET", URL: u, Body: newDummyReadCloser("")})
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", path, err)
+			continue
+		}
+		data := resp.Data
+		if path == "/xml" {
+			data, _ = data["doc"].(map[string]interface{})
+		}
+		if data["supu"] == nil {
+			t.Errorf("%s: unexpected response %v", path, resp.Data)
+		}
+	}
+}
+
+func TestNewHttpProxy_legacyEncodings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/report":
+			w.Header().Set("Content-Type", "text/csv")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("id;name\n1;supu\n2;tupu\n"))
+		case "/form":
+			w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("status=ok&tags=a&tags=b"))
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"total":2}`))
+		}
+	}))
+	defer server.Close()
+
+	endpoint := &config.EndpointConfig{
+		Endpoint: "/supu",
+		Timeout:  time.Second,
+		Backend: []*config.Backend{
+			{URLPattern: "/report", Encoding: encoding.CSV, CSVDelimiter: ";", Group: "report"},
+			{URLPattern: "/form", Encoding: encoding.AUTO},
+			{URLPattern: "/json"},
+		},
+	}
+	subject := config.ServiceConfig{Version: 1, Host: []string{server.URL}, Endpoints: []*config.EndpointConfig{endpoint}}
+	if err := subject.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	backends := make([]Proxy, len(endpoint.Backend))
+	for i, b := range endpoint.Backend {
+		p := NewHttpProxy(b, NewHttpClient, b.Decoder)
+		path := b.URLPattern
+		backends[i] = func(ctx context.Context, r *Request) (*Response, error) {
+			u, _ := url.Parse(server.URL + path)
+			return p(ctx, &Request{Method: "GET", URL: u, Body: newDummyReadCloser("")})
+		}
+	}
+	resp, err := NewMergeDataMiddleware(endpoint)(backends...)(context.Background(), &Request{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsComplete {
+		t.Error("the response should be complete")
+	}
+	if data := fmt.Sprint(resp.Data); data != "map[report:map[rows:[map[id:1 name:supu] map[id:2 name:tupu]]] status:ok tags:[a b] total:2]" {
+		t.Errorf("unexpected response: %s", data)
+	}
+}
+
+func TestNewHttpProxy_noopEncoding(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("a,b\n1,2\n"))
+	}))
+	defer server.Close()
+
+	backend := &config.Backend{Encoding: encoding.NOOP, Whitelist: []string{"a"}}
+	p := NewHttpProxy(backend, NewHttpClient, nil)
+
+	u, _ := url.Parse(server.URL)
+	resp, err := p(context.Background(), &Request{Method: "GET", URL: u, Body: newDummyReadCloser("")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data != nil || !resp.IsComplete {
+		t.Errorf("unexpected response: %v", resp)
+	}
+	if resp.Metadata.StatusCode != http.StatusPartialContent {
+		t.Errorf("unexpected status code: %d", resp.Metadata.StatusCode)
+	}
+	if ct := resp.Metadata.Headers["Content-Type"]; len(ct) != 1 || ct[0] != "text/csv" {
+		t.Errorf("unexpected headers: %v", resp.Metadata.Headers)
+	}
+	b, err := io.ReadAll(resp.Io)
+	resp.Io.(io.Closer).Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "a,b\n1,2\n" {
+		t.Errorf("unexpected body: %s", b)
+	}
+}
+
+func TestNewHttpProxy_limits(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := `{"supu":{"tupu":[1,2,3]}}`
+		if r.URL.Path == "/chunked" {
+			w.WriteHeader(http.StatusCreated)
+			w.(http.Flusher).Flush()
+		} else {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.WriteHeader(http.StatusCreated)
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		path    string
+		backend config.Backend
+		err     error
+	}{
+		{"/", config.Backend{MaxResponseSize: 100, MaxDepth: 3}, nil},
+		{"/", config.Backend{MaxResponseSize: 10}, ErrResponseTooLarge},
+		{"/chunked", config.Backend{MaxResponseSize: 10}, ErrResponseTooLarge},
+		{"/chunked", config.Backend{Encoding: encoding.AUTO, MaxResponseSize: 10}, ErrResponseTooLarge},
+		{"/", config.Backend{MaxDepth: 2}, encoding.ErrMaxDepthExceeded},
+	} {
+		p := NewHttpProxy(&tc.backend, NewHttpClient, encoding.JSONDecoder)
+		u, _ := url.Parse(server.URL + tc.path)
+		_, err := p(context.Background(), &Request{Method: "GET", URL: u, Body: newDummyReadCloser("")})
+		if err != tc.err {
+			t.Errorf("%s %+v: want %v, have %v", tc.path, tc.backend, tc.err, err)
+		}
+	}
+}
+
+func TestNewHttpProxy_compressedResponses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ae := r.Header.Get("Accept-Encoding"); ae != "br, zstd, gzip" {
+			t.Errorf("unexpected Accept-Encoding header: %s", ae)
+		}
+		coding := r.URL.Path[1:]
+		body, err := compression.Compress(coding, []byte(`{"supu":"`+coding+`"}`), 0)
+		if err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Encoding", coding)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	backend := &config.Backend{AcceptEncoding: []string{"br", "zstd", "gzip"}}
+	p := NewHttpProxy(backend, NewHttpClient, encoding.JSONDecoder)
+	headers := map[string][]string{"Accept-Encoding": {"deflate"}}
+	for _, coding := range []string{compression.Brotli, compression.Zstd, compression.Gzip} {
+		u, _ := url.Parse(server.URL + "/" + coding)
+		resp, err := p(context.Background(), &Request{Method: "GET", URL: u, Headers: headers, Body: newDummyReadCloser("")})
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", coding, err)
+			continue
+		}
+		if resp.Data["supu"] != coding {
+			t.Errorf("%s: unexpected response %v", coding, resp.Data)
+		}
+	}
+	if ae := headers["Accept-Encoding"]; len(ae) != 1 || ae[0] != "deflate" {
+		t.Errorf("the request headers were modified: %v", headers)
+	}
+
+	u, _ := url.Parse(server.URL + "/" + compression.Brotli)
+	backend = &config.Backend{AcceptEncoding: []string{"br", "zstd", "gzip"}, MaxResponseSize: 10}
+	p = NewHttpProxy(backend, NewHttpClient, encoding.JSONDecoder)
+	if _, err := p(context.Background(), &Request{Method: "GET", URL: u, Body: newDummyReadCloser("")}); err != ErrResponseTooLarge {
+		t.Errorf("want %v, have %v", ErrResponseTooLarge, err)
+	}
+}
+
+func TestLimitedReadCloser(t *testing.T) {
+	errLimit := errors.New("limit")
+	for body, want := range map[string]error{"": nil, "supu": nil, "supu!": errLimit} {
+		l := NewLimitedReadCloser(newDummyReadCloser(body), 4, errLimit)
+		b, err := io.ReadAll(l)
+		if err != want {
+			t.Errorf("%s: want %v, have %v", body, want, err)
+		}
+		if l.Exceeded() != (want != nil) {
+			t.Errorf("%s: unexpected exceeded flag", body)
+		}
+		if len(b) > 4 {
+			t.Errorf("%s: too many bytes read: %s", body, b)
+		}
+	}
+}
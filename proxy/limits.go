@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+var (
+	// ErrResponseTooLarge is returned when the backend response exceeds its max size
+	ErrResponseTooLarge = errors.New("the backend response exceeds the max size")
+	// ErrRequestTooLarge is returned when the request body exceeds the max size of the endpoint
+	ErrRequestTooLarge = errors.New("the request body exceeds the max size")
+)
+
+// LimitedReadCloser is an io.ReadCloser failing with a custom error once the wrapped one returns
+// more bytes than allowed
+type LimitedReadCloser struct {
+	rc        io.ReadCloser
+	remaining int64
+	err       error
+	exceeded  atomic.Bool
+}
+
+// NewLimitedReadCloser returns a LimitedReadCloser allowing max bytes to be read from rc. The reads
+// over the limit return the received error
+func NewLimitedReadCloser(rc io.ReadCloser, max int64, err error) *LimitedReadCloser {
+	return &LimitedReadCloser{rc: rc, remaining: max, err: err}
+}
+
+// Read implements the io.Reader interface
+func (l *LimitedReadCloser) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, l.err
+	}
+	// read one byte over the limit in order to detect the bodies exceeding it
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.rc.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		l.exceeded.Store(true)
+		return n + int(l.remaining), l.err
+	}
+	return n, err
+}
+
+// Close implements the io.Closer interface
+func (l *LimitedReadCloser) Close() error {
+	return l.rc.Close()
+}
+
+// Exceeded reports if the reader got more bytes than allowed
+func (l *LimitedReadCloser) Exceeded() bool {
+	return l.exceeded.Load()
+}
@@ -3,6 +3,8 @@ package proxy
 import (
 	"context"
 	"errors"
+	"io"
+
 	"github.com/ph0m1/porta/config"
 )
 
@@ -10,6 +12,17 @@ import (
 type Response struct {
 	Data       map[string]interface{}
 	IsComplete bool
+	// body of the backend response, only set by the backends with the no-op encoding. The consumer
+	// of the response is responsible for closing it when it implements the io.Closer interface
+	Io io.Reader
+	// metadata of the backend response, only set by the backends with the no-op encoding
+	Metadata Metadata
+}
+
+// Metadata contains the details of the backend response not included in its data
+type Metadata struct {
+	Headers    map[string][]string
+	StatusCode int
 }
 
 var (
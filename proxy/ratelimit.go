@@ -0,0 +1,29 @@
+package proxy
+
+import (
+	"context"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/ratelimit"
+)
+
+// NewRateLimitMiddleware returns a middleware failing with a *ratelimit.Error, before calling the
+// next proxy, the requests over the limits of the received config. The clients are identified by
+// the headers of the proxy requests, so the IP address is taken from the X-Forwarded-For header
+func NewRateLimitMiddleware(cfg *config.RateLimitConfig) Middleware {
+	limiter := ratelimit.NewLimiter(cfg)
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		if limiter == nil {
+			return next[0]
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			if err := limiter.Allow(limiter.ClientFromHeaders(request.Headers)); err != nil {
+				return nil, err
+			}
+			return next[0](ctx, request)
+		}
+	}
+}
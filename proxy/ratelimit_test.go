@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/ratelimit"
+)
+
+func TestNewRateLimitMiddleware(t *testing.T) {
+	calls := 0
+	p := NewRateLimitMiddleware(&config.RateLimitConfig{ClientMaxRate: 1, Key: "ip"})(func(_ context.Context, _ *Request) (*Response, error) {
+		calls++
+		return &Response{IsComplete: true}, nil
+	})
+
+	for i, tc := range []struct {
+		forwardedFor string
+		ok           bool
+	}{
+		{"10.0.0.1", true},
+		{"10.0.0.1", false},
+		{"10.0.0.2, 10.0.0.1", true},
+	} {
+		_, err := p(context.Background(), &Request{Headers: map[string][]string{"X-Forwarded-For": {tc.forwardedFor}}})
+		if (err == nil) != tc.ok {
+			t.Errorf("#%d: unexpected error %v", i, err)
+		}
+		if _, ok := err.(*ratelimit.Error); err != nil && !ok {
+			t.Errorf("#%d: unexpected error type %T", i, err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
+
+func TestNewRateLimitMiddleware_noConfig(t *testing.T) {
+	p := NewRateLimitMiddleware(nil)(func(_ context.Context, _ *Request) (*Response, error) {
+		return &Response{IsComplete: true}, nil
+	})
+	for i := 0; i < 10; i++ {
+		if _, err := p(context.Background(), &Request{}); err != nil {
+			t.Errorf("#%d: unexpected error %v", i, err)
+		}
+	}
+}
+
+func TestNewRateLimitMiddleware_multipleNext(t *testing.T) {
+	defer func() {
+		if r := recover(); r != ErrTooManyProxies {
+			t.Errorf("The code did not panic with ErrTooManyProxies: %v", r)
+		}
+	}()
+	NewRateLimitMiddleware(nil)(explosiveProxy(t), explosiveProxy(t))
+}
@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// NewTLSConfig returns the TLS settings defined by the received config, loading the CA bundles and
+// the client certificate from their files
+func NewTLSConfig(cfg *config.ClientTLSConfig) (*tls.Config, error) {
+	minVersion, err := config.ParseTLSVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		MinVersion:         minVersion,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if len(cfg.CACerts) > 0 {
+		pool := x509.NewCertPool()
+		for _, path := range cfg.CACerts {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(b) {
+				return nil, fmt.Errorf("no PEM encoded certificates found at %s", path)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// tlsReloadInterval is the min time between two checks of the TLS files
+var tlsReloadInterval = time.Second
+
+// NewReloadingTLSConfig returns the TLS settings defined by the received config, like NewTLSConfig,
+// loading the CA bundles and the client certificate again when their files change on disk. The
+// certificate of the servers is verified with the current CA bundles and the current client
+// certificate is presented on every handshake, so the clients keeping their connections, like the
+// grpc ones, get the rotated certificates on their next connection
+func NewReloadingTLSConfig(cfg *config.ClientTLSConfig) (*tls.Config, error) {
+	r := newTLSReloader(cfg)
+	current, _, err := r.current()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		ServerName: current.ServerName,
+		MinVersion: current.MinVersion,
+		// the certificates are verified by VerifyConnection, with the current CA bundles
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			current, _, err := r.current()
+			if err != nil {
+				return nil, err
+			}
+			if len(current.Certificates) == 0 {
+				return &tls.Certificate{}, nil
+			}
+			return &current.Certificates[0], nil
+		},
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			current, _, err := r.current()
+			if err != nil {
+				return err
+			}
+			if current.InsecureSkipVerify {
+				return nil
+			}
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("tls: no server certificate")
+			}
+			intermediates := x509.NewCertPool()
+			for _, c := range cs.PeerCertificates[1:] {
+				intermediates.AddCert(c)
+			}
+			_, err = cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+				Roots:         current.RootCAs,
+				DNSName:       cs.ServerName,
+				Intermediates: intermediates,
+			})
+			return err
+		},
+	}, nil
+}
+
+// tlsReloader keeps the TLS settings defined by a config, loading them again when the TLS files
+// change on disk. When the files can not be loaded, the previous settings are kept and the load is
+// retried after the reload interval
+type tlsReloader struct {
+	cfg     *config.ClientTLSConfig
+	files   []string
+	mu      sync.Mutex
+	checked time.Time
+	stamps  []fileStamp
+	tls     *tls.Config
+	err     error
+}
+
+func newTLSReloader(cfg *config.ClientTLSConfig) *tlsReloader {
+	files := append([]string{}, cfg.CACerts...)
+	if cfg.ClientCert != "" {
+		files = append(files, cfg.ClientCert, cfg.ClientKey)
+	}
+	return &tlsReloader{cfg: cfg, files: files}
+}
+
+// current returns the TLS settings loaded from the last version of the files, reporting whether
+// they were loaded again by the call
+func (r *tlsReloader) current() (*tls.Config, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.checked) < tlsReloadInterval {
+		return r.tls, false, r.err
+	}
+	r.checked = now
+	stamps := statFiles(r.files)
+	if r.tls != nil && equalStamps(stamps, r.stamps) {
+		return r.tls, false, nil
+	}
+
+	tlsConfig, err := NewTLSConfig(r.cfg)
+	if err != nil {
+		if r.tls == nil {
+			r.err = fmt.Errorf("loading the TLS settings: %w", err)
+		}
+		return r.tls, false, r.err
+	}
+	r.tls = tlsConfig
+	r.stamps = stamps
+	r.err = nil
+	return r.tls, true, nil
+}
+
+// reloadingTransport is a http.RoundTripper replacing its transport when the TLS files change on
+// disk. The connections opened by the previous transport are closed once they are idle
+type reloadingTransport struct {
+	cfg       *config.HTTPClientConfig
+	tls       *tlsReloader
+	mu        sync.Mutex
+	transport *http.Transport
+}
+
+func newReloadingTransport(cfg *config.HTTPClientConfig) *reloadingTransport {
+	r := &reloadingTransport{cfg: cfg, tls: newTLSReloader(cfg.TLS)}
+	r.current()
+	return r
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (r *reloadingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t, err := r.current()
+	if err != nil {
+		return nil, err
+	}
+	return t.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of the current transport
+func (r *reloadingTransport) CloseIdleConnections() {
+	if t, err := r.current(); err == nil {
+		t.CloseIdleConnections()
+	}
+}
+
+// current returns the transport built with the last version of the TLS files
+func (r *reloadingTransport) current() (*http.Transport, error) {
+	tlsConfig, changed, err := r.tls.current()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if !changed && r.transport != nil {
+		return r.transport, nil
+	}
+	previous := r.transport
+	r.transport = NewTransport(r.cfg)
+	r.transport.TLSClientConfig = tlsConfig
+	if previous != nil {
+		previous.CloseIdleConnections()
+	}
+	return r.transport, nil
+}
+
+// fileStamp identifies a version of a file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFiles(paths []string) []fileStamp {
+	stamps := make([]fileStamp, len(paths))
+	for i, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			stamps[i] = fileStamp{info.ModTime(), info.Size()}
+		}
+	}
+	return stamps
+}
+
+func equalStamps(a, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert creates a certificate signed by the parent one, or a self signed CA without parent
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{name},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert, key, der}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// write stores the certificate and its key at the received paths, setting a new modification time
+func (c *testCert) write(t *testing.T, certPath, keyPath string, modTime time.Time) {
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(certPath, modTime, modTime)
+	if keyPath == "" {
+		return
+	}
+	b, _ := x509.MarshalECPrivateKey(c.key)
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(keyPath, modTime, modTime)
+}
+
+func TestNewHTTPClientFactory_mutualTLS(t *testing.T) {
+	defer func(d time.Duration) { tlsReloadInterval = d }(tlsReloadInterval)
+	tlsReloadInterval = 0
+
+	ca := newTestCert(t, "ca", nil)
+	rotatedCA := newTestCert(t, "rotated-ca", nil)
+	serverCert := newTestCert(t, "backend.local", ca)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	clientCAs.AddCert(rotatedCA.cert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		Certificates: []tls.Certificate{serverCert.tlsCertificate()},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	caPath := filepath.Join(dir, "ca.pem")
+	certPath := filepath.Join(dir, "client.pem")
+	keyPath := filepath.Join(dir, "client.key")
+	ca.write(t, caPath, "", time.Now().Add(-time.Minute))
+	newTestCert(t, "client", ca).write(t, certPath, keyPath, time.Now().Add(-time.Minute))
+
+	cfg := &config.HTTPClientConfig{TLS: &config.ClientTLSConfig{
+		CACerts:    []string{caPath},
+		ClientCert: certPath,
+		ClientKey:  keyPath,
+		ServerName: "backend.local",
+		MinVersion: "1.2",
+	}}
+	client := NewHTTPClientFactory(cfg)(context.Background())
+	get := func() string {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Error(err)
+			return ""
+		}
+		defer resp.Body.Close()
+		b := make([]byte, 64)
+		n, _ := resp.Body.Read(b)
+		return string(b[:n])
+	}
+	if name := get(); name != "client" {
+		t.Errorf("unexpected client certificate: %s", name)
+	}
+
+	newTestCert(t, "rotated-client", rotatedCA).write(t, certPath, keyPath, time.Now())
+	if name := get(); name != "rotated-client" {
+		t.Errorf("the client certificate was not reloaded: %s", name)
+	}
+
+	// a broken file keeps the previous settings
+	os.WriteFile(caPath, []byte("broken"), 0600)
+	if name := get(); name != "rotated-client" {
+		t.Errorf("the previous settings were not kept: %s", name)
+	}
+
+	rotatedCA.write(t, caPath, "", time.Now().Add(time.Minute))
+	if _, err := client.Get(server.URL); err == nil {
+		t.Error("the server certificate should not be trusted by the rotated CA")
+	}
+}
+
+func TestNewReloadingTLSConfig(t *testing.T) {
+	defer func(d time.Duration) { tlsReloadInterval = d }(tlsReloadInterval)
+	tlsReloadInterval = 0
+
+	ca := newTestCert(t, "ca", nil)
+	rotatedCA := newTestCert(t, "rotated-ca", nil)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	clientCAs.AddCert(rotatedCA.cert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		Certificates: []tls.Certificate{newTestCert(t, "backend.local", ca).tlsCertificate()},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	caPath := filepath.Join(dir, "ca.pem")
+	certPath := filepath.Join(dir, "client.pem")
+	keyPath := filepath.Join(dir, "client.key")
+	ca.write(t, caPath, "", time.Now().Add(-time.Minute))
+	newTestCert(t, "client", ca).write(t, certPath, keyPath, time.Now().Add(-time.Minute))
+
+	tlsConfig, err := NewReloadingTLSConfig(&config.ClientTLSConfig{
+		CACerts:    []string{caPath},
+		ClientCert: certPath,
+		ClientKey:  keyPath,
+		ServerName: "backend.local",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every request opens a new connection, as the clients reconnecting
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, DisableKeepAlives: true}}
+	get := func() (string, error) {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b := make([]byte, 64)
+		n, _ := resp.Body.Read(b)
+		return string(b[:n]), nil
+	}
+	if name, err := get(); err != nil || name != "client" {
+		t.Errorf("unexpected client certificate: %s %v", name, err)
+	}
+
+	newTestCert(t, "rotated-client", rotatedCA).write(t, certPath, keyPath, time.Now())
+	if name, err := get(); err != nil || name != "rotated-client" {
+		t.Errorf("the client certificate was not reloaded: %s %v", name, err)
+	}
+
+	rotatedCA.write(t, caPath, "", time.Now().Add(time.Minute))
+	if _, err := get(); err == nil {
+		t.Error("the server certificate should not be trusted by the rotated CA")
+	}
+
+	if _, err := NewReloadingTLSConfig(&config.ClientTLSConfig{CACerts: []string{"/nowhere/ca.pem"}}); err == nil {
+		t.Error("error expected with a missing CA bundle")
+	}
+}
+
+func TestNewHTTPClientFactory_tlsKO(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	for _, tlsConfig := range []*config.ClientTLSConfig{
+		{CACerts: []string{"/nowhere/ca.pem"}},
+		{},
+	} {
+		client := NewHTTPClientFactory(&config.HTTPClientConfig{TLS: tlsConfig})(context.Background())
+		if _, err := client.Get(server.URL); err == nil {
+			t.Errorf("error expected with %+v", tlsConfig)
+		}
+	}
+
+	client := NewHTTPClientFactory(&config.HTTPClientConfig{TLS: &config.ClientTLSConfig{InsecureSkipVerify: true}})(context.Background())
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,219 @@
+// Package ratelimit provides the token buckets limiting the rate of the requests
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// TokenBucket is a token bucket refilled at a constant rate up to its capacity. Every allowed
+// request takes a token, so the capacity is the max number of requests in a burst
+type TokenBucket struct {
+	rate     float64
+	capacity float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewTokenBucket returns a full bucket refilled with rate tokens per second. The capacity is the
+// rate, rounded up, when it is not positive
+func NewTokenBucket(rate float64, capacity int) *TokenBucket {
+	c := float64(capacity)
+	if capacity <= 0 {
+		c = math.Max(1, math.Ceil(rate))
+	}
+	return &TokenBucket{rate: rate, capacity: c, tokens: c, last: time.Now(), now: time.Now}
+}
+
+// Allow takes a token from the bucket, if there is any. Otherwise, it returns the time to wait until
+// the next token is available
+func (b *TokenBucket) Allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.capacity, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// refund gives back a token taken from the bucket
+func (b *TokenBucket) refund() {
+	b.mu.Lock()
+	b.tokens = math.Min(b.capacity, b.tokens+1)
+	b.mu.Unlock()
+}
+
+// full reports whether the bucket would be full at the received time, so it can be discarded and
+// created again without changing the limits
+func (b *TokenBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.capacity
+}
+
+// Error is returned when a request exceeds a rate limit
+type Error struct {
+	// time to wait until the request would be allowed
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return "rate limit exceeded"
+}
+
+// RetryAfter returns the value of the Retry-After header of the responses to the requests rejected
+// with the received error, in whole seconds. It is empty when the error is not a rate limit one
+func RetryAfter(err error) string {
+	var e *Error
+	if !errors.As(err, &e) {
+		return ""
+	}
+	return strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// sweepInterval is the min time between the removals of the idle client buckets
+var sweepInterval = time.Minute
+
+// Limiter limits the rate of the requests with a bucket shared by all the clients and a bucket per
+// client, as defined by a rate limit config
+type Limiter struct {
+	global         *TokenBucket
+	clientRate     float64
+	clientCapacity int
+	source, header string
+
+	mu        sync.Mutex
+	clients   map[string]*TokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewLimiter returns the limiter defined by the received config, or nil when there is no config
+func NewLimiter(cfg *config.RateLimitConfig) *Limiter {
+	if cfg == nil {
+		return nil
+	}
+	l := &Limiter{
+		clientRate:     cfg.ClientMaxRate,
+		clientCapacity: cfg.ClientCapacity,
+		clients:        map[string]*TokenBucket{},
+		lastSweep:      time.Now(),
+		now:            time.Now,
+	}
+	l.source, l.header = cfg.KeySource()
+	if cfg.MaxRate > 0 {
+		l.global = NewTokenBucket(cfg.MaxRate, cfg.Capacity)
+	}
+	return l
+}
+
+// Allow takes a token from the bucket of the client and from the shared bucket, returning an *Error
+// when any of them is empty. The token of the client is given back when the shared bucket is empty,
+// so the clients are not throttled by the requests of the others. The requests without client are
+// only limited by the shared bucket
+func (l *Limiter) Allow(client string) error {
+	var b *TokenBucket
+	if l.clientRate > 0 && client != "" {
+		b = l.bucket(client)
+		if ok, wait := b.Allow(); !ok {
+			return &Error{RetryAfter: wait}
+		}
+	}
+	if l.global != nil {
+		if ok, wait := l.global.Allow(); !ok {
+			if b != nil {
+				b.refund()
+			}
+			return &Error{RetryAfter: wait}
+		}
+	}
+	return nil
+}
+
+// bucket returns the bucket of the client, removing the idle ones from time to time so the clients
+// seen once do not stay in memory
+func (l *Limiter) bucket(client string) *TokenBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		for k, b := range l.clients {
+			if b.full(now) {
+				delete(l.clients, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	b, ok := l.clients[client]
+	if !ok {
+		b = NewTokenBucket(l.clientRate, l.clientCapacity)
+		b.now = l.now
+		b.last = now
+		l.clients[client] = b
+	}
+	return b
+}
+
+// Client returns the identity of the client sending the request: its IP address or the value of
+// the header defined by the config
+func (l *Limiter) Client(r *http.Request) string {
+	if l.source == config.RateLimitKeyHeader {
+		return r.Header.Get(l.header)
+	}
+	return host(r.RemoteAddr)
+}
+
+// ClientFromHeaders returns the identity of the client sending a request with the received headers,
+// taking the IP address from the X-Forwarded-For header
+func (l *Limiter) ClientFromHeaders(headers map[string][]string) string {
+	if l.source == config.RateLimitKeyHeader {
+		if v := headers[l.header]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	v := headers["X-Forwarded-For"]
+	if len(v) == 0 {
+		return ""
+	}
+	ip, _, _ := strings.Cut(v[0], ",")
+	return host(strings.TrimSpace(ip))
+}
+
+// Handler returns a handler rejecting the requests over the limits with a 429 status code and a
+// Retry-After header before passing them to the next handler
+func (l *Limiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := l.Allow(l.Client(r)); err != nil {
+			w.Header().Set("Retry-After", RetryAfter(err))
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// host returns the host of the address, or the address itself when it has no port
+func host(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return addr
+}
@@ -0,0 +1,156 @@
+package ratelimit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := NewTokenBucket(2, 3)
+	b.last = now
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.Allow(); !ok {
+			t.Fatalf("#%d: the burst should be allowed", i)
+		}
+	}
+	if ok, wait := b.Allow(); ok || wait != 500*time.Millisecond {
+		t.Errorf("unexpected result: %v %s", ok, wait)
+	}
+
+	now = now.Add(250 * time.Millisecond)
+	if ok, wait := b.Allow(); ok || wait != 250*time.Millisecond {
+		t.Errorf("unexpected result: %v %s", ok, wait)
+	}
+	now = now.Add(250 * time.Millisecond)
+	if ok, _ := b.Allow(); !ok {
+		t.Error("the refilled token should be allowed")
+	}
+
+	// the bucket is never refilled over its capacity
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		b.Allow()
+	}
+	if ok, _ := b.Allow(); ok {
+		t.Error("the requests over the capacity should not be allowed")
+	}
+}
+
+func TestNewTokenBucket_defaultCapacity(t *testing.T) {
+	for rate, capacity := range map[float64]float64{0.5: 1, 2: 2, 2.5: 3} {
+		if b := NewTokenBucket(rate, 0); b.capacity != capacity {
+			t.Errorf("rate %v: want capacity %v, have %v", rate, capacity, b.capacity)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := NewLimiter(&config.RateLimitConfig{MaxRate: 1, Capacity: 3, ClientMaxRate: 1, ClientCapacity: 2, Key: "ip"})
+	l.global.now = func() time.Time { return now }
+	l.global.last = now
+	l.now = func() time.Time { return now }
+	l.lastSweep = now
+
+	for i, tc := range []struct {
+		client string
+		ok     bool
+	}{
+		{"supu", true},
+		{"supu", true},
+		{"supu", false},
+		{"tupu", true},
+		{"", false},
+	} {
+		err := l.Allow(tc.client)
+		if (err == nil) != tc.ok {
+			t.Errorf("#%d: unexpected result %v", i, err)
+		}
+		if err != nil && RetryAfter(err) != "1" {
+			t.Errorf("#%d: unexpected retry after %s", i, RetryAfter(err))
+		}
+	}
+
+	// the idle clients are removed
+	now = now.Add(time.Hour)
+	l.Allow("tupu")
+	if _, ok := l.clients["supu"]; ok || len(l.clients) != 1 {
+		t.Errorf("unexpected clients: %v", l.clients)
+	}
+
+	if NewLimiter(nil) != nil {
+		t.Error("unexpected limiter without config")
+	}
+	if RetryAfter(errors.New("supu")) != "" {
+		t.Error("unexpected retry after for other errors")
+	}
+}
+
+func TestLimiter_sharedBucketEmpty(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := NewLimiter(&config.RateLimitConfig{MaxRate: 1, Capacity: 1, ClientMaxRate: 0.1, ClientCapacity: 1, Key: "ip"})
+	l.global.now = func() time.Time { return now }
+	l.global.last = now
+	l.now = func() time.Time { return now }
+	l.lastSweep = now
+
+	if err := l.Allow("supu"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Allow("tupu"); err == nil {
+		t.Fatal("the shared bucket should be empty")
+	}
+	// the client keeps the token not used while the shared bucket was empty
+	now = now.Add(time.Second)
+	if err := l.Allow("tupu"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLimiter_clients(t *testing.T) {
+	byIP := NewLimiter(&config.RateLimitConfig{ClientMaxRate: 1, Key: "ip"})
+	byHeader := NewLimiter(&config.RateLimitConfig{ClientMaxRate: 1, Key: "header:X-Client-Id"})
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Client-Id", "supu")
+	if c := byIP.Client(r); c != "10.0.0.1" {
+		t.Errorf("unexpected client: %s", c)
+	}
+	if c := byHeader.Client(r); c != "supu" {
+		t.Errorf("unexpected client: %s", c)
+	}
+
+	headers := map[string][]string{"X-Forwarded-For": {"10.0.0.2:4321, 10.0.0.3"}, "X-Client-Id": {"tupu"}}
+	if c := byIP.ClientFromHeaders(headers); c != "10.0.0.2" {
+		t.Errorf("unexpected client: %s", c)
+	}
+	if c := byHeader.ClientFromHeaders(headers); c != "tupu" {
+		t.Errorf("unexpected client: %s", c)
+	}
+	if c := byHeader.ClientFromHeaders(map[string][]string{}); c != "" {
+		t.Errorf("unexpected client: %s", c)
+	}
+}
+
+func TestLimiter_Handler(t *testing.T) {
+	handler := NewLimiter(&config.RateLimitConfig{ClientMaxRate: 0.5, Key: "ip"}).Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	for i, status := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != status {
+			t.Errorf("#%d: want %d, have %d", i, status, w.Code)
+		}
+		if status == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "2" {
+			t.Errorf("unexpected headers: %v", w.Header())
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package gin
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/ph0m1/porta/apikey"
+	"github.com/ph0m1/porta/config"
+)
+
+// apiKeyHandler authorizes the API key of the requests to the endpoint before passing them to the
+// received handler, with the key in the context of the request
+func apiKeyHandler(store *apikey.Store, endpoint *config.EndpointConfig, next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, wait, err := store.Authorize(c.Request, endpoint)
+		if err != nil {
+			if wait > 0 {
+				c.Header("Retry-After", apikey.RetryAfter(wait))
+			}
+			c.AbortWithError(apikey.Status(err), err)
+			return
+		}
+		c.Request = c.Request.WithContext(apikey.NewContext(c.Request.Context(), key))
+		next(c)
+	}
+}
@@ -0,0 +1,34 @@
+package gin
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ph0m1/porta/cors"
+)
+
+// corsHandler adds the CORS headers of the policy to the responses of the received handler
+func corsHandler(policy *cors.Policy, next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policy.SetHeaders(c.Writer.Header(), c.Request)
+		next(c)
+	}
+}
+
+// preflightHandler answers the preflight requests with the policies of the endpoints sharing the
+// path, delegating the rest of OPTIONS requests to the OPTIONS endpoint, if any
+func preflightHandler(policies cors.Policies, options gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cors.IsPreflight(c.Request) {
+			policies.Preflight(c.Writer, c.Request)
+			c.Abort()
+			return
+		}
+		if options == nil {
+			c.AbortWithStatus(http.StatusMethodNotAllowed)
+			return
+		}
+		options(c)
+	}
+}
@@ -3,6 +3,7 @@ package gin
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/ph0m1/porta/logging"
+	"github.com/ph0m1/porta/proxy"
 	"io/ioutil"
 )
 
@@ -16,8 +17,12 @@ func DebugHandler(logger logging.Logger) gin.HandlerFunc {
 		body, _ := ioutil.ReadAll(c.Request.Body)
 		c.Request.Body.Close()
 		logger.Debug("Body:", string(body))
-		c.JSON(200, gin.H{
+		response := gin.H{
 			"message": "pong",
-		})
+		}
+		if tier := c.GetHeader(proxy.TierHeader); tier != "" {
+			response["tier"] = tier
+		}
+		c.JSON(200, response)
 	}
 }
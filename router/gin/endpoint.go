@@ -1,7 +1,7 @@
 package gin
 
 import (
-	"context"
+	"bytes"
 	"errors"
 	"fmt"
 	"net/http"
@@ -11,59 +11,109 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/encoding"
 	"github.com/ph0m1/porta/proxy"
+	"github.com/ph0m1/porta/ratelimit"
+	"github.com/ph0m1/porta/router"
 )
 
 var ErrInternalError = errors.New("internal server error")
 
 type HandlerFactory func(endpointConfig *config.EndpointConfig, proxy2 proxy.Proxy) gin.HandlerFunc
 
-func EndpointHandler(cfg *config.EndpointConfig, proxy proxy.Proxy) gin.HandlerFunc {
+func EndpointHandler(cfg *config.EndpointConfig, p proxy.Proxy) gin.HandlerFunc {
 	endpointTimeout := time.Duration(cfg.Timeout) * time.Millisecond
 
 	return func(c *gin.Context) {
-		requestCtx, cancel := context.WithTimeout(c, endpointTimeout)
+		requestCtx, stopTimeout, cancel := router.NewEndpointContext(c.Request.Context(), endpointTimeout)
 
 		c.Header("X_X", "Version undefined")
 
-		response, err := proxy(requestCtx, NewRequest(c, cfg.QueryString))
+		request := NewRequest(c, cfg.QueryString, cfg.HeadersToPass)
+		var body *proxy.LimitedReadCloser
+		if cfg.MaxRequestSize > 0 {
+			if c.Request.ContentLength > cfg.MaxRequestSize {
+				c.AbortWithError(http.StatusRequestEntityTooLarge, proxy.ErrRequestTooLarge)
+				cancel()
+				return
+			}
+			body = proxy.NewLimitedReadCloser(c.Request.Body, cfg.MaxRequestSize, proxy.ErrRequestTooLarge)
+			request.Body = body
+		}
+
+		response, err := p(requestCtx, request)
+		timedOut := !stopTimeout()
 		if err != nil {
-			c.AbortWithError(http.StatusInternalServerError, err)
+			if retryAfter := ratelimit.RetryAfter(err); retryAfter != "" {
+				c.Header("Retry-After", retryAfter)
+			}
+			c.AbortWithError(router.ErrorStatus(err, body), err)
 			cancel()
 			return
 		}
 
-		select {
-		case <-requestCtx.Done():
+		if timedOut || requestCtx.Err() != nil {
+			router.CloseBody(response)
 			c.AbortWithError(http.StatusInternalServerError, ErrInternalError)
 			cancel()
-		default:
+			return
 		}
 
 		if cfg.CacheTTL.Seconds() != 0 && response != nil && response.IsComplete {
 			c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(cfg.CacheTTL.Seconds())))
 		}
-		if response != nil {
-			c.JSON(http.StatusOK, response.Data)
-		} else {
-			c.JSON(http.StatusOK, gin.H{})
-		}
+		render(c, cfg, response)
 		cancel()
 	}
 }
 
+// render writes the response with the output encoding of the endpoint
+func render(c *gin.Context, cfg *config.EndpointConfig, response *proxy.Response) {
+	outputEncoding := cfg.OutputEncoding
+	if outputEncoding == encoding.NOOP {
+		router.Stream(c.Writer, response)
+		return
+	}
+
+	name := outputEncoding
+	if name == encoding.NEGOTIATE {
+		c.Header("Vary", "Accept")
+		var ok bool
+		if name, ok = encoding.Negotiate(c.GetHeader("Accept")); !ok {
+			c.AbortWithStatus(http.StatusNotAcceptable)
+			return
+		}
+	}
+	encoder, contentType, err := encoding.GetEncoder(name)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	data := map[string]interface{}{}
+	if response != nil && response.Data != nil {
+		data = response.Data
+	}
+	buf := new(bytes.Buffer)
+	if err := encoder(buf, data); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Data(http.StatusOK, contentType, router.Compress(c.Writer.Header(), c.GetHeader("Accept-Encoding"), cfg.Compression, buf.Bytes()))
+}
+
 var (
 	headersToSend        = []string{"Content-Type"}
 	userAgentHeaderValue = []string{"X_X Version undefined"}
 )
 
-func NewRequest(c *gin.Context, queryString []string) *proxy.Request {
+func NewRequest(c *gin.Context, queryString, headersToPass []string) *proxy.Request {
 	params := make(map[string]string, len(c.Params))
 	for _, param := range c.Params {
 		params[strings.Title(param.Key)] = param.Value
 	}
 
-	headers := make(map[string][]string, 2+len(headersToSend))
+	headers := make(map[string][]string, 2+len(headersToSend)+len(headersToPass))
 	headers["X-Forwarded-For"] = []string{c.ClientIP()}
 	headers["User-Agent"] = userAgentHeaderValue
 	for _, k := range headersToSend {
@@ -71,6 +121,11 @@ func NewRequest(c *gin.Context, queryString []string) *proxy.Request {
 			headers[k] = h
 		}
 	}
+	for _, k := range headersToPass {
+		if h, ok := c.Request.Header[k]; ok {
+			headers[k] = h
+		}
+	}
 
 	query := make(map[string][]string, len(queryString))
 	for i := range queryString {
@@ -0,0 +1,22 @@
+package gin
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/ph0m1/porta/jwt"
+)
+
+// jwtHandler validates the token of the requests before passing them to the received handler, with
+// the claims of the token in the context of the request
+func jwtHandler(validator *jwt.Validator, next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := validator.ValidateRequest(c.Request)
+		if err != nil {
+			c.Header("WWW-Authenticate", jwt.Challenge(err))
+			c.AbortWithError(jwt.Status(err), err)
+			return
+		}
+		c.Request = c.Request.WithContext(jwt.NewContext(c.Request.Context(), claims))
+		next(c)
+	}
+}
@@ -0,0 +1,22 @@
+package gin
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ph0m1/porta/ratelimit"
+)
+
+// rateLimitHandler rejects the requests over the limits of the limiter with a 429 status code and a
+// Retry-After header before passing them to the received handler
+func rateLimitHandler(limiter *ratelimit.Limiter, next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := limiter.Allow(limiter.Client(c.Request)); err != nil {
+			c.Header("Retry-After", ratelimit.RetryAfter(err))
+			c.AbortWithError(http.StatusTooManyRequests, err)
+			return
+		}
+		next(c)
+	}
+}
@@ -1,13 +1,15 @@
 package gin
 
 import (
-	"fmt"
-
 	"github.com/gin-gonic/gin"
 
+	"github.com/ph0m1/porta/apikey"
 	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/cors"
+	"github.com/ph0m1/porta/jwt"
 	"github.com/ph0m1/porta/logging"
 	"github.com/ph0m1/porta/proxy"
+	"github.com/ph0m1/porta/ratelimit"
 	"github.com/ph0m1/porta/router"
 )
 
@@ -66,7 +68,7 @@ func (r ginRouter) Run(cfg config.ServiceConfig) {
 	}
 	r.registerEndpoints(cfg.Endpoints)
 
-	r.cfg.Logger.Critical(r.cfg.Engine.Run(fmt.Sprintf(":%d", cfg.Port)))
+	r.cfg.Logger.Critical(router.ListenAndServe(cfg, r.cfg.Engine))
 }
 
 func (r ginRouter) registerDebugEndpoints() {
@@ -76,34 +78,86 @@ func (r ginRouter) registerDebugEndpoints() {
 	r.cfg.Engine.PUT("/__debug/*param", handler)
 }
 func (r ginRouter) registerEndpoints(endpoints []*config.EndpointConfig) {
+	// the GET endpoints also handle the HEAD requests to their path, unless there is a HEAD endpoint.
+	// The OPTIONS requests to the paths with a CORS policy are registered once all the endpoints are
+	// known, so the preflight requests are answered before reaching the OPTIONS endpoints
+	heads := map[string]bool{}
+	corsPaths := []string{}
+	policies := map[string]cors.Policies{}
+	for _, c := range endpoints {
+		if c.Method == config.HEAD {
+			heads[c.Endpoint] = true
+		}
+		if _, ok := policies[c.Endpoint]; c.CORS != nil && !ok {
+			corsPaths = append(corsPaths, c.Endpoint)
+			policies[c.Endpoint] = cors.Policies{}
+		}
+	}
+	options := map[string]gin.HandlerFunc{}
+	stores := apikey.Stores{}
 	for _, c := range endpoints {
 		proxyStack, err := r.cfg.ProxyFactory.New(c)
 		if err != nil {
 			r.cfg.Logger.Error("calling the ProxyFactory", err.Error())
 			continue
 		}
-		r.registerEndpoint(c.Method, c.Endpoint, r.cfg.HandlerFactory(c, proxyStack), len(c.Backend))
+		if !r.validEndpoint(c.Method, c.Endpoint, len(c.Backend)) {
+			continue
+		}
+		validator, err := jwt.New(c.JWT)
+		if err != nil {
+			r.cfg.Logger.Error("loading the JWT keys", err.Error())
+			continue
+		}
+		if validator != nil {
+			proxyStack = jwt.NewMiddleware(c.JWT)(proxyStack)
+		}
+		store, err := stores.Get(c.APIKeys)
+		if err != nil {
+			r.cfg.Logger.Error("loading the API keys", err.Error())
+			continue
+		}
+		if store != nil {
+			proxyStack = apikey.NewMiddleware(c.APIKeys)(proxyStack)
+		}
+		handler := r.cfg.HandlerFactory(c, proxyStack)
+		if validator != nil {
+			handler = jwtHandler(validator, handler)
+		}
+		if store != nil {
+			handler = apiKeyHandler(store, c, handler)
+		}
+		if limiter := ratelimit.NewLimiter(c.RateLimit); limiter != nil {
+			handler = rateLimitHandler(limiter, handler)
+		}
+		if policy := cors.New(c.CORS); policy != nil {
+			handler = corsHandler(policy, handler)
+			policies[c.Endpoint][c.Method] = policy
+		}
+		if _, ok := policies[c.Endpoint]; ok && c.Method == config.OPTIONS {
+			options[c.Endpoint] = handler
+			continue
+		}
+		r.cfg.Engine.Handle(c.Method, c.Endpoint, handler)
+		if c.Method == config.GET && !heads[c.Endpoint] {
+			r.cfg.Engine.HEAD(c.Endpoint, handler)
+		}
+	}
+	for _, path := range corsPaths {
+		r.cfg.Engine.OPTIONS(path, preflightHandler(policies[path], options[path]))
 	}
 }
 
-func (r ginRouter) registerEndpoint(method, path string, handler gin.HandlerFunc, toBackends int) {
+func (r ginRouter) validEndpoint(method, path string, toBackends int) bool {
 	if method != "GET" && toBackends > 1 {
 		r.cfg.Logger.Error(method, "endpoints must have a single backend! Ignoring", path)
-		return
+		return false
 	}
 	switch method {
-	case "GET":
-		r.cfg.Engine.GET(path, handler)
-	case "POST":
-		r.cfg.Engine.POST(path, handler)
-	case "PUT":
-		r.cfg.Engine.PUT(path, handler)
-	case "PATCH":
-		r.cfg.Engine.PATCH(path, handler)
-	case "DELETE":
-		r.cfg.Engine.DELETE(path, handler)
-
+	case config.GET, config.HEAD, config.POST, config.PUT, config.PATCH, config.DELETE, config.OPTIONS, config.CONNECT, config.TRACE:
+		return true
 	default:
 		r.cfg.Logger.Error("Unsupported method", method)
+		return false
 	}
 }
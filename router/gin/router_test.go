@@ -0,0 +1,296 @@
+package gin
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/logging/gologging"
+	"github.com/ph0m1/porta/proxy"
+)
+
+// paramsProxyFactory creates proxies returning the method of the endpoint and the request params
+type paramsProxyFactory struct{}
+
+func (paramsProxyFactory) New(cfg *config.EndpointConfig) (proxy.Proxy, error) {
+	return func(_ context.Context, r *proxy.Request) (*proxy.Response, error) {
+		return &proxy.Response{Data: map[string]interface{}{"method": cfg.Method, "params": fmt.Sprint(r.Params)}, IsComplete: true}, nil
+	}, nil
+}
+
+// newTestServer starts a server with the endpoints registered by a gin router with the proxies of
+// the received factory
+func newTestServer(t *testing.T, pf proxy.Factory, endpoints ...*config.EndpointConfig) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	logger, _ := gologging.NewLogger("ERROR", io.Discard, "")
+	r := NewFactory(Config{
+		Engine:         gin.New(),
+		HandlerFactory: EndpointHandler,
+		ProxyFactory:   pf,
+		Logger:         logger,
+	}).New().(ginRouter)
+	r.registerEndpoints(endpoints)
+	server := httptest.NewServer(r.cfg.Engine)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRouter_cors(t *testing.T) {
+	policy := &config.CORSConfig{AllowOrigins: []string{"https://*.supu.com"}, AllowHeaders: []string{"Authorization"}}
+	server := newTestServer(t, paramsProxyFactory{},
+		&config.EndpointConfig{Endpoint: "/users", Method: "GET", Timeout: time.Second, OutputEncoding: "json", Backend: []*config.Backend{{}}, CORS: policy},
+		&config.EndpointConfig{Endpoint: "/users", Method: "POST", Timeout: time.Second, OutputEncoding: "json", Backend: []*config.Backend{{}}},
+	)
+
+	do := func(method, origin, requestedMethod string) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+"/users", nil)
+		req.Header.Set("Origin", origin)
+		if requestedMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", requestedMethod)
+			req.Header.Set("Access-Control-Request-Headers", "Authorization")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := do("OPTIONS", "https://app.supu.com", "GET")
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Allow-Origin") != "https://app.supu.com" ||
+		resp.Header.Get("Access-Control-Allow-Methods") != "GET" || resp.Header.Get("Access-Control-Allow-Headers") != "Authorization" {
+		t.Errorf("unexpected preflight response: %d %v", resp.StatusCode, resp.Header)
+	}
+	if resp = do("OPTIONS", "https://app.supu.com", "POST"); resp.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("the POST endpoint has no CORS policy: %v", resp.Header)
+	}
+	if resp = do("OPTIONS", "https://tupu.com", "GET"); resp.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("the origin is not allowed: %v", resp.Header)
+	}
+	if resp = do("GET", "https://app.supu.com", ""); resp.StatusCode != http.StatusOK || resp.Header.Get("Access-Control-Allow-Origin") != "https://app.supu.com" {
+		t.Errorf("unexpected response: %d %v", resp.StatusCode, resp.Header)
+	}
+}
+
+func TestRouter_jwt(t *testing.T) {
+	server := newTestServer(t, paramsProxyFactory{}, &config.EndpointConfig{
+		Endpoint:       "/users/:user",
+		Method:         "GET",
+		Timeout:        time.Second,
+		OutputEncoding: "json",
+		Backend:        []*config.Backend{{}},
+		CORS:           &config.CORSConfig{AllowOrigins: []string{"*"}},
+		JWT: &config.JWTConfig{
+			Keys:           []config.JWTKeyConfig{{Secret: "supu"}},
+			ClaimsToParams: map[string]string{"sub": "sub"},
+		},
+	})
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	signed := b64([]byte(`{"alg":"HS256"}`)) + "." + b64([]byte(`{"sub":"42"}`))
+	mac := hmac.New(sha256.New, []byte("supu"))
+	mac.Write([]byte(signed))
+	token := signed + "." + b64(mac.Sum(nil))
+
+	for _, tc := range []struct {
+		authorization string
+		status        int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer " + signed + ".c3VwdQ", http.StatusUnauthorized},
+		{"Bearer " + token, http.StatusOK},
+	} {
+		req, _ := http.NewRequest("GET", server.URL+"/users/7", nil)
+		req.Header.Set("Origin", "https://supu.com")
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("unexpected response: %d %s", resp.StatusCode, body)
+		}
+		if tc.status == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("the rejected response should have a challenge: %v", resp.Header)
+		}
+		if resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+			t.Errorf("the response should have the CORS headers: %v", resp.Header)
+		}
+	}
+}
+
+func TestRouter_apiKeys(t *testing.T) {
+	keys := &config.APIKeysConfig{
+		Keys:   []config.APIKeyConfig{{Key: "supu", Client: "supu", Endpoints: []string{"/users"}, RateLimit: 1}},
+		Header: "X-Api-Key",
+	}
+	endpoint := func(method, path string) *config.EndpointConfig {
+		return &config.EndpointConfig{Endpoint: path, Method: method, Timeout: time.Second, OutputEncoding: "json", Backend: []*config.Backend{{}}, APIKeys: keys}
+	}
+	server := newTestServer(t, paramsProxyFactory{}, endpoint("GET", "/users"), endpoint("POST", "/users"), endpoint("GET", "/admin"))
+
+	for _, tc := range []struct {
+		method, path, key string
+		status            int
+	}{
+		{"GET", "/users", "", http.StatusUnauthorized},
+		{"GET", "/users", "tupu", http.StatusUnauthorized},
+		{"GET", "/admin", "supu", http.StatusForbidden},
+		{"GET", "/users", "supu", http.StatusOK},
+		// the quota is shared by all the endpoints
+		{"POST", "/users", "supu", http.StatusTooManyRequests},
+	} {
+		req, _ := http.NewRequest(tc.method, server.URL+tc.path, nil)
+		req.Header.Set("X-Api-Key", tc.key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s %s: want %d, have %d", tc.method, tc.path, tc.key, tc.status, resp.StatusCode)
+		}
+	}
+}
+
+// rateLimitedProxyFactory creates the proxies of the paramsProxyFactory limited by the rate limit
+// of the first backend
+type rateLimitedProxyFactory struct{}
+
+func (rateLimitedProxyFactory) New(cfg *config.EndpointConfig) (proxy.Proxy, error) {
+	p, _ := paramsProxyFactory{}.New(cfg)
+	return proxy.NewRateLimitMiddleware(cfg.Backend[0].RateLimit)(p), nil
+}
+
+func TestRouter_rateLimit(t *testing.T) {
+	server := newTestServer(t, rateLimitedProxyFactory{},
+		&config.EndpointConfig{
+			Endpoint:       "/endpoint",
+			Method:         "GET",
+			Timeout:        time.Second,
+			OutputEncoding: "json",
+			Backend:        []*config.Backend{{}},
+			RateLimit:      &config.RateLimitConfig{ClientMaxRate: 1, Key: "header:X-Client-Id"},
+		},
+		&config.EndpointConfig{
+			Endpoint:       "/backend",
+			Method:         "GET",
+			Timeout:        time.Second,
+			OutputEncoding: "json",
+			Backend:        []*config.Backend{{RateLimit: &config.RateLimitConfig{MaxRate: 0.5}}},
+		},
+	)
+
+	for _, tc := range []struct {
+		path, client string
+		status       int
+		retryAfter   string
+	}{
+		{"/endpoint", "supu", http.StatusOK, ""},
+		{"/endpoint", "supu", http.StatusTooManyRequests, "1"},
+		{"/endpoint", "tupu", http.StatusOK, ""},
+		{"/backend", "supu", http.StatusOK, ""},
+		{"/backend", "tupu", http.StatusTooManyRequests, "2"},
+	} {
+		req, _ := http.NewRequest("GET", server.URL+tc.path, nil)
+		req.Header.Set("X-Client-Id", tc.client)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status || resp.Header.Get("Retry-After") != tc.retryAfter {
+			t.Errorf("%s %s: unexpected response %d %v", tc.path, tc.client, resp.StatusCode, resp.Header)
+		}
+	}
+}
+
+// slowBodyProxy returns a no-op response with a body written in chunks after the delay, failing
+// when the context is done as the bodies of the backend responses
+func slowBodyProxy(delay time.Duration, chunks ...string) proxy.Proxy {
+	return func(ctx context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			for _, chunk := range chunks {
+				select {
+				case <-ctx.Done():
+					pw.CloseWithError(ctx.Err())
+					return
+				case <-time.After(delay):
+				}
+				pw.Write([]byte(chunk))
+			}
+			pw.Close()
+		}()
+		return &proxy.Response{
+			IsComplete: true,
+			Io:         pr,
+			Metadata:   proxy.Metadata{StatusCode: http.StatusAccepted, Headers: map[string][]string{"X-Supu": {"tupu"}, "Connection": {"close"}}},
+		}, nil
+	}
+}
+
+// noopProxyFactory creates proxies streaming a slow no-op response
+type noopProxyFactory struct{}
+
+func (noopProxyFactory) New(_ *config.EndpointConfig) (proxy.Proxy, error) {
+	return slowBodyProxy(30*time.Millisecond, "supu", "tupu", "foo"), nil
+}
+
+func TestRouter_noop(t *testing.T) {
+	// the timeout of the endpoints is in milliseconds, so the body is streamed after it
+	server := newTestServer(t, noopProxyFactory{}, &config.EndpointConfig{
+		Endpoint:       "/supu",
+		Method:         "GET",
+		Timeout:        50,
+		OutputEncoding: "no-op",
+		Backend:        []*config.Backend{{}},
+	})
+
+	resp, err := http.Get(server.URL + "/supu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || string(body) != "suputupufoo" {
+		t.Errorf("unexpected response: %d %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get("X-Supu") != "tupu" {
+		t.Errorf("the backend headers were not copied: %v", resp.Header)
+	}
+	if resp.Close {
+		t.Error("the hop-by-hop headers of the backend should not be copied")
+	}
+}
+
+func TestEndpointHandler_noopTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/supu", EndpointHandler(&config.EndpointConfig{Endpoint: "/supu", Method: "GET", Timeout: 50, OutputEncoding: "no-op"}, func(ctx context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+
+	// the backends must respond before the timeout
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest("GET", "/supu", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+}
@@ -23,7 +23,7 @@ func DefaultConfig(pf proxy.Factory, logger logging.Logger) mux.Config {
 		Engine:         gorillaEngine{gorilla.NewRouter()},
 		ProxyFactory:   pf,
 		Logger:         logger,
-		HandlerFactory: mux.EndpointHandler,
+		HandlerFactory: mux.CustomEndpointHandler(mux.NewRequestBuilder(gorillaParamsExtractor)),
 	}
 }
 
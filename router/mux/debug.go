@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/ph0m1/porta/logging"
+	"github.com/ph0m1/porta/proxy"
 )
 
 func DebugHandler(logger logging.Logger) http.HandlerFunc {
@@ -18,7 +19,11 @@ func DebugHandler(logger logging.Logger) http.HandlerFunc {
 		r.Body.Close()
 		logger.Debug("Body:", string(body))
 
-		js, err := json.Marshal(map[string]string{"message": "pong"})
+		response := map[string]string{"message": "pong"}
+		if tier := r.Header.Get(proxy.TierHeader); tier != "" {
+			response["tier"] = tier
+		}
+		js, err := json.Marshal(response)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -1,15 +1,19 @@
 package mux
 
 import (
-	"context"
-	"encoding/json"
+	"bytes"
 	"errors"
 	"fmt"
 	"net/http"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/encoding"
 	"github.com/ph0m1/porta/proxy"
+	"github.com/ph0m1/porta/ratelimit"
+	"github.com/ph0m1/porta/router"
 )
 
 var ErrInternalError = errors.New("internal server error")
@@ -21,62 +25,114 @@ type HandlerFactory func(*config.EndpointConfig, proxy.Proxy) http.HandlerFunc
 var EndpointHandler = CustomEndpointHandler(NewRequest)
 
 func CustomEndpointHandler(rb RequestBuilder) HandlerFactory {
-	return func(configuration *config.EndpointConfig, proxy proxy.Proxy) http.HandlerFunc {
+	return func(configuration *config.EndpointConfig, p proxy.Proxy) http.HandlerFunc {
 		endpointTimeout := time.Duration(configuration.Timeout) * time.Millisecond
 
 		return func(w http.ResponseWriter, r *http.Request) {
-			if r.Method != configuration.Method {
+			if r.Method != configuration.Method && !(r.Method == http.MethodHead && configuration.Method == config.GET) {
 				http.Error(w, "", http.StatusMethodNotAllowed)
 				return
 			}
-			requestCtx, cancel := context.WithTimeout(context.Background(), endpointTimeout)
+			requestCtx, stopTimeout, cancel := router.NewEndpointContext(r.Context(), endpointTimeout)
 
 			w.Header().Set("X_X", "Version undefined")
 
-			response, err := proxy(requestCtx, rb(r, configuration.QueryString))
+			var body *proxy.LimitedReadCloser
+			if configuration.MaxRequestSize > 0 {
+				if r.ContentLength > configuration.MaxRequestSize {
+					http.Error(w, proxy.ErrRequestTooLarge.Error(), http.StatusRequestEntityTooLarge)
+					cancel()
+					return
+				}
+				body = proxy.NewLimitedReadCloser(r.Body, configuration.MaxRequestSize, proxy.ErrRequestTooLarge)
+				r.Body = body
+			}
+
+			response, err := p(requestCtx, rb(r, configuration.QueryString, configuration.HeadersToPass))
+			timedOut := !stopTimeout()
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				if retryAfter := ratelimit.RetryAfter(err); retryAfter != "" {
+					w.Header().Set("Retry-After", retryAfter)
+				}
+				http.Error(w, err.Error(), router.ErrorStatus(err, body))
 				cancel()
 				return
 			}
-			select {
-			case <-requestCtx.Done():
+			if timedOut || requestCtx.Err() != nil {
+				router.CloseBody(response)
 				http.Error(w, ErrInternalError.Error(), http.StatusInternalServerError)
 				cancel()
 				return
-			default:
 			}
 
-			var js []byte
-			if response != nil {
-				js, err = json.Marshal(response.Data)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					cancel()
-					return
-				}
-				if configuration.CacheTTL.Seconds() != 0 && response.IsComplete {
-					w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(configuration.CacheTTL.Seconds())))
-				}
+			if response != nil && configuration.CacheTTL.Seconds() != 0 && response.IsComplete {
+				w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(configuration.CacheTTL.Seconds())))
 			}
-			w.Header().Set("Content-Type", "application/json")
-			w.Write(js)
+			render(w, r, configuration, response)
 			cancel()
 		}
 	}
 }
 
-// RequestBuilder creates a proxy.Request from the received http.Request
-type RequestBuilder func(*http.Request, []string) *proxy.Request
+// render writes the response with the output encoding of the endpoint
+func render(w http.ResponseWriter, r *http.Request, cfg *config.EndpointConfig, response *proxy.Response) {
+	outputEncoding := cfg.OutputEncoding
+	if outputEncoding == encoding.NOOP {
+		router.Stream(w, response)
+		return
+	}
+
+	name := outputEncoding
+	if name == encoding.NEGOTIATE {
+		w.Header().Set("Vary", "Accept")
+		var ok bool
+		if name, ok = encoding.Negotiate(r.Header.Get("Accept")); !ok {
+			http.Error(w, "", http.StatusNotAcceptable)
+			return
+		}
+	}
+	encoder, contentType, err := encoding.GetEncoder(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{}
+	if response != nil && response.Data != nil {
+		data = response.Data
+	}
+	buf := new(bytes.Buffer)
+	if err := encoder(buf, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(router.Compress(w.Header(), r.Header.Get("Accept-Encoding"), cfg.Compression, buf.Bytes()))
+}
+
+// RequestBuilder creates a proxy.Request from the received http.Request, the list of query string
+// params and the list of headers to pass
+type RequestBuilder func(r *http.Request, queryString, headersToPass []string) *proxy.Request
 
 // ParamExtractor is a function that extracts the params from the received uri
 type ParamExtractor func(*http.Request) map[string]string
 
-// NewRequest is a RequestBuilder that creates a proxy request from the received http request withoutAdd commentMore actions
-// processing the uri params
-var NewRequest = NewRequestBuilder(func(r *http.Request) map[string]string {
-	return map[string]string{}
-})
+// NewRequest is a RequestBuilder that creates a proxy request from the received http request, taking
+// the uri params from the wildcards of the http.ServeMux pattern matching it
+var NewRequest = NewRequestBuilder(PathValueParamsExtractor)
+
+// wildcardPattern matches the wildcards of the http.ServeMux patterns ({user} or {path...})
+var wildcardPattern = regexp.MustCompile(`\{([^{}.$]+)(\.\.\.)?\}`)
+
+// PathValueParamsExtractor is a ParamExtractor returning the values of the wildcards of the
+// http.ServeMux pattern matching the request
+func PathValueParamsExtractor(r *http.Request) map[string]string {
+	params := map[string]string{}
+	for _, m := range wildcardPattern.FindAllStringSubmatch(r.Pattern, -1) {
+		params[strings.Title(m[1])] = r.PathValue(m[1])
+	}
+	return params
+}
 
 var (
 	headersToSend        = []string{"Content-Type"}
@@ -86,9 +142,9 @@ var (
 // NewRequestBuilder gets a RequestBuilder with the received ParamExtractor as a query paramAdd commentMore actions
 // extraction mecanism
 func NewRequestBuilder(paramExtractor ParamExtractor) RequestBuilder {
-	return func(r *http.Request, queryString []string) *proxy.Request {
+	return func(r *http.Request, queryString, headersToPass []string) *proxy.Request {
 		params := paramExtractor(r)
-		headers := make(map[string][]string, 2+len(headersToSend))
+		headers := make(map[string][]string, 2+len(headersToSend)+len(headersToPass))
 		headers["X-Forwarded-For"] = []string{r.RemoteAddr}
 		headers["User-Agent"] = userAgentHeaderValue
 
@@ -97,6 +153,11 @@ func NewRequestBuilder(paramExtractor ParamExtractor) RequestBuilder {
 				headers[k] = h
 			}
 		}
+		for _, k := range headersToPass {
+			if h, ok := r.Header[k]; ok {
+				headers[k] = h
+			}
+		}
 		query := make(map[string][]string, len(queryString))
 		for i := range queryString {
 			if v := r.URL.Query().Get(queryString[i]); v != "" {
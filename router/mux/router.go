@@ -1,12 +1,17 @@
 package mux
 
 import (
-	"fmt"
+	"github.com/ph0m1/porta/apikey"
 	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/cors"
+	"github.com/ph0m1/porta/jwt"
 	"github.com/ph0m1/porta/logging"
 	"github.com/ph0m1/porta/proxy"
+	"github.com/ph0m1/porta/ratelimit"
 	"github.com/ph0m1/porta/router"
 	"net/http"
+	"sort"
+	"strings"
 )
 
 const DefaultDebugPattern = "/__debug/"
@@ -75,14 +80,15 @@ func (r httpRouter) Run(cfg config.ServiceConfig) {
 	}
 	r.registerEndpoints(cfg.Endpoints)
 
-	server := http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.Port),
-		Handler: r.handler(),
-	}
-	r.cfg.Logger.Critical(server.ListenAndServe())
+	r.cfg.Logger.Critical(router.ListenAndServe(cfg, r.handler()))
 }
 
 func (r httpRouter) registerEndpoints(endpoints []*config.EndpointConfig) {
+	// the endpoints sharing a path are registered together, dispatching the requests by method
+	paths := []string{}
+	handlers := map[string]methodHandler{}
+	policies := map[string]cors.Policies{}
+	stores := apikey.Stores{}
 	for _, c := range endpoints {
 		proxyStack, err := r.cfg.ProxyFactory.New(c)
 
@@ -91,25 +97,142 @@ func (r httpRouter) registerEndpoints(endpoints []*config.EndpointConfig) {
 			continue
 		}
 
-		r.registerEndpoint(c.Method, c.Endpoint, r.cfg.HandlerFactory(c, proxyStack), len(c.Backend))
+		if !r.validEndpoint(c.Method, c.Endpoint, len(c.Backend)) {
+			continue
+		}
+		if _, ok := handlers[c.Endpoint]; !ok {
+			paths = append(paths, c.Endpoint)
+			handlers[c.Endpoint] = methodHandler{}
+		}
+		if _, ok := handlers[c.Endpoint][c.Method]; ok {
+			r.cfg.Logger.Error(c.Method, "endpoint already registered! Ignoring", c.Endpoint)
+			continue
+		}
+		validator, err := jwt.New(c.JWT)
+		if err != nil {
+			r.cfg.Logger.Error("loading the JWT keys", err.Error())
+			continue
+		}
+		if validator != nil {
+			proxyStack = jwt.NewMiddleware(c.JWT)(proxyStack)
+		}
+		store, err := stores.Get(c.APIKeys)
+		if err != nil {
+			r.cfg.Logger.Error("loading the API keys", err.Error())
+			continue
+		}
+		if store != nil {
+			proxyStack = apikey.NewMiddleware(c.APIKeys)(proxyStack)
+		}
+		r.cfg.Logger.Debug("registering the endpoint", c.Method, c.Endpoint)
+		var handler http.Handler = r.cfg.HandlerFactory(c, proxyStack)
+		if validator != nil {
+			handler = validator.Handler(handler)
+		}
+		if store != nil {
+			handler = store.Handler(c, handler)
+		}
+		if limiter := ratelimit.NewLimiter(c.RateLimit); limiter != nil {
+			handler = limiter.Handler(handler)
+		}
+		if policy := cors.New(c.CORS); policy != nil {
+			handler = policy.Handler(handler)
+			if _, ok := policies[c.Endpoint]; !ok {
+				policies[c.Endpoint] = cors.Policies{}
+			}
+			policies[c.Endpoint][c.Method] = policy
+		}
+		handlers[c.Endpoint][c.Method] = handler
+	}
+	// the preflight requests to the paths with a CORS policy are answered before reaching the
+	// OPTIONS endpoints
+	for path, ps := range policies {
+		handlers[path][http.MethodOptions] = ps.Handler(handlers[path][http.MethodOptions])
+	}
+	_, methodPatterns := r.cfg.Engine.(*http.ServeMux)
+	for _, path := range paths {
+		pattern := muxPattern(path)
+		if !methodPatterns {
+			r.handle(pattern, handlers[path])
+			continue
+		}
+		// the http.ServeMux routes by method and answers the HEAD requests with the GET handlers
+		methods := make([]string, 0, len(handlers[path]))
+		for method := range handlers[path] {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			r.handle(method+" "+pattern, handlers[path][method])
+		}
+	}
+}
+
+// handle registers the handler, logging the patterns rejected by the engine
+func (r httpRouter) handle(pattern string, handler http.Handler) {
+	defer func() {
+		if err := recover(); err != nil {
+			r.cfg.Logger.Error("registering the pattern", pattern, err)
+		}
+	}()
+	r.cfg.Engine.Handle(pattern, handler)
+}
+
+// muxPattern returns the path with its colon style params (/users/:user) converted to the wildcards
+// of the http.ServeMux and gorilla patterns (/users/{user})
+func muxPattern(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if strings.HasPrefix(s, ":") && len(s) > 1 {
+			segments[i] = "{" + s[1:] + "}"
+		}
 	}
+	return strings.Join(segments, "/")
 }
 
-func (r httpRouter) registerEndpoint(method, path string, handler http.HandlerFunc, toBackends int) {
+func (r httpRouter) validEndpoint(method, path string, toBackends int) bool {
 	if method != "GET" && toBackends > 1 {
 		r.cfg.Logger.Error(method, "endpoints must have a single backend! Ignoring", path)
-		return
+		return false
 	}
 	switch method {
-	case "GET":
-	case "POST":
-	case "PUT":
+	case config.GET, config.HEAD, config.POST, config.PUT, config.PATCH, config.DELETE, config.OPTIONS, config.CONNECT, config.TRACE:
+		return true
 	default:
 		r.cfg.Logger.Error("Unsupported method", method)
+		return false
+	}
+}
+
+// methodHandler dispatches the requests to the handler of their method. The HEAD requests are
+// handled by the GET handler when there is no HEAD one
+type methodHandler map[string]http.Handler
+
+func (m methodHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, ok := m[r.Method]
+	if !ok && r.Method == http.MethodHead {
+		h, ok = m[http.MethodGet]
+	}
+	if !ok {
+		w.Header().Set("Allow", m.allow())
+		http.Error(w, "", http.StatusMethodNotAllowed)
 		return
 	}
-	r.cfg.Logger.Debug("registering the endpoint", method, path)
-	r.cfg.Engine.Handle(path, handler)
+	h.ServeHTTP(w, r)
+}
+
+func (m methodHandler) allow() string {
+	methods := make([]string, 0, len(m)+1)
+	for method := range m {
+		methods = append(methods, method)
+	}
+	if _, ok := m[http.MethodGet]; ok {
+		if _, ok := m[http.MethodHead]; !ok {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
 }
 
 func (r httpRouter) handler() http.Handler {
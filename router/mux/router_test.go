@@ -0,0 +1,333 @@
+package mux
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/logging/gologging"
+	"github.com/ph0m1/porta/proxy"
+)
+
+// paramsProxyFactory creates proxies returning the method of the endpoint and the request params
+type paramsProxyFactory struct{}
+
+func (paramsProxyFactory) New(cfg *config.EndpointConfig) (proxy.Proxy, error) {
+	return func(_ context.Context, r *proxy.Request) (*proxy.Response, error) {
+		return &proxy.Response{Data: map[string]interface{}{"method": cfg.Method, "params": fmt.Sprint(r.Params)}, IsComplete: true}, nil
+	}, nil
+}
+
+func TestRouter_methodPatterns(t *testing.T) {
+	logger, _ := gologging.NewLogger("ERROR", io.Discard, "")
+	r := DefaultFactory(paramsProxyFactory{}, logger).New().(httpRouter)
+
+	endpoint := func(method, path string) *config.EndpointConfig {
+		return &config.EndpointConfig{Endpoint: path, Method: method, Timeout: time.Second, OutputEncoding: "json", Backend: []*config.Backend{{}}}
+	}
+	r.registerEndpoints([]*config.EndpointConfig{
+		endpoint("GET", "/users/:user/posts/:post"),
+		endpoint("DELETE", "/users/:user/posts/:post"),
+		endpoint("POST", "/users/{user}"),
+		endpoint("GET", "/users/{invalid-name}/tags"),
+	})
+	server := httptest.NewServer(r.handler())
+	defer server.Close()
+
+	for _, tc := range []struct {
+		method, path string
+		status       int
+		body         string
+	}{
+		{"GET", "/users/supu/posts/42", http.StatusOK, `{"method":"GET","params":"map[Post:42 User:supu]"}`},
+		{"HEAD", "/users/supu/posts/42", http.StatusOK, ""},
+		{"DELETE", "/users/supu/posts/42", http.StatusOK, `{"method":"DELETE","params":"map[Post:42 User:supu]"}`},
+		{"POST", "/users/tupu", http.StatusOK, `{"method":"POST","params":"map[User:tupu]"}`},
+		{"PUT", "/users/supu/posts/42", http.StatusMethodNotAllowed, ""},
+		{"GET", "/users/supu/tags", http.StatusNotFound, ""},
+	} {
+		req, _ := http.NewRequest(tc.method, server.URL+tc.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s: want status %d, have %d", tc.method, tc.path, tc.status, resp.StatusCode)
+		}
+		if tc.status == http.StatusOK && strings.TrimSpace(string(b)) != tc.body {
+			t.Errorf("%s %s: unexpected body %s", tc.method, tc.path, b)
+		}
+	}
+}
+
+func TestRouter_cors(t *testing.T) {
+	logger, _ := gologging.NewLogger("ERROR", io.Discard, "")
+	r := DefaultFactory(paramsProxyFactory{}, logger).New().(httpRouter)
+
+	policy := &config.CORSConfig{AllowOrigins: []string{"https://*.supu.com"}, AllowHeaders: []string{"Authorization"}}
+	r.registerEndpoints([]*config.EndpointConfig{
+		{Endpoint: "/users", Method: "GET", Timeout: time.Second, OutputEncoding: "json", Backend: []*config.Backend{{}}, CORS: policy},
+		{Endpoint: "/users", Method: "POST", Timeout: time.Second, OutputEncoding: "json", Backend: []*config.Backend{{}}},
+	})
+	server := httptest.NewServer(r.handler())
+	defer server.Close()
+
+	do := func(method, requestedMethod string) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+"/users", nil)
+		req.Header.Set("Origin", "https://app.supu.com")
+		if requestedMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", requestedMethod)
+			req.Header.Set("Access-Control-Request-Headers", "Authorization")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := do("OPTIONS", "GET")
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Allow-Origin") != "https://app.supu.com" ||
+		resp.Header.Get("Access-Control-Allow-Methods") != "GET" || resp.Header.Get("Access-Control-Allow-Headers") != "Authorization" {
+		t.Errorf("unexpected preflight response: %d %v", resp.StatusCode, resp.Header)
+	}
+	if resp = do("OPTIONS", "POST"); resp.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("the POST endpoint has no CORS policy: %v", resp.Header)
+	}
+	if resp = do("GET", ""); resp.StatusCode != http.StatusOK || resp.Header.Get("Access-Control-Allow-Origin") != "https://app.supu.com" {
+		t.Errorf("unexpected response: %d %v", resp.StatusCode, resp.Header)
+	}
+	if resp = do("OPTIONS", ""); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+}
+
+func TestRouter_jwt(t *testing.T) {
+	logger, _ := gologging.NewLogger("ERROR", io.Discard, "")
+	r := DefaultFactory(paramsProxyFactory{}, logger).New().(httpRouter)
+
+	r.registerEndpoints([]*config.EndpointConfig{{
+		Endpoint:       "/users/{user}",
+		Method:         "GET",
+		Timeout:        time.Second,
+		OutputEncoding: "json",
+		Backend:        []*config.Backend{{}},
+		CORS:           &config.CORSConfig{AllowOrigins: []string{"*"}},
+		JWT: &config.JWTConfig{
+			Keys:           []config.JWTKeyConfig{{Secret: "supu"}},
+			ClaimsToParams: map[string]string{"sub": "sub"},
+		},
+	}})
+	server := httptest.NewServer(r.handler())
+	defer server.Close()
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	signed := b64([]byte(`{"alg":"HS256"}`)) + "." + b64([]byte(`{"sub":"42"}`))
+	mac := hmac.New(sha256.New, []byte("supu"))
+	mac.Write([]byte(signed))
+	token := signed + "." + b64(mac.Sum(nil))
+
+	for _, tc := range []struct {
+		authorization string
+		status        int
+		body          string
+	}{
+		{"", http.StatusUnauthorized, "jwt: missing bearer token"},
+		{"Bearer " + signed + ".c3VwdQ", http.StatusUnauthorized, "jwt: invalid token"},
+		{"Bearer " + token, http.StatusOK, `{"method":"GET","params":"map[Sub:42 User:7]"}`},
+	} {
+		req, _ := http.NewRequest("GET", server.URL+"/users/7", nil)
+		req.Header.Set("Origin", "https://supu.com")
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status || strings.TrimSpace(string(body)) != tc.body {
+			t.Errorf("unexpected response: %d %s", resp.StatusCode, body)
+		}
+		if resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+			t.Errorf("the response should have the CORS headers: %v", resp.Header)
+		}
+	}
+}
+
+func TestRouter_apiKeys(t *testing.T) {
+	logger, _ := gologging.NewLogger("ERROR", io.Discard, "")
+	r := DefaultFactory(paramsProxyFactory{}, logger).New().(httpRouter)
+
+	keys := &config.APIKeysConfig{
+		Keys:   []config.APIKeyConfig{{Key: "supu", Client: "supu", Endpoints: []string{"/users"}, RateLimit: 1}},
+		Header: "X-Api-Key",
+	}
+	endpoint := func(method, path string) *config.EndpointConfig {
+		return &config.EndpointConfig{Endpoint: path, Method: method, Timeout: time.Second, OutputEncoding: "json", Backend: []*config.Backend{{}}, APIKeys: keys}
+	}
+	r.registerEndpoints([]*config.EndpointConfig{endpoint("GET", "/users"), endpoint("POST", "/users"), endpoint("GET", "/admin")})
+	server := httptest.NewServer(r.handler())
+	defer server.Close()
+
+	for _, tc := range []struct {
+		method, path, key string
+		status            int
+	}{
+		{"GET", "/users", "", http.StatusUnauthorized},
+		{"GET", "/users", "tupu", http.StatusUnauthorized},
+		{"GET", "/admin", "supu", http.StatusForbidden},
+		{"GET", "/users", "supu", http.StatusOK},
+		// the quota is shared by all the endpoints
+		{"POST", "/users", "supu", http.StatusTooManyRequests},
+	} {
+		req, _ := http.NewRequest(tc.method, server.URL+tc.path, nil)
+		req.Header.Set("X-Api-Key", tc.key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s %s: want %d, have %d", tc.method, tc.path, tc.key, tc.status, resp.StatusCode)
+		}
+	}
+}
+
+// rateLimitedProxyFactory creates the proxies of the paramsProxyFactory limited by the rate limit
+// of the first backend
+type rateLimitedProxyFactory struct{}
+
+func (rateLimitedProxyFactory) New(cfg *config.EndpointConfig) (proxy.Proxy, error) {
+	p, _ := paramsProxyFactory{}.New(cfg)
+	return proxy.NewRateLimitMiddleware(cfg.Backend[0].RateLimit)(p), nil
+}
+
+func TestRouter_rateLimit(t *testing.T) {
+	logger, _ := gologging.NewLogger("ERROR", io.Discard, "")
+	r := DefaultFactory(rateLimitedProxyFactory{}, logger).New().(httpRouter)
+
+	r.registerEndpoints([]*config.EndpointConfig{
+		{
+			Endpoint:       "/endpoint",
+			Method:         "GET",
+			Timeout:        time.Second,
+			OutputEncoding: "json",
+			Backend:        []*config.Backend{{}},
+			RateLimit:      &config.RateLimitConfig{ClientMaxRate: 1, Key: "header:X-Client-Id"},
+		},
+		{
+			Endpoint:       "/backend",
+			Method:         "GET",
+			Timeout:        time.Second,
+			OutputEncoding: "json",
+			Backend:        []*config.Backend{{RateLimit: &config.RateLimitConfig{MaxRate: 0.5}}},
+		},
+	})
+	server := httptest.NewServer(r.handler())
+	defer server.Close()
+
+	for _, tc := range []struct {
+		path, client string
+		status       int
+		retryAfter   string
+	}{
+		{"/endpoint", "supu", http.StatusOK, ""},
+		{"/endpoint", "supu", http.StatusTooManyRequests, "1"},
+		{"/endpoint", "tupu", http.StatusOK, ""},
+		{"/backend", "supu", http.StatusOK, ""},
+		{"/backend", "tupu", http.StatusTooManyRequests, "2"},
+	} {
+		req, _ := http.NewRequest("GET", server.URL+tc.path, nil)
+		req.Header.Set("X-Client-Id", tc.client)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status || resp.Header.Get("Retry-After") != tc.retryAfter {
+			t.Errorf("%s %s: unexpected response %d %v", tc.path, tc.client, resp.StatusCode, resp.Header)
+		}
+	}
+}
+
+func TestRouter_methodHandler(t *testing.T) {
+	h := methodHandler{"GET": http.NotFoundHandler(), "POST": http.NotFoundHandler()}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("PUT", "/", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD, POST" {
+		t.Errorf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("HEAD", "/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("the HEAD request was not handled by the GET handler: %d", w.Code)
+	}
+}
+
+// slowBodyProxy returns a no-op response with a body written in chunks after the delay, failing
+// when the context is done as the bodies of the backend responses
+func slowBodyProxy(delay time.Duration, chunks ...string) proxy.Proxy {
+	return func(ctx context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			for _, chunk := range chunks {
+				select {
+				case <-ctx.Done():
+					pw.CloseWithError(ctx.Err())
+					return
+				case <-time.After(delay):
+				}
+				pw.Write([]byte(chunk))
+			}
+			pw.Close()
+		}()
+		return &proxy.Response{IsComplete: true, Io: pr, Metadata: proxy.Metadata{StatusCode: http.StatusOK}}, nil
+	}
+}
+
+func TestEndpointHandler_noopTimeout(t *testing.T) {
+	// the timeout of the endpoints is in milliseconds
+	cfg := &config.EndpointConfig{Endpoint: "/supu", Method: "GET", Timeout: 50, OutputEncoding: "no-op"}
+
+	// the body is streamed after the timeout
+	w := httptest.NewRecorder()
+	EndpointHandler(cfg, slowBodyProxy(30*time.Millisecond, "supu", "tupu", "foo"))(w, httptest.NewRequest("GET", "/supu", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "suputupufoo" {
+		t.Errorf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+
+	// the backends must respond before the timeout
+	w = httptest.NewRecorder()
+	EndpointHandler(cfg, func(ctx context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})(w, httptest.NewRequest("GET", "/supu", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+}
+
+func TestDebugHandler_tier(t *testing.T) {
+	logger, _ := gologging.NewLogger("ERROR", io.Discard, "")
+	req := httptest.NewRequest("GET", "/__debug/supu", nil)
+	req.Header.Set(proxy.TierHeader, "dc1")
+	w := httptest.NewRecorder()
+	DebugHandler(logger)(w, req)
+	if body := w.Body.String(); body != `{"message":"pong","tier":"dc1"}` {
+		t.Errorf("unexpected body: %s", body)
+	}
+}
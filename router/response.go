@@ -0,0 +1,145 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/ph0m1/porta/compression"
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/encoding"
+	"github.com/ph0m1/porta/proxy"
+	"github.com/ph0m1/porta/ratelimit"
+)
+
+// ErrorStatus returns the status code of the responses failed with the received error. The body is
+// the limited request body, if any
+func ErrorStatus(err error, body *proxy.LimitedReadCloser) int {
+	switch {
+	case errors.Is(err, proxy.ErrRequestTooLarge) || (body != nil && body.Exceeded()):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, proxy.ErrResponseTooLarge) || errors.Is(err, encoding.ErrMaxDepthExceeded):
+		return http.StatusBadGateway
+	case ratelimit.RetryAfter(err) != "":
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// Compress returns the body compressed with the content coding negotiated with the client, setting
+// the related headers. The body is returned untouched when there is no content coding to apply
+func Compress(headers http.Header, acceptEncoding string, cfg config.CompressionConfig, body []byte) []byte {
+	if len(cfg.Encodings) == 0 {
+		return body
+	}
+	headers.Add("Vary", "Accept-Encoding")
+	if len(body) < cfg.MinSize {
+		return body
+	}
+	name := compression.Negotiate(acceptEncoding, cfg.Encodings)
+	if name == "" {
+		return body
+	}
+	compressed, err := compression.Compress(name, body, cfg.Level)
+	if err != nil {
+		return body
+	}
+	headers.Set("Content-Encoding", name)
+	return compressed
+}
+
+// NewEndpointContext returns the context of a request to an endpoint, canceled when the parent is
+// done or when the endpoint timeout passes, with the function stopping the timeout and the one
+// canceling the context. The timeout must be stopped as soon as the proxy returns, so the no-op
+// responses streamed after it are not cut off. Stopping it reports false when it already passed
+func NewEndpointContext(parent context.Context, timeout time.Duration) (context.Context, func() bool, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	timer := time.AfterFunc(timeout, func() { cancel(context.DeadlineExceeded) })
+	return ctx, timer.Stop, func() {
+		timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// Stream copies the status, headers and body of the backend response to the client. The body is
+// flushed as it is read, so a slow client slows down the backend reads, and the copy stops as soon
+// as the client goes away or the request context is done
+func Stream(w http.ResponseWriter, response *proxy.Response) {
+	defer CloseBody(response)
+	if response == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	copyHeaders(w.Header(), response.Metadata.Headers)
+	status := response.Metadata.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	if response.Io != nil {
+		copyBody(w, response.Io)
+	}
+}
+
+// CloseBody closes the body of the backend response, when it has one
+func CloseBody(response *proxy.Response) {
+	if response == nil {
+		return
+	}
+	if closer, ok := response.Io.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// hopHeaders are the hop-by-hop headers not forwarded to the client
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func copyHeaders(dst http.Header, src map[string][]string) {
+	skip := map[string]struct{}{}
+	for _, h := range hopHeaders {
+		skip[h] = struct{}{}
+	}
+	for _, v := range src["Connection"] {
+		for _, h := range strings.Split(v, ",") {
+			skip[textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(h))] = struct{}{}
+		}
+	}
+	for k, v := range src {
+		if _, ok := skip[k]; ok {
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+func copyBody(w io.Writer, body io.Reader) {
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/encoding"
+	"github.com/ph0m1/porta/proxy"
+	"github.com/ph0m1/porta/ratelimit"
+)
+
+type closingReader struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closingReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestStream(t *testing.T) {
+	body := &closingReader{Reader: strings.NewReader("supu")}
+	w := httptest.NewRecorder()
+	Stream(w, &proxy.Response{
+		Io: body,
+		Metadata: proxy.Metadata{
+			StatusCode: http.StatusCreated,
+			Headers: map[string][]string{
+				"Content-Type":      {"text/plain"},
+				"Connection":        {"keep-alive, X-Hop"},
+				"Keep-Alive":        {"timeout=5"},
+				"Transfer-Encoding": {"chunked"},
+				"X-Hop":             {"supu"},
+			},
+		},
+	})
+	if w.Code != http.StatusCreated || w.Body.String() != "supu" || !body.closed {
+		t.Errorf("unexpected response: %d %s %v", w.Code, w.Body.String(), body.closed)
+	}
+	if len(w.Header()) != 1 || w.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("the hop-by-hop headers were forwarded: %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	Stream(w, nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	cfg := config.CompressionConfig{Encodings: []string{"br", "gzip"}, MinSize: 5}
+	body := []byte(strings.Repeat("supu", 100))
+
+	h := http.Header{}
+	if b := Compress(h, "gzip, deflate", cfg, body); len(b) >= len(body) || h.Get("Content-Encoding") != "gzip" || h.Get("Vary") != "Accept-Encoding" {
+		t.Errorf("unexpected headers: %v", h)
+	}
+	h = http.Header{}
+	if b := Compress(h, "gzip", cfg, []byte("supu")); string(b) != "supu" || h.Get("Content-Encoding") != "" {
+		t.Errorf("the body under the min size was compressed: %v", h)
+	}
+	h = http.Header{}
+	if b := Compress(h, "gzip", config.CompressionConfig{}, body); len(b) != len(body) || len(h) != 0 {
+		t.Errorf("the body was compressed without content codings: %v", h)
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	exceeded := proxy.NewLimitedReadCloser(io.NopCloser(strings.NewReader("supu")), 1, proxy.ErrRequestTooLarge)
+	io.ReadAll(exceeded)
+	for i, tc := range []struct {
+		err    error
+		body   *proxy.LimitedReadCloser
+		status int
+	}{
+		{proxy.ErrRequestTooLarge, nil, http.StatusRequestEntityTooLarge},
+		{errors.New("supu"), exceeded, http.StatusRequestEntityTooLarge},
+		{fmt.Errorf("decoding: %w", proxy.ErrResponseTooLarge), nil, http.StatusBadGateway},
+		{encoding.ErrMaxDepthExceeded, nil, http.StatusBadGateway},
+		{&ratelimit.Error{RetryAfter: time.Second}, nil, http.StatusTooManyRequests},
+		{errors.New("supu"), nil, http.StatusInternalServerError},
+	} {
+		if status := ErrorStatus(tc.err, tc.body); status != tc.status {
+			t.Errorf("#%d: want %d, have %d", i, tc.status, status)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package router
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// NewServer returns the server exposing the handler at the port of the service. The server is
+// configured for TLS when the service config defines it, and accepts HTTP/2 over TLS unless it is
+// disabled. Without TLS, it accepts HTTP/2 with prior knowledge (h2c) when the service enables it
+func NewServer(cfg config.ServiceConfig, handler http.Handler) (*http.Server, error) {
+	server := &http.Server{
+		Addr:      fmt.Sprintf(":%d", cfg.Port),
+		Handler:   handler,
+		Protocols: new(http.Protocols),
+	}
+	server.Protocols.SetHTTP1(true)
+	if cfg.TLS == nil {
+		server.Protocols.SetUnencryptedHTTP2(cfg.H2C)
+		return server, nil
+	}
+
+	tlsConfig, err := NewTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	server.TLSConfig = tlsConfig
+	server.Protocols.SetHTTP2(!cfg.TLS.DisableHTTP2)
+	return server, nil
+}
+
+// NewTLSConfig returns the TLS settings of the listener, loading its certificates and client CAs
+func NewTLSConfig(cfg *config.ServerTLSConfig) (*tls.Config, error) {
+	minVersion, err := config.ParseTLSVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := config.ParseCipherSuites(cfg.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	clientAuth, err := config.ParseClientAuth(cfg.ClientAuth, len(cfg.ClientCAs) > 0)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+		ClientAuth:   clientAuth,
+	}
+	for _, kp := range cfg.Certificates {
+		cert, err := tls.LoadX509KeyPair(kp.CertFile, kp.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+	}
+	if len(cfg.ClientCAs) > 0 {
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		for _, path := range cfg.ClientCAs {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if !tlsConfig.ClientCAs.AppendCertsFromPEM(b) {
+				return nil, fmt.Errorf("no PEM encoded certificates found at %s", path)
+			}
+		}
+	}
+	return tlsConfig, nil
+}
+
+// RedirectHandler redirects the requests to the same URL with the https scheme at the received port
+func RedirectHandler(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// ListenAndServe runs the server of the service and, when the TLS settings define a redirect port,
+// the plain HTTP server redirecting to it. It blocks until one of them fails
+func ListenAndServe(cfg config.ServiceConfig, handler http.Handler) error {
+	server, err := NewServer(cfg, handler)
+	if err != nil {
+		return err
+	}
+	if cfg.TLS == nil {
+		return server.ListenAndServe()
+	}
+
+	errs := make(chan error, 2)
+	if cfg.TLS.RedirectPort != 0 {
+		redirect := &http.Server{
+			Addr:    fmt.Sprintf(":%d", cfg.TLS.RedirectPort),
+			Handler: RedirectHandler(cfg.Port),
+		}
+		go func() { errs <- redirect.ListenAndServe() }()
+	}
+	go func() { errs <- server.ListenAndServeTLS("", "") }()
+	return <-errs
+}
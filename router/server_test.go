@@ -0,0 +1,171 @@
+package router
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+// writeKeyPair stores a self signed certificate for the received name and returns its files
+func writeKeyPair(t *testing.T, name string) config.KeyPairConfig {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:              []string{name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := x509.MarshalECPrivateKey(key)
+	dir := t.TempDir()
+	kp := config.KeyPairConfig{CertFile: filepath.Join(dir, name+".pem"), KeyFile: filepath.Join(dir, name+".key")}
+	os.WriteFile(kp.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(kp.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600)
+	return kp
+}
+
+func serve(t *testing.T, server *http.Server, withTLS bool) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withTLS {
+		go server.ServeTLS(l, "", "")
+	} else {
+		go server.Serve(l)
+	}
+	t.Cleanup(func() { server.Close() })
+	return l.Addr().String()
+}
+
+var protoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(r.Proto))
+})
+
+func TestNewServer_tls(t *testing.T) {
+	supu, tupu, client := writeKeyPair(t, "supu.local"), writeKeyPair(t, "tupu.local"), writeKeyPair(t, "client")
+	cfg := config.ServiceConfig{Port: 8080, TLS: &config.ServerTLSConfig{
+		Certificates: []config.KeyPairConfig{supu, tupu},
+		MinVersion:   "1.2",
+		ClientCAs:    []string{client.CertFile},
+	}}
+	server, err := NewServer(cfg, protoHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.Addr != ":8080" || server.TLSConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("unexpected server: %+v", server)
+	}
+	addr := serve(t, server, true)
+
+	clientCert, _ := tls.LoadX509KeyPair(client.CertFile, client.KeyFile)
+	for _, name := range []string{"supu.local", "tupu.local"} {
+		transport := &http.Transport{
+			TLSClientConfig:   &tls.Config{ServerName: name, InsecureSkipVerify: true, Certificates: []tls.Certificate{clientCert}},
+			ForceAttemptHTTP2: true,
+		}
+		resp, err := (&http.Client{Transport: transport}).Get("https://" + addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if cn := resp.TLS.PeerCertificates[0].Subject.CommonName; cn != name {
+			t.Errorf("unexpected certificate for %s: %s", name, cn)
+		}
+		if resp.Proto != "HTTP/2.0" {
+			t.Errorf("unexpected protocol: %s", resp.Proto)
+		}
+	}
+
+	transport := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	if _, err := (&http.Client{Transport: transport}).Get("https://" + addr); err == nil {
+		t.Error("error expected without a client certificate")
+	}
+}
+
+func TestNewServer_disableHTTP2(t *testing.T) {
+	cfg := config.ServiceConfig{TLS: &config.ServerTLSConfig{
+		Certificates: []config.KeyPairConfig{writeKeyPair(t, "supu.local")},
+		DisableHTTP2: true,
+	}}
+	server, err := NewServer(cfg, protoHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, server, true)
+	transport := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, ForceAttemptHTTP2: true}
+	resp, err := (&http.Client{Transport: transport}).Get("https://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Proto != "HTTP/1.1" {
+		t.Errorf("unexpected protocol: %s", resp.Proto)
+	}
+}
+
+func TestNewServer_h2c(t *testing.T) {
+	server, err := NewServer(config.ServiceConfig{H2C: true}, protoHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, server, false)
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	resp, err := (&http.Client{Transport: transport}).Get("http://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Proto != "HTTP/2.0" {
+		t.Errorf("unexpected protocol: %s", resp.Proto)
+	}
+}
+
+func TestNewServer_ko(t *testing.T) {
+	kp := writeKeyPair(t, "supu.local")
+	for _, tlsConfig := range []*config.ServerTLSConfig{
+		{Certificates: []config.KeyPairConfig{{CertFile: "/nowhere/cert.pem", KeyFile: "/nowhere/cert.key"}}},
+		{Certificates: []config.KeyPairConfig{kp}, ClientCAs: []string{kp.KeyFile}},
+		{Certificates: []config.KeyPairConfig{kp}, CipherSuites: []string{"unknown"}},
+	} {
+		if _, err := NewServer(config.ServiceConfig{TLS: tlsConfig}, protoHandler); err == nil {
+			t.Errorf("error expected with %+v", tlsConfig)
+		}
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	for port, want := range map[int]string{443: "https://supu.local/a?b=c", 8443: "https://supu.local:8443/a?b=c"} {
+		w := httptest.NewRecorder()
+		RedirectHandler(port).ServeHTTP(w, httptest.NewRequest("POST", "http://supu.local:8080/a?b=c", nil))
+		if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != want {
+			t.Errorf("%d: unexpected redirect %d %s", port, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
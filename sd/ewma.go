@@ -0,0 +1,135 @@
+package sd
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultEWMADecay is the decay window used by the P2C EWMA balancer when none is provided
+const DefaultEWMADecay = 10 * time.Second
+
+// NewP2CEWMALB returns a power of two choices balancer. For every request it picks two random hosts
+// and selects the one with the lowest peak exponentially weighted moving average latency, multiplied
+// by its number of outstanding requests. Latency peaks are applied immediately and decay over time.
+// Failed requests are accounted as taking at least the decay window, so broken hosts are quickly
+// avoided. The returned balancer implements the Tracker interface
+func NewP2CEWMALB(subscriber Subscriber, seed int64, decay time.Duration) Balancer {
+	if decay <= 0 {
+		decay = DefaultEWMADecay
+	}
+	return &p2cEWMALB{
+		subscriber: subscriber,
+		rnd:        newLockedRand(seed),
+		decay:      decay,
+		stats:      map[string]*hostStats{},
+		now:        time.Now,
+	}
+}
+
+type p2cEWMALB struct {
+	subscriber Subscriber
+	rnd        *lockedRand
+	decay      time.Duration
+	mu         sync.Mutex
+	stats      map[string]*hostStats
+	now        func() time.Time
+}
+
+type hostStats struct {
+	ewma        float64
+	outstanding int
+	lastUpdate  time.Time
+}
+
+func (p *p2cEWMALB) Host(_ context.Context) (string, error) {
+	hosts, err := p.subscriber.Hosts()
+	if err != nil {
+		return "", err
+	}
+	if len(hosts) <= 0 {
+		return "", ErrNoHosts
+	}
+
+	var selected string
+	if len(hosts) == 1 {
+		selected = hosts[0]
+	} else {
+		i := p.rnd.Intn(len(hosts))
+		j := p.rnd.Intn(len(hosts) - 1)
+		if j >= i {
+			j++
+		}
+		selected = hosts[i]
+		p.mu.Lock()
+		if p.score(hosts[j]) < p.score(hosts[i]) {
+			selected = hosts[j]
+		}
+		p.mu.Unlock()
+	}
+
+	p.mu.Lock()
+	p.prune(hosts)
+	p.get(selected).outstanding++
+	p.mu.Unlock()
+	return selected, nil
+}
+
+// prune drops the stats of the hosts not in the current host list, so the hosts removed by the
+// service discovery do not stay in memory. The stats only have entries for the selected hosts, so
+// there are stale ones when there are more entries than hosts
+func (p *p2cEWMALB) prune(hosts []string) {
+	if len(p.stats) <= len(hosts) {
+		return
+	}
+	current := make(map[string]struct{}, len(hosts))
+	for _, h := range hosts {
+		current[h] = struct{}{}
+	}
+	for h := range p.stats {
+		if _, ok := current[h]; !ok {
+			delete(p.stats, h)
+		}
+	}
+}
+
+// Release implements the Tracker interface
+func (p *p2cEWMALB) Release(host string, latency time.Duration, err error) {
+	if err != nil && latency < p.decay {
+		latency = p.decay
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := p.get(host)
+	if s.outstanding > 0 {
+		s.outstanding--
+	}
+	now := p.now()
+	if s.lastUpdate.IsZero() || float64(latency) > s.ewma {
+		s.ewma = float64(latency)
+	} else {
+		w := math.Exp(-float64(now.Sub(s.lastUpdate)) / float64(p.decay))
+		s.ewma = s.ewma*w + float64(latency)*(1-w)
+	}
+	s.lastUpdate = now
+}
+
+func (p *p2cEWMALB) score(host string) float64 {
+	s, ok := p.stats[host]
+	if !ok {
+		return 0
+	}
+	return s.ewma * float64(s.outstanding+1)
+}
+
+func (p *p2cEWMALB) get(host string) *hostStats {
+	s, ok := p.stats[host]
+	if !ok {
+		s = &hostStats{}
+		p.stats[host] = s
+	}
+	return s
+}
@@ -0,0 +1,137 @@
+package sd
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultReplicas is the number of virtual nodes per host used by the consistent hash balancer when
+// none is provided
+const DefaultReplicas = 160
+
+type hashKeyContextKey struct{}
+
+// NewContextWithHashKey returns a copy of the context carrying the key to be used by the consistent
+// hash balancers
+func NewContextWithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKeyContextKey{}, key)
+}
+
+// HashKeyFromContext returns the hash key stored in the context, if any
+func HashKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(hashKeyContextKey{}).(string)
+	return key, ok && key != ""
+}
+
+// NewConsistentHashLB returns a balancer mapping the hash key found in the context to a host using a
+// consistent hash ring with the given number of virtual nodes per host, so the same key sticks to the
+// same host and only a small fraction of the keys move when the set of hosts changes. Requests
+// without a hash key are distributed in a round robin fashion
+func NewConsistentHashLB(subscriber Subscriber, replicas int) Balancer {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	return &consistentHashLB{
+		subscriber: subscriber,
+		replicas:   replicas,
+	}
+}
+
+type consistentHashLB struct {
+	subscriber Subscriber
+	replicas   int
+	counter    uint64
+	mu         sync.RWMutex
+	ring       *hashRing
+}
+
+type hashRing struct {
+	hosts  []string
+	hashes []uint64
+	owners map[uint64]string
+}
+
+func (c *consistentHashLB) Host(ctx context.Context) (string, error) {
+	hosts, err := c.subscriber.Hosts()
+	if err != nil {
+		return "", err
+	}
+	if len(hosts) <= 0 {
+		return "", ErrNoHosts
+	}
+
+	key, ok := HashKeyFromContext(ctx)
+	if !ok {
+		offset := (atomic.AddUint64(&c.counter, 1) - 1) % uint64(len(hosts))
+		return hosts[offset], nil
+	}
+
+	ring := c.getRing(hosts)
+	h := hashOf(key)
+	i := sort.Search(len(ring.hashes), func(i int) bool { return ring.hashes[i] >= h })
+	if i == len(ring.hashes) {
+		i = 0
+	}
+	return ring.owners[ring.hashes[i]], nil
+}
+
+func (c *consistentHashLB) getRing(hosts []string) *hashRing {
+	c.mu.RLock()
+	ring := c.ring
+	c.mu.RUnlock()
+	if ring != nil && sameHosts(ring.hosts, hosts) {
+		return ring
+	}
+
+	ring = &hashRing{
+		hosts:  append([]string{}, hosts...),
+		hashes: make([]uint64, 0, len(hosts)*c.replicas),
+		owners: make(map[uint64]string, len(hosts)*c.replicas),
+	}
+	for _, host := range hosts {
+		for r := 0; r < c.replicas; r++ {
+			h := hashOf(strconv.Itoa(r) + "-" + host)
+			if _, ok := ring.owners[h]; ok {
+				continue
+			}
+			ring.owners[h] = host
+			ring.hashes = append(ring.hashes, h)
+		}
+	}
+	sort.Slice(ring.hashes, func(i, j int) bool { return ring.hashes[i] < ring.hashes[j] })
+
+	c.mu.Lock()
+	c.ring = ring
+	c.mu.Unlock()
+	return ring
+}
+
+func sameHosts(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hashOf returns the FNV-1a hash of the key, finalized with the murmur3 mixer to spread similar keys
+// (like the virtual nodes of the same host) across the whole ring
+func hashOf(key string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(key))
+	h := f.Sum64()
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
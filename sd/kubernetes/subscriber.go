@@ -0,0 +1,535 @@
+// Package kubernetes provides a subscriber reading the hosts of a service from the Endpoints or the
+// EndpointSlice resources of the Kubernetes API server, watching them for changes
+package kubernetes
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+	"github.com/ph0m1/porta/sd"
+)
+
+const (
+	// ResourceEndpoints selects the core/v1 Endpoints resource
+	ResourceEndpoints = "endpoints"
+	// ResourceEndpointSlices selects the discovery.k8s.io/v1 EndpointSlice resources
+	ResourceEndpointSlices = "endpointslices"
+
+	// DefaultTokenFile is the path of the service account token mounted in the pods
+	DefaultTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	// DefaultCAFile is the path of the cluster CA bundle mounted in the pods
+	DefaultCAFile = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	// DefaultNamespaceFile is the path of the file with the namespace of the pod
+	DefaultNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+	defaultRetryBackoff = time.Second
+)
+
+var (
+	// ErrNoService is returned when the name of the service is not defined
+	ErrNoService = errors.New("kubernetes: the service name is required")
+	// ErrNoAPIServer is returned when the API server address is not defined and the subscriber is
+	// not running inside a cluster
+	ErrNoAPIServer = errors.New("kubernetes: unable to locate the API server")
+
+	errGone = errors.New("kubernetes: resource version too old")
+)
+
+// Config defines the resource to watch and how to connect to the API server
+type Config struct {
+	// address of the API server. Defaults to the in-cluster one
+	APIServer string
+	// namespace of the service. Defaults to the namespace of the pod or "default"
+	Namespace string
+	// name of the service
+	Service string
+	// name or number of the port to use. Defaults to the first port of the resource
+	Port string
+	// scheme of the returned hosts. Defaults to http
+	Scheme string
+	// resource to watch: endpoints (default) or endpointslices
+	Resource string
+	// service account token, used when the file exists. Defaults to the in-cluster one
+	TokenFile string
+	// CA bundle to validate the API server, used when the file exists. Defaults to the in-cluster one
+	CAFile string
+	// time to wait before reconnecting after a failure or a closed watch
+	RetryBackoff time.Duration
+	// client to use. When nil, a client trusting the CA bundle is created
+	Client *http.Client
+}
+
+// Subscriber keeps the set of hosts of a service updated, watching the API server
+type Subscriber struct {
+	cfg    Config
+	client *http.Client
+	cancel context.CancelFunc
+	done   <-chan struct{}
+	mu     sync.RWMutex
+	hosts  []string
+	slices map[string][]string
+}
+
+// NewSubscriber lists the hosts of the service and starts watching them in the background until the
+// context is cancelled or the subscriber is closed. An error is returned if the first listing fails
+func NewSubscriber(ctx context.Context, cfg Config) (*Subscriber, error) {
+	if cfg.Service == "" {
+		return nil, ErrNoService
+	}
+	if cfg.APIServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, ErrNoAPIServer
+		}
+		cfg.APIServer = "https://" + net.JoinHostPort(host, port)
+	}
+	cfg.APIServer = strings.TrimSuffix(cfg.APIServer, "/")
+	if cfg.TokenFile == "" {
+		cfg.TokenFile = DefaultTokenFile
+	}
+	if cfg.CAFile == "" {
+		cfg.CAFile = DefaultCAFile
+	}
+	if cfg.Namespace == "" {
+		cfg.Namespace = "default"
+		if ns, err := os.ReadFile(DefaultNamespaceFile); err == nil && len(ns) > 0 {
+			cfg.Namespace = strings.TrimSpace(string(ns))
+		}
+	}
+	if cfg.Scheme == "" {
+		cfg.Scheme = "http"
+	}
+	switch cfg.Resource {
+	case "":
+		cfg.Resource = ResourceEndpoints
+	case ResourceEndpoints, ResourceEndpointSlices:
+	default:
+		return nil, fmt.Errorf("kubernetes: unknown resource %s", cfg.Resource)
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = defaultRetryBackoff
+	}
+
+	client := cfg.Client
+	if client == nil {
+		var err error
+		if client, err = newClient(cfg.CAFile); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Subscriber{cfg: cfg, client: client, cancel: cancel, done: ctx.Done()}
+	version, err := s.list(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go s.run(ctx, version)
+	return s, nil
+}
+
+// Close stops watching the service, closing the connection to the API server. The last known set of
+// hosts is kept
+func (s *Subscriber) Close() error {
+	s.cancel()
+	return nil
+}
+
+// Hosts implements the sd.Subscriber interface
+func (s *Subscriber) Hosts() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.hosts, nil
+}
+
+func newClient(caFile string) (*http.Client, error) {
+	ca, err := os.ReadFile(caFile)
+	if os.IsNotExist(err) {
+		return &http.Client{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("kubernetes: no certificates found at %s", caFile)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
+}
+
+// run keeps watching the resource, listing it again when the watch can not be resumed. The last
+// known set of hosts is kept while the API server is unavailable
+func (s *Subscriber) run(ctx context.Context, version string) {
+	for {
+		var err error
+		if version == "" {
+			version, err = s.list(ctx)
+		}
+		if err == nil {
+			version, err = s.watch(ctx, version)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		if err == errGone {
+			version = ""
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.cfg.RetryBackoff):
+		}
+	}
+}
+
+func (s *Subscriber) list(ctx context.Context) (string, error) {
+	resp, err := s.get(ctx, s.path(false, ""))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch s.cfg.Resource {
+	case ResourceEndpointSlices:
+		var list endpointSliceList
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			return "", err
+		}
+		slices := make(map[string][]string, len(list.Items))
+		for _, slice := range list.Items {
+			slices[slice.Metadata.Name] = s.sliceHosts(slice)
+		}
+		s.setSlices(slices)
+		return list.Metadata.ResourceVersion, nil
+	default:
+		var list endpointsList
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			return "", err
+		}
+		hosts := []string{}
+		for _, e := range list.Items {
+			hosts = append(hosts, s.endpointsHosts(e)...)
+		}
+		s.setHosts(hosts)
+		return list.Metadata.ResourceVersion, nil
+	}
+}
+
+// watch applies the received events until the stream is closed, returning the last seen version
+func (s *Subscriber) watch(ctx context.Context, version string) (string, error) {
+	resp, err := s.get(ctx, s.path(true, version))
+	if err != nil {
+		return version, err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var e event
+		if err := decoder.Decode(&e); err != nil {
+			if err == io.EOF {
+				return version, nil
+			}
+			return version, err
+		}
+		switch e.Type {
+		case "ERROR":
+			var status status
+			json.Unmarshal(e.Object, &status)
+			if status.Code == http.StatusGone {
+				return "", errGone
+			}
+			return version, fmt.Errorf("kubernetes: watch error: %s", status.Message)
+		case "ADDED", "MODIFIED", "DELETED":
+			s.apply(e)
+			fallthrough
+		case "BOOKMARK":
+			var object struct {
+				Metadata metadata `json:"metadata"`
+			}
+			if json.Unmarshal(e.Object, &object) == nil && object.Metadata.ResourceVersion != "" {
+				version = object.Metadata.ResourceVersion
+			}
+		}
+	}
+}
+
+func (s *Subscriber) apply(e event) {
+	switch s.cfg.Resource {
+	case ResourceEndpointSlices:
+		var slice endpointSlice
+		if err := json.Unmarshal(e.Object, &slice); err != nil {
+			return
+		}
+		s.mu.RLock()
+		slices := make(map[string][]string, len(s.slices)+1)
+		for k, v := range s.slices {
+			slices[k] = v
+		}
+		s.mu.RUnlock()
+		if e.Type == "DELETED" {
+			delete(slices, slice.Metadata.Name)
+		} else {
+			slices[slice.Metadata.Name] = s.sliceHosts(slice)
+		}
+		s.setSlices(slices)
+	default:
+		var endpoints endpoints
+		if err := json.Unmarshal(e.Object, &endpoints); err != nil {
+			return
+		}
+		if e.Type == "DELETED" {
+			s.setHosts([]string{})
+			return
+		}
+		s.setHosts(s.endpointsHosts(endpoints))
+	}
+}
+
+func (s *Subscriber) setHosts(hosts []string) {
+	sort.Strings(hosts)
+	s.mu.Lock()
+	s.hosts = hosts
+	s.mu.Unlock()
+}
+
+func (s *Subscriber) setSlices(slices map[string][]string) {
+	seen := map[string]struct{}{}
+	hosts := []string{}
+	for _, sliceHosts := range slices {
+		for _, h := range sliceHosts {
+			if _, ok := seen[h]; !ok {
+				seen[h] = struct{}{}
+				hosts = append(hosts, h)
+			}
+		}
+	}
+	sort.Strings(hosts)
+	s.mu.Lock()
+	s.slices = slices
+	s.hosts = hosts
+	s.mu.Unlock()
+}
+
+func (s *Subscriber) path(watch bool, version string) string {
+	q := url.Values{}
+	var p string
+	switch s.cfg.Resource {
+	case ResourceEndpointSlices:
+		p = "/apis/discovery.k8s.io/v1/namespaces/" + s.cfg.Namespace + "/endpointslices"
+		q.Set("labelSelector", "kubernetes.io/service-name="+s.cfg.Service)
+	default:
+		p = "/api/v1/namespaces/" + s.cfg.Namespace + "/endpoints"
+		q.Set("fieldSelector", "metadata.name="+s.cfg.Service)
+	}
+	if watch {
+		q.Set("watch", "true")
+		q.Set("allowWatchBookmarks", "true")
+		if version != "" {
+			q.Set("resourceVersion", version)
+		}
+	}
+	return s.cfg.APIServer + p + "?" + q.Encode()
+}
+
+func (s *Subscriber) get(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token, err := os.ReadFile(s.cfg.TokenFile); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusGone {
+		resp.Body.Close()
+		return nil, errGone
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("kubernetes: unexpected status code %d from %s", resp.StatusCode, u)
+	}
+	return resp, nil
+}
+
+func (s *Subscriber) endpointsHosts(e endpoints) []string {
+	hosts := []string{}
+	for _, subset := range e.Subsets {
+		port, ok := s.selectPort(subset.Ports)
+		if !ok {
+			continue
+		}
+		for _, a := range subset.Addresses {
+			hosts = append(hosts, s.host(a.IP, port))
+		}
+	}
+	return hosts
+}
+
+func (s *Subscriber) sliceHosts(slice endpointSlice) []string {
+	hosts := []string{}
+	port, ok := s.selectPort(slice.Ports)
+	if !ok {
+		return hosts
+	}
+	for _, e := range slice.Endpoints {
+		if e.Conditions.Ready != nil && !*e.Conditions.Ready {
+			continue
+		}
+		for _, a := range e.Addresses {
+			hosts = append(hosts, s.host(a, port))
+		}
+	}
+	return hosts
+}
+
+func (s *Subscriber) selectPort(ports []port) (int, bool) {
+	if len(ports) == 0 {
+		return 0, false
+	}
+	if s.cfg.Port == "" {
+		return ports[0].Port, true
+	}
+	for _, p := range ports {
+		if p.Name == s.cfg.Port || strconv.Itoa(p.Port) == s.cfg.Port {
+			return p.Port, true
+		}
+	}
+	return 0, false
+}
+
+func (s *Subscriber) host(ip string, port int) string {
+	return s.cfg.Scheme + "://" + net.JoinHostPort(ip, strconv.Itoa(port))
+}
+
+type metadata struct {
+	Name            string `json:"name"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+type event struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type port struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+}
+
+type endpointsList struct {
+	Metadata metadata    `json:"metadata"`
+	Items    []endpoints `json:"items"`
+}
+
+type endpoints struct {
+	Metadata metadata `json:"metadata"`
+	Subsets  []struct {
+		Addresses []struct {
+			IP string `json:"ip"`
+		} `json:"addresses"`
+		Ports []port `json:"ports"`
+	} `json:"subsets"`
+}
+
+type endpointSliceList struct {
+	Metadata metadata        `json:"metadata"`
+	Items    []endpointSlice `json:"items"`
+}
+
+type endpointSlice struct {
+	Metadata  metadata `json:"metadata"`
+	Ports     []port   `json:"ports"`
+	Endpoints []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready *bool `json:"ready"`
+		} `json:"conditions"`
+	} `json:"endpoints"`
+}
+
+// shared holds the subscribers created by the SubscriberFactory by their settings
+var (
+	sharedMu sync.Mutex
+	shared   = map[Config]*Subscriber{}
+)
+
+// SubscriberFactory returns a subscriber for the backend, reading the settings from its sd_config
+// section. The backends with the same settings share a single subscriber, so building the proxies
+// again does not open new watches. The shared subscriber watches the service until it is closed,
+// and a new one is created for the next backends once it is. It can be registered in the proxy
+// package in order to be selected from the config:
+//
+//	proxy.RegisterSubscriber("kubernetes", kubernetes.SubscriberFactory)
+func SubscriberFactory(remote *config.Backend) (sd.Subscriber, error) {
+	get := func(key string) string {
+		if v, ok := remote.SDConfig[key]; ok && v != nil {
+			return fmt.Sprint(v)
+		}
+		return ""
+	}
+	cfg := Config{
+		APIServer: get("api_server"),
+		Namespace: get("namespace"),
+		Service:   get("service"),
+		Port:      get("port"),
+		Scheme:    get("scheme"),
+		Resource:  get("resource"),
+		TokenFile: get("token_file"),
+		CAFile:    get("ca_file"),
+	}
+	if backoff := get("retry_backoff"); backoff != "" {
+		d, err := time.ParseDuration(backoff)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RetryBackoff = d
+	}
+
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if s, ok := shared[cfg]; ok {
+		select {
+		case <-s.done:
+		default:
+			return s, nil
+		}
+	}
+	s, err := NewSubscriber(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	shared[cfg] = s
+	return s, nil
+}
@@ -0,0 +1,297 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ph0m1/porta/config"
+)
+
+type fakeAPIServer struct {
+	mu       sync.Mutex
+	list     string
+	events   chan string
+	done     chan struct{}
+	lists    int
+	watches  []string
+	active   int
+	authz    []string
+	resource string
+}
+
+func newFakeAPIServer(resource, list string) *fakeAPIServer {
+	return &fakeAPIServer{list: list, events: make(chan string, 10), done: make(chan struct{}), resource: resource}
+}
+
+func (f *fakeAPIServer) Close() {
+	close(f.done)
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.authz = append(f.authz, r.Header.Get("Authorization"))
+	f.mu.Unlock()
+
+	if r.URL.Path != f.resource {
+		http.NotFound(w, r)
+		return
+	}
+	if r.URL.Query().Get("watch") != "true" {
+		f.mu.Lock()
+		f.lists++
+		list := f.list
+		f.mu.Unlock()
+		w.Write([]byte(list))
+		return
+	}
+
+	f.mu.Lock()
+	f.watches = append(f.watches, r.URL.Query().Get("resourceVersion"))
+	f.active++
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.active--
+		f.mu.Unlock()
+	}()
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-f.done:
+			return
+		case e := <-f.events:
+			fmt.Fprintln(w, e)
+			w.(http.Flusher).Flush()
+			if strings.Contains(e, `"type":"ERROR"`) {
+				return
+			}
+		}
+	}
+}
+
+func (f *fakeAPIServer) stats() (int, []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lists, append([]string{}, f.watches...)
+}
+
+// waitForWatches waits until the number of watches in progress is the expected one
+func (f *fakeAPIServer) waitForWatches(t *testing.T, want int) {
+	t.Helper()
+	var active int
+	for i := 0; i < 100; i++ {
+		f.mu.Lock()
+		active = f.active
+		f.mu.Unlock()
+		if active == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("want %d watches, have %d", want, active)
+}
+
+func waitForHosts(t *testing.T, s *Subscriber, want ...string) {
+	t.Helper()
+	var hosts []string
+	for i := 0; i < 100; i++ {
+		hosts, _ = s.Hosts()
+		if fmt.Sprint(hosts) == fmt.Sprint(want) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("want hosts %v, have %v", want, hosts)
+}
+
+func TestSubscriber_endpoints(t *testing.T) {
+	api := newFakeAPIServer("/api/v1/namespaces/supu/endpoints", `{"metadata":{"resourceVersion":"10"},"items":[
+		{"metadata":{"name":"tupu","resourceVersion":"9"},"subsets":[{"addresses":[{"ip":"10.0.0.2"},{"ip":"10.0.0.1"}],"ports":[{"name":"metrics","port":9090},{"name":"http","port":8080}]}]}
+	]}`)
+	server := httptest.NewServer(api)
+	defer server.Close()
+	defer api.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscriber, err := NewSubscriber(ctx, Config{
+		APIServer:    server.URL,
+		Namespace:    "supu",
+		Service:      "tupu",
+		Port:         "http",
+		TokenFile:    "/nowhere/token",
+		CAFile:       "/nowhere/ca.crt",
+		RetryBackoff: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForHosts(t, subscriber, "http://10.0.0.1:8080", "http://10.0.0.2:8080")
+
+	api.events <- `{"type":"MODIFIED","object":{"metadata":{"name":"tupu","resourceVersion":"11"},"subsets":[{"addresses":[{"ip":"10.0.0.3"}],"ports":[{"name":"http","port":8080}]}]}}`
+	waitForHosts(t, subscriber, "http://10.0.0.3:8080")
+
+	api.events <- `{"type":"BOOKMARK","object":{"metadata":{"resourceVersion":"12"}}}`
+	api.events <- `{"type":"ERROR","object":{"kind":"Status","code":500,"message":"boom"}}`
+	api.mu.Lock()
+	api.list = `{"metadata":{"resourceVersion":"20"},"items":[]}`
+	api.mu.Unlock()
+	api.events <- `{"type":"ERROR","object":{"kind":"Status","code":410,"message":"too old"}}`
+	waitForHosts(t, subscriber)
+
+	lists, watches := api.stats()
+	if lists != 2 {
+		t.Errorf("unexpected number of lists: %d", lists)
+	}
+	if fmt.Sprint(watches) != fmt.Sprint([]string{"10", "12", "20"}) {
+		t.Errorf("unexpected watch versions: %v", watches)
+	}
+
+	api.events <- `{"type":"ADDED","object":{"metadata":{"name":"tupu","resourceVersion":"21"},"subsets":[{"addresses":[{"ip":"10.0.0.4"}],"ports":[{"name":"metrics","port":9090}]}]}}`
+	api.events <- `{"type":"MODIFIED","object":{"metadata":{"name":"tupu","resourceVersion":"22"},"subsets":[{"addresses":[{"ip":"fd00::1"}],"ports":[{"name":"http","port":8080}]}]}}`
+	waitForHosts(t, subscriber, "http://[fd00::1]:8080")
+
+	api.events <- `{"type":"DELETED","object":{"metadata":{"name":"tupu","resourceVersion":"23"}}}`
+	waitForHosts(t, subscriber)
+}
+
+func TestSubscriber_endpointSlices(t *testing.T) {
+	api := newFakeAPIServer("/apis/discovery.k8s.io/v1/namespaces/default/endpointslices", `{"metadata":{"resourceVersion":"1"},"items":[
+		{"metadata":{"name":"tupu-a"},"ports":[{"name":"http","port":8080}],"endpoints":[
+			{"addresses":["10.0.0.1"],"conditions":{"ready":true}},
+			{"addresses":["10.0.0.2"],"conditions":{"ready":false}}
+		]},
+		{"metadata":{"name":"tupu-b"},"ports":[{"name":"http","port":8080}],"endpoints":[{"addresses":["10.0.0.3"]}]}
+	]}`)
+	server := httptest.NewServer(api)
+	defer server.Close()
+	defer api.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscriber, err := NewSubscriber(ctx, Config{
+		APIServer: server.URL,
+		Namespace: "default",
+		Service:   "tupu",
+		Port:      "8080",
+		Scheme:    "https",
+		Resource:  ResourceEndpointSlices,
+		TokenFile: "/nowhere/token",
+		CAFile:    "/nowhere/ca.crt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForHosts(t, subscriber, "https://10.0.0.1:8080", "https://10.0.0.3:8080")
+
+	api.events <- `{"type":"MODIFIED","object":{"metadata":{"name":"tupu-a","resourceVersion":"2"},"ports":[{"name":"http","port":8080}],"endpoints":[{"addresses":["10.0.0.2"],"conditions":{"ready":true}}]}}`
+	waitForHosts(t, subscriber, "https://10.0.0.2:8080", "https://10.0.0.3:8080")
+
+	api.events <- `{"type":"DELETED","object":{"metadata":{"name":"tupu-b","resourceVersion":"3"}}}`
+	waitForHosts(t, subscriber, "https://10.0.0.2:8080")
+}
+
+func TestSubscriber_inClusterCredentials(t *testing.T) {
+	api := newFakeAPIServer("/api/v1/namespaces/supu/endpoints", `{"metadata":{"resourceVersion":"1"},"items":[
+		{"metadata":{"name":"tupu"},"subsets":[{"addresses":[{"ip":"10.0.0.1"}],"ports":[{"port":80}]}]}
+	]}`)
+	server := httptest.NewTLSServer(api)
+	defer server.Close()
+	defer api.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	tokenFile := filepath.Join(dir, "token")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tokenFile, []byte("secret-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := &config.Backend{
+		SD: "kubernetes",
+		SDConfig: map[string]interface{}{
+			"api_server": server.URL,
+			"namespace":  "supu",
+			"service":    "tupu",
+			"token_file": tokenFile,
+			"ca_file":    caFile,
+		},
+	}
+	subscriber, err := SubscriberFactory(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForHosts(t, subscriber.(*Subscriber), "http://10.0.0.1:80")
+
+	api.mu.Lock()
+	authz := api.authz[0]
+	api.mu.Unlock()
+	if authz != "Bearer secret-token" {
+		t.Errorf("unexpected authorization header: %s", authz)
+	}
+
+	// the backends watching the same service share the subscriber, so building the proxies again
+	// does not open new watches
+	for i := 0; i < 3; i++ {
+		s, err := SubscriberFactory(backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != subscriber {
+			t.Error("the subscriber should be shared")
+		}
+	}
+	api.waitForWatches(t, 1)
+
+	// closing the subscriber stops the watch
+	subscriber.(io.Closer).Close()
+	api.waitForWatches(t, 0)
+	waitForHosts(t, subscriber.(*Subscriber), "http://10.0.0.1:80")
+
+	// the closed subscribers are replaced
+	s, err := SubscriberFactory(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s == subscriber {
+		t.Error("the closed subscriber should not be shared")
+	}
+	api.waitForWatches(t, 1)
+	s.(io.Closer).Close()
+	api.waitForWatches(t, 0)
+
+	if _, err := NewSubscriber(context.Background(), Config{APIServer: server.URL, Service: "tupu", CAFile: "/nowhere/ca.crt"}); err == nil {
+		t.Error("the subscriber should not trust the server without the CA bundle")
+	}
+}
+
+func TestNewSubscriber_ko(t *testing.T) {
+	os.Unsetenv("KUBERNETES_SERVICE_HOST")
+	if _, err := NewSubscriber(context.Background(), Config{}); err != ErrNoService {
+		t.Errorf("want %v, have %v", ErrNoService, err)
+	}
+	if _, err := NewSubscriber(context.Background(), Config{Service: "tupu"}); err != ErrNoAPIServer {
+		t.Errorf("want %v, have %v", ErrNoAPIServer, err)
+	}
+	if _, err := NewSubscriber(context.Background(), Config{Service: "tupu", APIServer: "http://127.0.0.1", Resource: "pods"}); err == nil {
+		t.Error("error expected with an unknown resource")
+	}
+}
@@ -0,0 +1,60 @@
+package sd
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// NewLeastConnectionsLB returns a balancer selecting the host with the fewest outstanding requests.
+// Ties are resolved in a round robin fashion. The returned balancer implements the Tracker interface
+func NewLeastConnectionsLB(subscriber Subscriber) Balancer {
+	return &leastConnectionsLB{
+		subscriber:  subscriber,
+		outstanding: map[string]int{},
+	}
+}
+
+type leastConnectionsLB struct {
+	subscriber  Subscriber
+	mu          sync.Mutex
+	counter     int
+	outstanding map[string]int
+}
+
+func (l *leastConnectionsLB) Host(_ context.Context) (string, error) {
+	hosts, err := l.subscriber.Hosts()
+	if err != nil {
+		return "", err
+	}
+	if len(hosts) <= 0 {
+		return "", ErrNoHosts
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	offset := l.counter % len(hosts)
+	l.counter++
+
+	selected := hosts[offset]
+	for i := 1; i < len(hosts); i++ {
+		h := hosts[(offset+i)%len(hosts)]
+		if l.outstanding[h] < l.outstanding[selected] {
+			selected = h
+		}
+	}
+	l.outstanding[selected]++
+	return selected, nil
+}
+
+// Release implements the Tracker interface
+func (l *leastConnectionsLB) Release(host string, _ time.Duration, _ error) {
+	l.mu.Lock()
+	if v := l.outstanding[host]; v > 1 {
+		l.outstanding[host] = v - 1
+	} else {
+		delete(l.outstanding, host)
+	}
+	l.mu.Unlock()
+}
@@ -1,13 +1,26 @@
 package sd
 
 import (
+	"context"
 	"errors"
 	"math/rand"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
+// Balancer selects the host the next request should be sent to. The received context carries the
+// request scoped values the balancer may require, like the hash key
 type Balancer interface {
-	Host() (string, error)
+	Host(ctx context.Context) (string, error)
+}
+
+// Tracker is implemented by the balancers that need feedback about the requests sent to the selected
+// hosts. Release must be called once per successful call to Host, when the request is completed,
+// with the error of the host, if any. The errors caused by the request itself must not be reported,
+// so a client sending bad requests can not make a healthy host look broken
+type Tracker interface {
+	Release(host string, latency time.Duration, err error)
 }
 
 var ErrNoHosts = errors.New("no hosts available")
@@ -24,7 +37,7 @@ type roundRobinLB struct {
 	counter    uint64
 }
 
-func (rr *roundRobinLB) Host() (string, error) {
+func (rr *roundRobinLB) Host(_ context.Context) (string, error) {
 	hosts, err := rr.subscriber.Hosts()
 	if err != nil {
 		return "", err
@@ -39,16 +52,16 @@ func (rr *roundRobinLB) Host() (string, error) {
 func NewRandomLB(subscriber Subscriber, seed int64) Balancer {
 	return &randomLB{
 		subscriber: subscriber,
-		rnd:        rand.New(rand.NewSource(seed)),
+		rnd:        newLockedRand(seed),
 	}
 }
 
 type randomLB struct {
 	subscriber Subscriber
-	rnd        *rand.Rand
+	rnd        *lockedRand
 }
 
-func (r *randomLB) Host() (string, error) {
+func (r *randomLB) Host(_ context.Context) (string, error) {
 	hosts, err := r.subscriber.Hosts()
 	if err != nil {
 		return "", err
@@ -58,3 +71,20 @@ func (r *randomLB) Host() (string, error) {
 	}
 	return hosts[r.rnd.Intn(len(hosts))], nil
 }
+
+// lockedRand is a goroutine-safe wrapper over a rand.Rand
+type lockedRand struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func newLockedRand(seed int64) *lockedRand {
+	return &lockedRand{rnd: rand.New(rand.NewSource(seed))}
+}
+
+func (r *lockedRand) Intn(n int) int {
+	r.mu.Lock()
+	v := r.rnd.Intn(n)
+	r.mu.Unlock()
+	return v
+}
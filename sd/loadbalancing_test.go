@@ -1,9 +1,13 @@
 package sd
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"math"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestRoundRobinLB(t *testing.T) {
@@ -22,7 +26,7 @@ func TestRoundRobinLB(t *testing.T) {
 	balancer := NewRoundRobinLB(subscriber)
 
 	for i := 0; i < iterations; i++ {
-		endpoint, err := balancer.Host()
+		endpoint, err := balancer.Host(context.Background())
 		if err != nil {
 			t.Fail()
 		}
@@ -42,7 +46,7 @@ func TestRoundRobinLB_noEndpoints(t *testing.T) {
 	subscriber := FixedSubscriber{}
 	balancer := NewRoundRobinLB(subscriber)
 
-	_, err := balancer.Host()
+	_, err := balancer.Host(context.Background())
 	if want, have := ErrNoHosts, err; want != have {
 		t.Errorf("want %v, have %v", want, have)
 	}
@@ -67,7 +71,7 @@ func TestRandomLB(t *testing.T) {
 	balancer := NewRandomLB(subscriber, seed)
 
 	for i := 0; i < iterations; i++ {
-		endpoint, err := balancer.Host()
+		endpoint, err := balancer.Host(context.Background())
 		if err != nil {
 			t.Fail()
 		}
@@ -85,7 +89,7 @@ func TestRandomLB_noEndpoints(t *testing.T) {
 	subscriber := FixedSubscriber{}
 	balancer := NewRandomLB(subscriber, 34567)
 
-	_, err := balancer.Host()
+	_, err := balancer.Host(context.Background())
 	if want, have := ErrNoHosts, err; want != have {
 		t.Errorf("want %s, have %s", want, have)
 	}
@@ -100,7 +104,7 @@ func (s erroredSubscriber) Hosts() ([]string, error) {
 func TestRoundRobinLB_erroredSubsciber(t *testing.T) {
 	want := "supu"
 	balancer := NewRoundRobinLB(erroredSubscriber(want))
-	host, have := balancer.Host()
+	host, have := balancer.Host(context.Background())
 	if host != "" || want != have.Error() {
 		t.Errorf("want %s, have %s", want, have.Error())
 	}
@@ -109,8 +113,341 @@ func TestRoundRobinLB_erroredSubsciber(t *testing.T) {
 func TestRandomLB_erroredSubscriber(t *testing.T) {
 	want := "supu"
 	balancer := NewRandomLB(erroredSubscriber(want), 1415926)
-	host, have := balancer.Host()
+	host, have := balancer.Host(context.Background())
 	if host != "" || want != have.Error() {
 		t.Errorf("want %s, have %s", want, have.Error())
 	}
 }
+
+func TestWeightedRoundRobinLB(t *testing.T) {
+	endpoints := []string{"a", "b", "c"}
+	weights := map[string]int{"a": 5, "b": 1, "c": 0}
+	balancer := NewWeightedRoundRobinLB(FixedSubscriber(endpoints), weights)
+
+	counts := map[string]int{}
+	for i := 0; i < 6000; i++ {
+		endpoint, err := balancer.Host(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[endpoint]++
+	}
+	if counts["a"] != 5000 || counts["b"] != 1000 || counts["c"] != 0 {
+		t.Errorf("unexpected distribution: %v", counts)
+	}
+
+	expected := []string{"a", "a", "a", "b", "a", "a"}
+	balancer = NewWeightedRoundRobinLB(FixedSubscriber(endpoints), weights)
+	for i, want := range expected {
+		if have, _ := balancer.Host(context.Background()); have != want {
+			t.Errorf("%d: want %s, have %s", i, want, have)
+		}
+	}
+}
+
+func TestWeightedRoundRobinLB_noEndpoints(t *testing.T) {
+	balancer := NewWeightedRoundRobinLB(FixedSubscriber{}, nil)
+	if _, err := balancer.Host(context.Background()); err != ErrNoHosts {
+		t.Errorf("want %v, have %v", ErrNoHosts, err)
+	}
+
+	balancer = NewWeightedRoundRobinLB(FixedSubscriber{"a"}, map[string]int{"a": 0})
+	if _, err := balancer.Host(context.Background()); err != ErrNoHosts {
+		t.Errorf("want %v, have %v", ErrNoHosts, err)
+	}
+}
+
+func TestLeastConnectionsLB(t *testing.T) {
+	balancer := NewLeastConnectionsLB(FixedSubscriber([]string{"a", "b", "c"}))
+	tracker := balancer.(Tracker)
+
+	for i, want := range []string{"a", "b", "c", "a"} {
+		if have, _ := balancer.Host(context.Background()); have != want {
+			t.Errorf("%d: want %s, have %s", i, want, have)
+		}
+	}
+	tracker.Release("b", time.Millisecond, nil)
+	if have, _ := balancer.Host(context.Background()); have != "b" {
+		t.Errorf("want b, have %s", have)
+	}
+	tracker.Release("a", time.Millisecond, nil)
+	tracker.Release("a", time.Millisecond, nil)
+	if have, _ := balancer.Host(context.Background()); have != "a" {
+		t.Errorf("want a, have %s", have)
+	}
+}
+
+func TestLeastConnectionsLB_concurrent(t *testing.T) {
+	balancer := NewLeastConnectionsLB(FixedSubscriber([]string{"a", "b", "c"}))
+	tracker := balancer.(Tracker)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				host, err := balancer.Host(context.Background())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				tracker.Release(host, 0, nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if outstanding := balancer.(*leastConnectionsLB).outstanding; len(outstanding) != 0 {
+		t.Errorf("unexpected outstanding requests: %v", outstanding)
+	}
+}
+
+func TestP2CEWMALB(t *testing.T) {
+	balancer := NewP2CEWMALB(FixedSubscriber([]string{"fast", "slow"}), 1234, time.Second)
+	tracker := balancer.(Tracker)
+
+	tracker.Release("fast", time.Millisecond, nil)
+	tracker.Release("slow", 100*time.Millisecond, nil)
+
+	for i := 0; i < 100; i++ {
+		host, err := balancer.Host(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host != "fast" {
+			t.Errorf("%d: want fast, have %s", i, host)
+		}
+		tracker.Release(host, time.Millisecond, nil)
+	}
+
+	tracker.Release("fast", 0, errors.New("boom"))
+	if host, _ := balancer.Host(context.Background()); host != "slow" {
+		t.Errorf("failed host not penalized, have %s", host)
+	}
+}
+
+func TestP2CEWMALB_distribution(t *testing.T) {
+	endpoints := []string{"a", "b", "c", "d"}
+	balancer := NewP2CEWMALB(FixedSubscriber(endpoints), 34567, 0)
+	tracker := balancer.(Tracker)
+
+	counts := map[string]int{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				host, err := balancer.Host(context.Background())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				counts[host]++
+				mu.Unlock()
+				tracker.Release(host, time.Millisecond, nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, e := range endpoints {
+		if counts[e] == 0 {
+			t.Errorf("host %s never selected: %v", e, counts)
+		}
+	}
+}
+
+func TestP2CEWMALB_removedHosts(t *testing.T) {
+	subscriber := FixedSubscriber{"a", "b", "c", "d", "e"}
+	balancer := NewP2CEWMALB(&subscriber, 1, time.Second).(*p2cEWMALB)
+	for i := 0; i < 50; i++ {
+		host, _ := balancer.Host(context.Background())
+		balancer.Release(host, time.Millisecond, nil)
+	}
+
+	// the stats of the hosts removed by the service discovery are dropped
+	subscriber = FixedSubscriber{"f", "g"}
+	for i := 0; i < 10; i++ {
+		host, _ := balancer.Host(context.Background())
+		balancer.Release(host, time.Millisecond, nil)
+	}
+	for host := range balancer.stats {
+		if host != "f" && host != "g" {
+			t.Errorf("the stats of the removed host %s were kept", host)
+		}
+	}
+}
+
+func TestP2CEWMALB_noEndpoints(t *testing.T) {
+	balancer := NewP2CEWMALB(FixedSubscriber{}, 1, time.Second)
+	if _, err := balancer.Host(context.Background()); err != ErrNoHosts {
+		t.Errorf("want %v, have %v", ErrNoHosts, err)
+	}
+}
+
+func TestRandomLB_concurrent(t *testing.T) {
+	balancer := NewRandomLB(FixedSubscriber([]string{"a", "b", "c"}), 1)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if _, err := balancer.Host(context.Background()); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConsistentHashLB(t *testing.T) {
+	endpoints := []string{"a", "b", "c", "d", "e"}
+	balancer := NewConsistentHashLB(FixedSubscriber(endpoints), 0)
+
+	assigned := map[string]string{}
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		host, err := balancer.Host(NewContextWithHashKey(context.Background(), key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again, _ := balancer.Host(NewContextWithHashKey(context.Background(), key)); again != host {
+			t.Errorf("key %s moved from %s to %s", key, host, again)
+		}
+		assigned[key] = host
+		counts[host]++
+	}
+	for _, e := range endpoints {
+		if counts[e] < 1000 || counts[e] > 3000 {
+			t.Errorf("unbalanced ring: %v", counts)
+			break
+		}
+	}
+
+	balancer = NewConsistentHashLB(FixedSubscriber(endpoints[:4]), 0)
+	moved := 0
+	for key, host := range assigned {
+		have, _ := balancer.Host(NewContextWithHashKey(context.Background(), key))
+		if host != "e" && have != host {
+			moved++
+		}
+	}
+	if moved != 0 {
+		t.Errorf("%d keys moved after removing an unrelated host", moved)
+	}
+}
+
+func TestConsistentHashLB_noKey(t *testing.T) {
+	endpoints := []string{"a", "b", "c"}
+	balancer := NewConsistentHashLB(FixedSubscriber(endpoints), 10)
+	for i := 0; i < 6; i++ {
+		host, err := balancer.Host(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := endpoints[i%len(endpoints)]; host != want {
+			t.Errorf("%d: want %s, have %s", i, want, host)
+		}
+	}
+}
+
+func TestConsistentHashLB_noEndpoints(t *testing.T) {
+	balancer := NewConsistentHashLB(FixedSubscriber{}, 10)
+	if _, err := balancer.Host(NewContextWithHashKey(context.Background(), "supu")); err != ErrNoHosts {
+		t.Errorf("want %v, have %v", ErrNoHosts, err)
+	}
+}
+
+func TestTieredLB(t *testing.T) {
+	tiers := []Tier{
+		{Name: "local", Subscriber: FixedSubscriber{"a", "b"}},
+		{Name: "remote", Subscriber: FixedSubscriber{"c"}},
+	}
+	balancer, err := NewTieredLB(tiers, func(s Subscriber) (Balancer, error) { return NewRoundRobinLB(s), nil }, 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := balancer.(Tracker)
+	reporter := balancer.(TierReporter)
+	now := time.Now()
+	balancer.(*tieredLB).now = func() time.Time { return now }
+
+	assertTier := func(want string, hosts ...string) {
+		t.Helper()
+		for i := 0; i < 4; i++ {
+			host, err := balancer.Host(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tier := reporter.Tier(host); tier != want {
+				t.Errorf("want tier %s, have %s (host %s)", want, tier, host)
+			}
+			if !contains(hosts, host) {
+				t.Errorf("unexpected host %s, want one of %v", host, hosts)
+			}
+		}
+	}
+
+	assertTier("local", "a", "b")
+
+	for i := 0; i < 2; i++ {
+		tracker.Release("a", time.Millisecond, errors.New("boom"))
+	}
+	assertTier("local", "b")
+
+	tracker.Release("b", time.Millisecond, errors.New("boom"))
+	tracker.Release("b", time.Millisecond, nil)
+	tracker.Release("b", time.Millisecond, errors.New("boom"))
+	assertTier("local", "b")
+
+	tracker.Release("b", time.Millisecond, errors.New("boom"))
+	assertTier("remote", "c")
+
+	tracker.Release("c", time.Millisecond, errors.New("boom"))
+	tracker.Release("c", time.Millisecond, errors.New("boom"))
+	assertTier("local", "a", "b")
+
+	now = now.Add(time.Minute)
+	tracker.Release("a", time.Millisecond, context.Canceled)
+	tracker.Release("a", time.Millisecond, context.Canceled)
+	assertTier("local", "a", "b")
+}
+
+func TestTieredLB_noHosts(t *testing.T) {
+	tiers := []Tier{
+		{Name: "local", Subscriber: FixedSubscriber{}},
+		{Name: "remote", Subscriber: erroredSubscriber("supu")},
+	}
+	balancer, err := NewTieredLB(tiers, func(s Subscriber) (Balancer, error) { return NewRoundRobinLB(s), nil }, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := balancer.Host(context.Background()); err != ErrNoHosts {
+		t.Errorf("want %v, have %v", ErrNoHosts, err)
+	}
+}
+
+func TestTieredLB_factoryError(t *testing.T) {
+	want := errors.New("supu")
+	_, err := NewTieredLB([]Tier{{Name: "local", Subscriber: FixedSubscriber{"a"}}}, func(s Subscriber) (Balancer, error) { return nil, want }, 0, 0)
+	if err != want {
+		t.Errorf("want %v, have %v", want, err)
+	}
+}
+
+func contains(set []string, v string) bool {
+	for _, s := range set {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
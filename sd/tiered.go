@@ -0,0 +1,181 @@
+package sd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxFails is the number of consecutive failures marking a host as unhealthy when none is provided
+	DefaultMaxFails = 3
+	// DefaultFailTimeout is the time an unhealthy host is kept out of the rotation when none is provided
+	DefaultFailTimeout = 10 * time.Second
+)
+
+// Tier is a named group of hosts sharing the same priority
+type Tier struct {
+	Name       string
+	Subscriber Subscriber
+}
+
+// TierReporter is implemented by the balancers grouping their hosts in tiers
+type TierReporter interface {
+	Tier(host string) string
+}
+
+// NewTieredLB returns a balancer sending the requests to the first tier with healthy hosts, so the
+// next tiers are only used when the previous ones have no healthy hosts. The hosts of every tier are
+// distributed by a dedicated balancer created with the received factory.
+//
+// A host is considered unhealthy for failTimeout after maxFails consecutive failed requests. When no
+// tier has healthy hosts, the first tier with hosts is used with all of them, so the gateway keeps
+// trying instead of failing every request. The returned balancer implements the Tracker and the
+// TierReporter interfaces
+func NewTieredLB(tiers []Tier, lbFactory func(Subscriber) (Balancer, error), maxFails int, failTimeout time.Duration) (Balancer, error) {
+	if maxFails <= 0 {
+		maxFails = DefaultMaxFails
+	}
+	if failTimeout <= 0 {
+		failTimeout = DefaultFailTimeout
+	}
+	t := &tieredLB{
+		tiers:       make([]tier, len(tiers)),
+		maxFails:    maxFails,
+		failTimeout: failTimeout,
+		hosts:       map[string]*hostHealth{},
+		now:         time.Now,
+	}
+	for i, tr := range tiers {
+		lb, err := lbFactory(healthySubscriber{tr.Subscriber, t})
+		if err != nil {
+			return nil, err
+		}
+		t.tiers[i] = tier{Tier: tr, lb: lb}
+	}
+	return t, nil
+}
+
+type tieredLB struct {
+	tiers       []tier
+	maxFails    int
+	failTimeout time.Duration
+	mu          sync.Mutex
+	hosts       map[string]*hostHealth
+	owners      sync.Map
+	now         func() time.Time
+}
+
+type tier struct {
+	Tier
+	lb Balancer
+}
+
+type hostHealth struct {
+	failures     int
+	ejectedUntil time.Time
+}
+
+func (t *tieredLB) Host(ctx context.Context) (string, error) {
+	fallback := -1
+	for i, tr := range t.tiers {
+		hosts, err := tr.Subscriber.Hosts()
+		if err != nil || len(hosts) == 0 {
+			continue
+		}
+		if fallback == -1 {
+			fallback = i
+		}
+		if len(t.healthy(hosts)) > 0 {
+			return t.selectFrom(ctx, i)
+		}
+	}
+	if fallback == -1 {
+		return "", ErrNoHosts
+	}
+	return t.selectFrom(ctx, fallback)
+}
+
+func (t *tieredLB) selectFrom(ctx context.Context, i int) (string, error) {
+	host, err := t.tiers[i].lb.Host(ctx)
+	if err != nil {
+		return "", err
+	}
+	t.owners.Store(host, i)
+	return host, nil
+}
+
+// Tier implements the TierReporter interface
+func (t *tieredLB) Tier(host string) string {
+	if i, ok := t.owners.Load(host); ok {
+		return t.tiers[i.(int)].Name
+	}
+	return ""
+}
+
+// Release implements the Tracker interface
+func (t *tieredLB) Release(host string, latency time.Duration, err error) {
+	if i, ok := t.owners.Load(host); ok {
+		if tracker, ok := t.tiers[i.(int)].lb.(Tracker); ok {
+			tracker.Release(host, latency, err)
+		}
+	}
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h, ok := t.hosts[host]
+	if !ok {
+		if err == nil {
+			return
+		}
+		h = &hostHealth{}
+		t.hosts[host] = h
+	}
+	if err == nil {
+		delete(t.hosts, host)
+		return
+	}
+	h.failures++
+	if h.failures >= t.maxFails {
+		h.failures = 0
+		h.ejectedUntil = t.now().Add(t.failTimeout)
+	}
+}
+
+func (t *tieredLB) healthy(hosts []string) []string {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if h, ok := t.hosts[host]; ok && now.Before(h.ejectedUntil) {
+			continue
+		}
+		res = append(res, host)
+	}
+	return res
+}
+
+// healthySubscriber filters the unhealthy hosts of the wrapped subscriber. All the hosts are returned
+// when none of them is healthy
+type healthySubscriber struct {
+	subscriber Subscriber
+	lb         *tieredLB
+}
+
+func (h healthySubscriber) Hosts() ([]string, error) {
+	hosts, err := h.subscriber.Hosts()
+	if err != nil {
+		return hosts, err
+	}
+	if healthy := h.lb.healthy(hosts); len(healthy) > 0 {
+		return healthy, nil
+	}
+	return hosts, nil
+}
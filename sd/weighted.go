@@ -0,0 +1,66 @@
+package sd
+
+import (
+	"context"
+	"sync"
+)
+
+// NewWeightedRoundRobinLB returns a smooth weighted round robin balancer. Hosts missing from the
+// weights map get a weight of 1 and hosts with a weight of 0 never get selected
+func NewWeightedRoundRobinLB(subscriber Subscriber, weights map[string]int) Balancer {
+	return &weightedRoundRobinLB{
+		subscriber: subscriber,
+		weights:    weights,
+		current:    map[string]int{},
+	}
+}
+
+type weightedRoundRobinLB struct {
+	subscriber Subscriber
+	weights    map[string]int
+	mu         sync.Mutex
+	current    map[string]int
+}
+
+func (w *weightedRoundRobinLB) Host(_ context.Context) (string, error) {
+	hosts, err := w.subscriber.Hosts()
+	if err != nil {
+		return "", err
+	}
+	if len(hosts) <= 0 {
+		return "", ErrNoHosts
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.current) > len(hosts) {
+		w.current = make(map[string]int, len(hosts))
+	}
+
+	total := 0
+	selected := ""
+	for _, h := range hosts {
+		weight := w.weight(h)
+		if weight <= 0 {
+			continue
+		}
+		w.current[h] += weight
+		total += weight
+		if selected == "" || w.current[h] > w.current[selected] {
+			selected = h
+		}
+	}
+	if selected == "" {
+		return "", ErrNoHosts
+	}
+	w.current[selected] -= total
+	return selected, nil
+}
+
+func (w *weightedRoundRobinLB) weight(host string) int {
+	if v, ok := w.weights[host]; ok {
+		return v
+	}
+	return 1
+}